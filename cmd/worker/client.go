@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/services"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// errLeaseLost 表示服务器已收回任务的租约，节点应放弃该任务
+var errLeaseLost = errors.New("job lease lost")
+
+// workerClient 实现节点与API服务器之间基于HTTP拉取的协议
+type workerClient struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// leasedJob 是租用任务接口的响应
+type leasedJob struct {
+	JobID          uint            `json:"job_id"`
+	Type           string          `json:"type"`
+	Payload        json.RawMessage `json:"payload"`
+	Inputs         []string        `json:"inputs"`
+	Artifacts      []string        `json:"artifacts"`
+	LeaseExpiresAt time.Time       `json:"lease_expires_at"`
+	Attempt        int             `json:"attempt"`
+	MaxAttempts    int             `json:"max_attempts"`
+	LogOffset      int64           `json:"log_offset"`
+}
+
+func newWorkerClient(server, token string) *workerClient {
+	return &workerClient{server: server, token: token, http: &http.Client{}}
+}
+
+// register 使用注册密钥注册节点，返回节点令牌
+func (wc *workerClient) register(name, secret string, jobTypes []string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	body := map[string]interface{}{"name": name, "secret": secret, "job_types": jobTypes}
+	if err := wc.doJSON(http.MethodPost, "/worker/register", body, &resp); err != nil {
+		return "", fmt.Errorf("fail to register worker: %w", err)
+	}
+	return resp.Token, nil
+}
+
+// lease 租用一个任务，没有可执行的任务时返回nil
+func (wc *workerClient) lease() (*leasedJob, error) {
+	var job leasedJob
+	if err := wc.doJSON(http.MethodPost, "/worker/jobs/lease", nil, &job); err != nil {
+		return nil, fmt.Errorf("fail to lease job: %w", err)
+	}
+	if job.JobID == 0 {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+// heartbeat 延长任务的租约
+func (wc *workerClient) heartbeat(jobID uint) error {
+	return wc.doJSON(http.MethodPost, wc.jobPath(jobID, "heartbeat"), nil, nil)
+}
+
+// download 将任务的输入文件下载到path
+func (wc *workerClient) download(jobID uint, name, path string) error {
+	resp, err := wc.do(http.MethodGet, wc.jobPath(jobID, "inputs/"+name), nil, "")
+	if err != nil {
+		return fmt.Errorf("fail to download %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create %s: %w", path, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("fail to download %s: %w", name, err)
+	}
+	return nil
+}
+
+// upload 上传任务的产物文件
+func (wc *workerClient) upload(jobID uint, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("fail to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	resp, err := wc.do(http.MethodPut, wc.jobPath(jobID, "artifacts/"+name), file, "application/octet-stream")
+	if err != nil {
+		return fmt.Errorf("fail to upload %s: %w", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// jobLogSink 将任务日志上传到服务器，实现 services.JobLogSink
+type jobLogSink struct {
+	client *workerClient
+	jobID  uint
+}
+
+func (s jobLogSink) StoreSegment(offset int64, data []byte) error {
+	path := s.client.jobPath(s.jobID, "log/"+strconv.FormatInt(offset, 10))
+	resp, err := s.client.do(http.MethodPut, path, bytes.NewReader(data), "text/plain")
+	if err != nil {
+		return fmt.Errorf("fail to upload log segment: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s jobLogSink) UpdateTail(tail []byte, size int64) error {
+	body := map[string]interface{}{"tail": tail, "size": size}
+	if err := s.client.doJSON(http.MethodPost, s.client.jobPath(s.jobID, "log"), body, nil); err != nil {
+		return fmt.Errorf("fail to update log tail: %w", err)
+	}
+	return nil
+}
+
+// report 报告任务的执行结果与训练器子进程的资源使用
+func (wc *workerClient) report(jobID uint, jobErr error, usage services.ResourceUsage) error {
+	body := map[string]interface{}{
+		"success":        jobErr == nil,
+		"peak_rss_bytes": usage.PeakRSSBytes,
+		"cpu_seconds":    usage.CPUTime.Seconds(),
+	}
+	if jobErr != nil {
+		body["error"] = jobErr.Error()
+		body["failure_class"] = services.ClassifyError(jobErr)
+		var classified *services.JobError
+		if errors.As(jobErr, &classified) {
+			body["stage"] = classified.Stage
+			body["log"] = classified.Log
+		}
+	}
+	// 后处理由服务器另建任务执行，其结果不在此返回
+	if err := wc.doJSON(http.MethodPost, wc.jobPath(jobID, "result"), body, nil); err != nil {
+		return fmt.Errorf("fail to report result: %w", err)
+	}
+	return nil
+}
+
+func (wc *workerClient) jobPath(jobID uint, action string) string {
+	return "/worker/jobs/" + strconv.FormatUint(uint64(jobID), 10) + "/" + action
+}
+
+// doJSON 以JSON发送请求并解析JSON响应，out为nil或响应为204时不解析
+func (wc *workerClient) doJSON(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := wc.do(method, path, body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do 发送请求，响应状态码不是2xx时返回错误，409表示失去租约
+func (wc *workerClient) do(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, wc.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if wc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+wc.token)
+	}
+	resp, err := wc.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errLeaseLost
+	}
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	return nil, fmt.Errorf("%s %s: %s %s", method, path, resp.Status, errResp.Error)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"myapp/services"
+	"os"
+	"path/filepath"
+)
+
+// fakeTrain 代替真实训练器，在处理器的输出目录中按训练器的目录结构生成示例场景：
+// 地面上的一个彩色球体、环绕场景的相机姿态以及检查点文件。场景采用 -Y 朝上的约定。
+func fakeTrain(processor *services.VideoProcessor) error {
+	processor.OutputFolder = filepath.Join(processor.BaseOutputFolder, "fake")
+	if err := os.MkdirAll(filepath.Dir(processor.SplatPath()), 0755); err != nil {
+		return fmt.Errorf("fail to create output folder: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(int64(processor.Params.Iterations)))
+	var splats []services.Splat
+	// 地面：y = 1 处的平面
+	for i := 0; i < 20000; i++ {
+		splats = append(splats, fakeSplat(
+			[3]float32{float32(rng.Float64()*6 - 3), 1, float32(rng.Float64()*6 - 3)},
+			0.03, [4]uint8{120, 120, 110, 255}))
+	}
+	// 球体：位于地面上方，颜色随位置变化
+	for i := 0; i < 20000; i++ {
+		theta := rng.Float64() * 2 * math.Pi
+		phi := math.Acos(2*rng.Float64() - 1)
+		x, y, z := math.Sin(phi)*math.Cos(theta), math.Cos(phi), math.Sin(phi)*math.Sin(theta)
+		splats = append(splats, fakeSplat(
+			[3]float32{float32(x), float32(y), float32(z)},
+			0.02, [4]uint8{uint8(128 + 127*x), uint8(128 + 127*y), uint8(128 + 127*z), 255}))
+	}
+	if err := services.WriteSplatFile(processor.SplatPath(), splats); err != nil {
+		return err
+	}
+
+	if err := services.WriteCameraPoses(processor.CamerasPath(), fakeCameras(24, 4)); err != nil {
+		return err
+	}
+
+	return os.WriteFile(processor.CheckpointPath(), []byte("fake checkpoint\n"), 0644)
+}
+
+func fakeSplat(position [3]float32, scale float32, color [4]uint8) services.Splat {
+	s := services.Splat{
+		Position: position,
+		Scale:    [3]float32{scale, scale, scale},
+		Color:    color,
+	}
+	s.SetQuaternion([4]float64{1, 0, 0, 0})
+	return s
+}
+
+// fakeCameras 生成count个在半径radius的圆周上环绕原点、略高于场景的相机
+func fakeCameras(count int, radius float64) []services.CameraPose {
+	cameras := make([]services.CameraPose, 0, count)
+	for i := 0; i < count; i++ {
+		angle := 2 * math.Pi * float64(i) / float64(count)
+		position := [3]float64{radius * math.Cos(angle), -1, radius * math.Sin(angle)}
+
+		// OpenCV约定：z轴朝向原点，y轴大致朝下（+Y），x = y × z
+		forward := normalize([3]float64{-position[0], -position[1], -position[2]})
+		right := normalize(cross([3]float64{0, 1, 0}, forward))
+		down := cross(forward, right)
+
+		var rotation [3][3]float64
+		for r := 0; r < 3; r++ {
+			rotation[r] = [3]float64{right[r], down[r], forward[r]}
+		}
+		cameras = append(cameras, services.CameraPose{
+			ID:       i,
+			ImgName:  fmt.Sprintf("%05d", i),
+			Width:    960,
+			Height:   540,
+			Position: position,
+			Rotation: rotation,
+			Fx:       800,
+			Fy:       800,
+		})
+	}
+	return cameras
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize(v [3]float64) [3]float64 {
+	length := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	return [3]float64{v[0] / length, v[1] / length, v[2] / length}
+}
@@ -0,0 +1,224 @@
+// worker 是远程训练节点：向API服务器注册后轮询租用训练任务，在本机运行训练器，
+// 上传产物并报告结果。使用 -fake 时以生成的示例场景代替真实训练器，便于在本地联调。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"myapp/config"
+	"myapp/services"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	hostname, _ := os.Hostname()
+	server := flag.String("server", "http://localhost:8080", "API服务器地址")
+	name := flag.String("name", hostname, "节点名称")
+	secret := flag.String("secret", os.Getenv("WORKER_SECRET"), "注册密钥，与服务器的 WORKER_SECRET 一致")
+	tokenFile := flag.String("token-file", "worker.token", "保存节点令牌的文件，不存在时注册并写入")
+	python := flag.String("python", "", "训练器使用的Python解释器，为空时使用默认值")
+	poll := flag.Duration("poll", 5*time.Second, "没有任务时的轮询间隔")
+	fake := flag.Bool("fake", false, "使用生成的示例场景代替真实训练器")
+	jobTimeout := flag.Duration("job-timeout", 6*time.Hour, "单个任务的最长执行时间，0表示不限制")
+	flag.IntVar(&config.Conf.TrainTimeoutSeconds, "train-timeout", 0, "训练阶段的超时秒数，0表示不限制")
+	flag.IntVar(&config.Conf.SplatTimeoutSeconds, "splat-timeout", 600, "转换splat阶段的超时秒数，0表示不限制")
+	flag.IntVar(&config.Conf.TrainerMemoryLimitMB, "memory-limit-mb", 0, "训练器子进程的内存上限（MB），0表示不限制")
+	flag.Float64Var(&config.Conf.TrainerCPULimit, "cpu-limit", 0, "训练器子进程可使用的CPU核数，需要配合 -cgroup-root")
+	flag.StringVar(&config.Conf.TrainerCgroupRoot, "cgroup-root", "", "为训练器创建cgroup的父目录（cgroup v2，需可写）")
+	flag.Parse()
+
+	client := newWorkerClient(strings.TrimRight(*server, "/"), "")
+	token, err := loadOrRegister(client, *tokenFile, *name, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client.token = token
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	runner := &jobRunner{client: client, python: *python, fake: *fake, timeout: *jobTimeout}
+	log.Printf("worker %s polling %s", *name, *server)
+	for ctx.Err() == nil {
+		job, err := client.lease()
+		if err != nil {
+			log.Print(err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(*poll):
+			}
+			continue
+		}
+		runner.run(ctx, job)
+	}
+	log.Print("worker exit")
+}
+
+// loadOrRegister 从文件读取节点令牌，文件不存在时注册节点并保存令牌
+func loadOrRegister(client *workerClient, tokenFile, name, secret string) (string, error) {
+	if data, err := os.ReadFile(tokenFile); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	token, err := client.register(name, secret, []string{services.JobTypeTrain})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("fail to save worker token: %w", err)
+	}
+	return token, nil
+}
+
+// jobRunner 在本机执行租用到的任务
+type jobRunner struct {
+	client  *workerClient
+	python  string
+	fake    bool
+	timeout time.Duration
+}
+
+// run 执行一个任务并报告结果；执行期间定期发送心跳，失去租约时放弃该任务
+func (r *jobRunner) run(ctx context.Context, job *leasedJob) {
+	log.Printf("job %d (%s) leased", job.JobID, job.Type)
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 任务超时时终止训练器并作为超时失败报告，与被取消（失去租约、退出）区分
+	runCtx := jobCtx
+	if r.timeout > 0 {
+		var cancelRun context.CancelFunc
+		runCtx, cancelRun = context.WithTimeout(jobCtx, r.timeout)
+		defer cancelRun()
+	}
+
+	// 心跳间隔取租约剩余时间的三分之一
+	interval := time.Until(job.LeaseExpiresAt) / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+				if err := r.client.heartbeat(job.JobID); err != nil {
+					log.Printf("job %d heartbeat: %v", job.JobID, err)
+					if errors.Is(err, errLeaseLost) {
+						cancel()
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// 训练器的输出上传到服务器的任务日志，接在之前尝试的日志之后
+	jobLog := services.NewJobLogWriter(jobLogSink{client: r.client, jobID: job.JobID}, job.LogOffset)
+	jobLog.Printf("attempt %d/%d started at %s on worker", job.Attempt, job.MaxAttempts, time.Now().Format(time.RFC3339))
+	usage, err := r.execute(runCtx, job, jobLog)
+	if err != nil && jobCtx.Err() == nil {
+		jobLog.Printf("attempt %d failed: %v", job.Attempt, err)
+	}
+	jobLog.Close()
+	if jobCtx.Err() != nil {
+		log.Printf("job %d abandoned", job.JobID)
+		return
+	}
+	if err != nil {
+		log.Printf("job %d failed: %v", job.JobID, err)
+	}
+	if err := r.client.report(job.JobID, err, usage); err != nil {
+		log.Printf("job %d: %v", job.JobID, err)
+		return
+	}
+	log.Printf("job %d reported", job.JobID)
+}
+
+// execute 下载输入、运行训练器并上传产物，返回训练器子进程的资源使用
+func (r *jobRunner) execute(ctx context.Context, job *leasedJob, jobLog io.Writer) (services.ResourceUsage, error) {
+	if job.Type != services.JobTypeTrain {
+		return services.ResourceUsage{}, fmt.Errorf("unsupported job type %q", job.Type)
+	}
+	var payload services.TrainPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return services.ResourceUsage{}, fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	workDir, err := os.MkdirTemp("", fmt.Sprintf("job%d-", job.JobID))
+	if err != nil {
+		return services.ResourceUsage{}, fmt.Errorf("fail to create work dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	inputs := map[string]string{}
+	for _, name := range job.Inputs {
+		inputs[name] = filepath.Join(workDir, filepath.Base(name))
+		if err := r.client.download(job.JobID, name, inputs[name]); err != nil {
+			return services.ResourceUsage{}, err
+		}
+	}
+
+	processor, err := services.NewVideoProcessor(payload.Params)
+	if err != nil {
+		return services.ResourceUsage{}, err
+	}
+	processor.BaseOutputFolder = filepath.Join(workDir, "output")
+	processor.Log = jobLog
+	if r.python != "" {
+		processor.PythonInterpreter = r.python
+	}
+	if checkpoint, ok := inputs["checkpoint.pth"]; ok {
+		processor.StartCheckpoint = checkpoint
+	}
+
+	if r.fake {
+		err = fakeTrain(processor)
+	} else {
+		err = processor.ProcessVideo(ctx, inputs["video.mp4"], processor)
+		if err == nil {
+			err = processor.Splat(ctx)
+		}
+	}
+	if err != nil {
+		return processor.Usage, err
+	}
+	if ctx.Err() != nil {
+		return processor.Usage, services.NewJobError("", "train", ctx.Err())
+	}
+
+	artifacts := map[string]string{
+		services.TrainArtifactSplat:      processor.SplatPath(),
+		services.TrainArtifactCameras:    processor.CamerasPath(),
+		services.TrainArtifactCheckpoint: processor.CheckpointPath(),
+	}
+	for _, name := range job.Artifacts {
+		artifactPath, ok := artifacts[name]
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(artifactPath); err != nil {
+			if name == services.TrainArtifactSplat {
+				return processor.Usage, fmt.Errorf("trainer produced no %s", name)
+			}
+			continue
+		}
+		if err := r.client.upload(job.JobID, name, artifactPath); err != nil {
+			return processor.Usage, err
+		}
+	}
+	return processor.Usage, nil
+}
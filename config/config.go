@@ -2,8 +2,12 @@
 package config
 
 import (
+	"encoding/base64"
+	"fmt"
 	"myapp/models"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/minio/minio-go/v7"
@@ -24,6 +28,95 @@ type AppConfig struct {
 	SplatPath  string
 	ServerPort string
 	DSN        string
+
+	FFmpegPath    string
+	PreviewFormat string
+	PreviewFrames int
+	PreviewSize   int // 预览视频的边长（像素），须为正偶数
+
+	NormalizeScene bool
+
+	JobWorkers      int
+	JobPollInterval int // 秒
+	// 每个用户同时运行的任务数上限
+	JobUserConcurrency int
+	// 可重试失败的最多尝试次数及首次重试的等待时间（秒），之后按指数增长
+	JobMaxAttempts      int
+	JobRetryBaseSeconds int
+
+	// 任务整体超时及训练、转换阶段的超时（秒），0表示不限制
+	JobTimeoutSeconds   int
+	TrainTimeoutSeconds int
+	SplatTimeoutSeconds int
+	// 训练器子进程的资源限制：内存上限（MB）与CPU核数，0表示不限制。
+	// 两者都需要配置 TrainerCgroupRoot（cgroup v2 目录），未配置时不生效；
+	// 不使用rlimit限制虚拟内存，因为CUDA与PyTorch会预留远超实际用量的地址空间
+	TrainerMemoryLimitMB int
+	TrainerCPULimit      float64
+	TrainerCgroupRoot    string
+	// 每个任务在对象存储中保留的日志上限（MB），超出时删除最早的分段
+	JobLogMaxMB int
+
+	// 未完成的存储操作在多少秒后由后台协调器处理
+	OutboxGraceSeconds int
+	// 存储核对任务的间隔（小时，0表示不定期执行）、是否删除孤立对象，
+	// 以及本地临时目录多久未修改后视为过期（小时）
+	StorageGCIntervalHours int
+	StorageGCDelete        bool
+	TempMaxAgeHours        int
+
+	// 对象完整性巡检的间隔（小时，0表示不定期执行）与每次校验的对象数
+	ScrubIntervalHours int
+	ScrubBatchSize     int
+
+	// 对象加密的主密钥（ID到32字节AES-256密钥），为空时不加密；新对象使用 StorageKeyID 对应的密钥，
+	// 其余密钥只用于解密，由密钥轮换任务按 KeyRotationIntervalHours（小时）逐步改用当前密钥
+	StorageKeys              map[string][]byte
+	StorageKeyID             string
+	KeyRotationIntervalHours int
+
+	// 数据保留规则（天，0表示永久保留）：作品训练成功后原视频的保留天数、失败任务的日志与产物的保留天数、
+	// 作品导出文件的保留天数，以及软删除的作品、视频与webhook被彻底删除前的天数；以及执行保留规则的间隔（小时）
+	RetentionVideoDays     int
+	RetentionFailedJobDays int
+	RetentionExportDays    int
+	RetentionDeletedDays   int
+	LifecycleIntervalHours int
+
+	// 每个用户的存储配额（MB）与GPU时长配额（分钟），0表示不限制
+	QuotaStorageMB  int
+	QuotaGPUMinutes int
+
+	// 批量导入作品时，压缩包中单个文件及解出的文件总计的大小上限（MB），超出时导入任务失败，0表示不限制
+	ImportMaxEntryMB int
+	ImportMaxTotalMB int
+
+	// 注销账号后可恢复的天数，之后彻底删除账号的全部数据
+	AccountDeletionGraceDays int
+	// 账号数据导出的压缩包及其下载链接的有效时间（小时）
+	AccountExportExpiryHours int
+
+	// webhook投递的最多尝试次数、单次请求超时（秒），以及是否允许投递到内网地址
+	WebhookMaxAttempts    int
+	WebhookTimeoutSeconds int
+	WebhookAllowPrivate   bool
+
+	// 邮件通知：SMTP服务器（为空时不发送）、认证信息、发件人、最多尝试次数，
+	// 默认语言（zh/en），以及邮件中链接使用的站点地址
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         string
+	EmailMaxAttempts int
+	EmailLanguage    string
+	AppBaseURL       string
+
+	// 远程训练节点：注册密钥、任务租约时长（秒，本地执行的任务同样适用）、只交给远程节点执行的任务类型及单个产物的大小上限（MB，0表示不限制）
+	WorkerSecret        string
+	WorkerLeaseSeconds  int
+	RemoteJobTypes      []string
+	WorkerArtifactMaxMB int
 }
 
 var Conf AppConfig
@@ -43,6 +136,82 @@ func LoadConfig() {
 		SplatPath:  os.Getenv("SPLAT_PATH"),
 		ServerPort: "8080",
 		DSN:        os.Getenv("DB_DSN"),
+
+		FFmpegPath:    getEnv("FFMPEG_PATH", "ffmpeg"),
+		PreviewFormat: getEnv("PREVIEW_FORMAT", "mp4"),
+		PreviewFrames: getEnvInt("PREVIEW_FRAMES", 72),
+		PreviewSize:   getEnvInt("PREVIEW_SIZE", 512),
+
+		NormalizeScene: getEnv("NORMALIZE_SCENE", "true") == "true",
+
+		JobWorkers:      getEnvInt("JOB_WORKERS", 2),
+		JobPollInterval: getEnvInt("JOB_POLL_INTERVAL", 2),
+
+		JobUserConcurrency:  getEnvInt("JOB_USER_CONCURRENCY", 2),
+		JobMaxAttempts:      getEnvInt("JOB_MAX_ATTEMPTS", 3),
+		JobRetryBaseSeconds: getEnvInt("JOB_RETRY_BASE_SECONDS", 30),
+
+		JobTimeoutSeconds:   getEnvInt("JOB_TIMEOUT", 6*60*60),
+		TrainTimeoutSeconds: getEnvInt("TRAIN_TIMEOUT", 0),
+		SplatTimeoutSeconds: getEnvInt("SPLAT_TIMEOUT", 10*60),
+
+		TrainerMemoryLimitMB: getEnvInt("TRAINER_MEMORY_LIMIT_MB", 0),
+		TrainerCPULimit:      getEnvFloat("TRAINER_CPU_LIMIT", 0),
+		TrainerCgroupRoot:    os.Getenv("TRAINER_CGROUP_ROOT"),
+		JobLogMaxMB:          getEnvInt("JOB_LOG_MAX_MB", 50),
+
+		OutboxGraceSeconds:     getEnvInt("OUTBOX_GRACE_SECONDS", 15*60),
+		StorageGCIntervalHours: getEnvInt("STORAGE_GC_INTERVAL_HOURS", 24),
+		StorageGCDelete:        getEnv("STORAGE_GC_DELETE", "false") == "true",
+		TempMaxAgeHours:        getEnvInt("TEMP_MAX_AGE_HOURS", 24),
+
+		ScrubIntervalHours: getEnvInt("SCRUB_INTERVAL_HOURS", 24),
+		ScrubBatchSize:     getEnvInt("SCRUB_BATCH_SIZE", 200),
+
+		KeyRotationIntervalHours: getEnvInt("KEY_ROTATION_INTERVAL_HOURS", 24),
+
+		RetentionVideoDays:     getEnvInt("RETENTION_VIDEO_DAYS", 0),
+		RetentionFailedJobDays: getEnvInt("RETENTION_FAILED_JOB_DAYS", 30),
+		RetentionExportDays:    getEnvInt("RETENTION_EXPORT_DAYS", 7),
+		RetentionDeletedDays:   getEnvInt("RETENTION_DELETED_DAYS", 30),
+		LifecycleIntervalHours: getEnvInt("LIFECYCLE_INTERVAL_HOURS", 24),
+
+		QuotaStorageMB:  getEnvInt("QUOTA_STORAGE_MB", 0),
+		QuotaGPUMinutes: getEnvInt("QUOTA_GPU_MINUTES", 0),
+
+		ImportMaxEntryMB: getEnvInt("IMPORT_MAX_ENTRY_MB", 1024),
+		ImportMaxTotalMB: getEnvInt("IMPORT_MAX_TOTAL_MB", 4096),
+
+		AccountDeletionGraceDays: getEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 30),
+		AccountExportExpiryHours: getEnvInt("ACCOUNT_EXPORT_EXPIRY_HOURS", 72),
+
+		WebhookMaxAttempts:    getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookTimeoutSeconds: getEnvInt("WEBHOOK_TIMEOUT", 10),
+		WebhookAllowPrivate:   getEnv("WEBHOOK_ALLOW_PRIVATE", "false") == "true",
+
+		SMTPHost:         os.Getenv("SMTP_HOST"),
+		SMTPPort:         getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:     os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:         os.Getenv("SMTP_FROM"),
+		EmailMaxAttempts: getEnvInt("EMAIL_MAX_ATTEMPTS", 5),
+		EmailLanguage:    getEnv("EMAIL_LANGUAGE", "zh"),
+		AppBaseURL:       strings.TrimRight(os.Getenv("APP_BASE_URL"), "/"),
+
+		WorkerSecret:        os.Getenv("WORKER_SECRET"),
+		WorkerLeaseSeconds:  getEnvInt("WORKER_LEASE_SECONDS", 60),
+		RemoteJobTypes:      getEnvList("REMOTE_JOB_TYPES"),
+		WorkerArtifactMaxMB: getEnvInt("WORKER_ARTIFACT_MAX_MB", 4096),
+	}
+
+	// 预览视频以yuv420p编码，libx264要求宽高为偶数
+	if Conf.PreviewSize <= 0 || Conf.PreviewSize%2 != 0 {
+		panic(fmt.Sprintf("invalid PREVIEW_SIZE %d: must be a positive even number", Conf.PreviewSize))
+	}
+
+	Conf.StorageKeys, Conf.StorageKeyID, err = parseStorageKeys(getEnvList("STORAGE_ENCRYPTION_KEYS"))
+	if err != nil {
+		panic("invalid STORAGE_ENCRYPTION_KEYS: " + err.Error())
 	}
 
 	db, err := gorm.Open(mysql.Open(Conf.DSN), &gorm.Config{
@@ -52,7 +221,10 @@ func LoadConfig() {
 		panic("failed to connect database: " + err.Error())
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.Video{}, &models.Work{}); err != nil {
+	if err := prepareMigration(db); err != nil {
+		panic("Database migration failed: " + err.Error())
+	}
+	if err := Migrate(db); err != nil {
 		panic("Database migration failed: " + err.Error())
 	}
 	Conf.DB = db // 将数据库实例存入 AppConfig
@@ -66,3 +238,82 @@ func LoadConfig() {
 
 	Conf.MINIO = minioClient
 }
+
+// Migrate 创建或更新全部数据表
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&models.User{}, &models.Video{}, &models.Work{}, &models.CameraPreset{}, &models.Job{}, &models.WorkRevision{}, &models.TrainingPreset{}, &models.Worker{}, &models.WorkAttempt{}, &models.JobLogSegment{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.NotificationPreference{}, &models.EmailMessage{}, &models.PendingOperation{}, &models.StoredObject{})
+}
+
+// prepareMigration 在 AutoMigrate 之前整理已有数据，使新增的唯一索引能够建立：
+// 没有记录SHA-256的视频改为NULL，同一用户内容重复的视频只保留最早一条的SHA-256。
+func prepareMigration(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.Video{}, "SHA256") {
+		return nil
+	}
+	if err := db.Exec("UPDATE videos SET sha256 = NULL WHERE sha256 = ''").Error; err != nil {
+		return err
+	}
+	return db.Exec("UPDATE videos v JOIN videos o ON o.user_id = v.user_id AND o.sha256 = v.sha256 AND o.id < v.id " +
+		"SET v.sha256 = NULL").Error
+}
+
+// getEnv 读取环境变量，未设置时返回默认值。
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// getEnvInt 读取整数类型的环境变量，未设置或格式错误时返回默认值。
+func getEnvInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// getEnvFloat 读取浮点类型的环境变量，未设置或格式错误时返回默认值。
+func getEnvFloat(key string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// getEnvList 读取以逗号分隔的环境变量，忽略空白项。
+func getEnvList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseStorageKeys 解析形如 "id:base64密钥" 的主密钥列表，返回密钥表与第一个密钥的ID（用于加密新对象）。
+func parseStorageKeys(entries []string) (map[string][]byte, string, error) {
+	keys := make(map[string][]byte, len(entries))
+	activeID := ""
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, "", fmt.Errorf("key %q is not in id:base64 form", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, "", fmt.Errorf("key %s must be 32 bytes encoded in base64", id)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, "", fmt.Errorf("duplicate key id %s", id)
+		}
+		keys[id] = key
+		if activeID == "" {
+			activeID = id
+		}
+	}
+	return keys, activeID, nil
+}
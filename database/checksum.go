@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"myapp/config"
+	"myapp/models"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrChecksumMismatch 表示对象的内容与上传时记录的大小或SHA-256不一致
+var ErrChecksumMismatch = errors.New("object checksum mismatch")
+
+// stageObject 在上传前将即将上传的内容的大小、SHA-256与数据密钥记为待定，同名对象的当前记录不变。
+// 之后即使上传完成而进程退出，存储中的内容也能找到解密所需的数据密钥
+func stageObject(upload *models.StoredObject) error {
+	object := models.StoredObject{
+		ObjectKey:         upload.ObjectKey,
+		PendingSize:       upload.Size,
+		PendingSHA256:     upload.SHA256,
+		PendingKeyID:      upload.KeyID,
+		PendingWrappedKey: upload.WrappedKey,
+	}
+	err := config.Conf.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "object_key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"pending_size":        upload.Size,
+			"pending_sha256":      upload.SHA256,
+			"pending_key_id":      upload.KeyID,
+			"pending_wrapped_key": upload.WrappedKey,
+			"updated_at":          time.Now(),
+		}),
+	}).Create(&object).Error
+	if err != nil {
+		return fmt.Errorf("fail to record checksum of %s: %w", upload.ObjectKey, err)
+	}
+	return nil
+}
+
+// promoteObject 在上传完成后将待定记录转为当前记录。待定记录已被之后的上传替换时不做任何事，由那次上传转换
+func promoteObject(upload *models.StoredObject) error {
+	err := config.Conf.DB.Unscoped().Model(&models.StoredObject{}).
+		Where("object_key = ? AND pending_sha256 = ? AND pending_key_id = ? AND pending_wrapped_key = ?",
+			upload.ObjectKey, upload.SHA256, upload.KeyID, upload.WrappedKey).
+		Updates(map[string]interface{}{
+			"size":                upload.Size,
+			"sha256":              upload.SHA256,
+			"key_id":              upload.KeyID,
+			"wrapped_key":         upload.WrappedKey,
+			"pending_size":        0,
+			"pending_sha256":      "",
+			"pending_key_id":      "",
+			"pending_wrapped_key": "",
+			"verified_at":         nil,
+			"verify_error":        "",
+			"deleted_at":          nil,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("fail to record checksum of %s: %w", upload.ObjectKey, err)
+	}
+	return nil
+}
+
+// resolveObject 返回与存储中的内容对应的记录，没有上传完成的内容时返回nil。
+// 有待定记录（上传后未能转为当前记录）时，按对象元数据中的SHA-256与数据密钥标识判断存储中的内容，
+// 属于待定记录时将其转为当前记录
+func resolveObject(ctx context.Context, object *models.StoredObject) (*models.StoredObject, error) {
+	if object == nil {
+		return nil, nil
+	}
+	if object.PendingSHA256 != "" {
+		info, err := config.Conf.MINIO.StatObject(ctx, config.Conf.BucketName, object.ObjectKey, minio.StatObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("object %s not found: %w", object.ObjectKey, err)
+		}
+		pending := *object
+		pending.Size, pending.SHA256 = object.PendingSize, object.PendingSHA256
+		pending.KeyID, pending.WrappedKey = object.PendingKeyID, object.PendingWrappedKey
+		pending.PendingSize, pending.PendingSHA256, pending.PendingKeyID, pending.PendingWrappedKey = 0, "", "", ""
+		uploaded, err := uploadedAs(&pending, info.UserMetadata)
+		if err != nil {
+			return nil, err
+		}
+		if uploaded {
+			if err := promoteObject(&pending); err != nil {
+				return nil, err
+			}
+			return &pending, nil
+		}
+	}
+	if object.SHA256 == "" {
+		return nil, nil
+	}
+	return object, nil
+}
+
+// uploadedAs 判断元数据为metadata的对象是否是按记录object上传的内容
+func uploadedAs(object *models.StoredObject, metadata map[string]string) (bool, error) {
+	if metadataValue(metadata, "sha256") != object.SHA256 {
+		return false, nil
+	}
+	if object.KeyID == "" {
+		return metadataValue(metadata, "data-key") == "", nil
+	}
+	key, err := unwrapDataKey(object)
+	if err != nil {
+		return false, err
+	}
+	return metadataValue(metadata, "data-key") == dataKeyID(key), nil
+}
+
+// metadataValue 返回对象的用户元数据，名称不区分大小写
+func metadataValue(metadata map[string]string, name string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// FindObjectChecksum 返回对象上传时记录的大小与SHA-256，没有记录（早于校验和功能上传的对象）时返回nil
+func FindObjectChecksum(key string) (*models.StoredObject, error) {
+	var object models.StoredObject
+	err := config.Conf.DB.Where("object_key = ?", key).First(&object).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to find checksum of %s: %w", key, err)
+	}
+	return &object, nil
+}
+
+// verifyChecksum 将取得的对象内容的大小与SHA-256与记录object比较，没有记录时不校验
+func verifyChecksum(object *models.StoredObject, key string, size int64, sum string) error {
+	if object == nil {
+		return nil
+	}
+	if object.Size != size {
+		return fmt.Errorf("%w: %s has %d bytes, expected %d", ErrChecksumMismatch, key, size, object.Size)
+	}
+	if object.SHA256 != sum {
+		return fmt.Errorf("%w: %s has sha256 %s, expected %s", ErrChecksumMismatch, key, sum, object.SHA256)
+	}
+	return nil
+}
+
+// verifyReader 在流式读取对象时计算SHA-256，读到记录的大小时与记录比较。
+// 不一致时不返回最后一次读取的内容而返回包装了 ErrChecksumMismatch 的错误，读取方不会得到完整的损坏内容。
+type verifyReader struct {
+	src    io.Reader
+	object *models.StoredObject
+	hash   hash.Hash
+	size   int64
+	done   bool
+}
+
+func newVerifyReader(src io.Reader, object *models.StoredObject) *verifyReader {
+	return &verifyReader{src: src, object: object, hash: sha256.New()}
+}
+
+func (r *verifyReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	n, err := r.src.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	if err == nil && r.size < r.object.Size {
+		return n, nil
+	}
+	if err == nil {
+		// 已读到记录的大小，确认对象没有多余的内容
+		var extra [1]byte
+		m, extraErr := io.ReadFull(r.src, extra[:])
+		if extraErr != nil && extraErr != io.EOF {
+			return 0, extraErr
+		}
+		r.hash.Write(extra[:m])
+		r.size += int64(m)
+	}
+	if err := verifyChecksum(r.object, r.object.ObjectKey, r.size, hex.EncodeToString(r.hash.Sum(nil))); err != nil {
+		return 0, err
+	}
+	r.done = true
+	return n, io.EOF
+}
+
+// VerifyObject 重新读取存储桶中的对象（加密的对象同时解密）并与记录的校验和比较，不保存到本地
+func VerifyObject(ctx context.Context, key string) error {
+	obj, object, err := openObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, obj)
+	if err != nil {
+		return fmt.Errorf("fail to read object %s: %w", key, err)
+	}
+	return verifyChecksum(object, key, size, hex.EncodeToString(hash.Sum(nil)))
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"myapp/testutil"
+	"testing"
+)
+
+// readObject 通过 OpenObject 读取对象的全部内容
+func readObject(t *testing.T, key string) ([]byte, error) {
+	t.Helper()
+	reader, err := OpenObject(key)
+	if err != nil {
+		t.Fatalf("open %s: %v", key, err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestOpenObjectVerifiesChecksum(t *testing.T) {
+	bucket := testutil.Setup(t)
+	const key = "work1/cameras.json"
+	content := bytes.Repeat([]byte(`{"fx":1111.1},`), 10000)
+	storeContent(t, key, content)
+
+	if got, err := readObject(t, key); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("read %d bytes, err = %v", len(got), err)
+	}
+
+	corrupted := append([]byte{}, content...)
+	corrupted[len(corrupted)-1] = 'x'
+	cases := map[string][]byte{
+		"changed last byte": corrupted,
+		"truncated":         content[:len(content)-100],
+		"extra bytes":       append(append([]byte{}, content...), '!'),
+	}
+	for name, stored := range cases {
+		t.Run(name, func(t *testing.T) {
+			bucket.Put(key, stored)
+			got, err := readObject(t, key)
+			if !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("err = %v, want ErrChecksumMismatch", err)
+			}
+			// 不一致时最后一次读取的内容不交给调用方，调用方不会得到完整的损坏内容
+			if len(got) >= len(stored) {
+				t.Errorf("read %d of %d bytes of a corrupted object", len(got), len(stored))
+			}
+		})
+	}
+}
@@ -3,49 +3,95 @@ package database
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"myapp/config"
+	"myapp/models"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 )
 
 func StoreInBucket(id, ftype string, file *os.File) error {
+	return StoreObject(ftype+id+filepath.Ext(file.Name()), file)
+}
+
+// StoreObject 将本地文件以指定的对象键流式上传到存储桶，对象键的扩展名需与文件一致
+func StoreObject(key string, file *os.File) error {
 	// 1. 添加文件格式校验
 	ext := filepath.Ext(file.Name())
-	if ext != ".mp4" && ext != ".splat" {
-		return fmt.Errorf("unsupported file format: %s, only .mp4 and .splat allowed", ext)
+	contentType, ok := contentTypes[ext]
+	if !ok {
+		return fmt.Errorf("unsupported file format: %s", ext)
+	}
+	if filepath.Ext(key) != ext {
+		return fmt.Errorf("object key %s does not match file format %s", key, ext)
 	}
 
 	// 2. 设置大文件分块参数（64MB分块）
 	partSize := uint64(64 * 1024 * 1024)
 	opts := minio.PutObjectOptions{
-		ContentType:      getContentType(ext), // 3. 自定义ContentType处理
+		ContentType:      contentType, // 3. 自定义ContentType处理
 		PartSize:         partSize,
 		DisableMultipart: false,
 	}
 
-	// 4. 使用流式上传避免内存溢出
+	// 4. 上传前计算大小与SHA-256，随对象一同保存，取回时据此校验
+	if _, err := file.Seek(0, 0); err != nil {
+		return fmt.Errorf("fail to reset file pointer:%w", err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("fail to hash file:%w", err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	opts.UserMetadata = map[string]string{"sha256": sum}
 	if _, err := file.Seek(0, 0); err != nil {
 		return fmt.Errorf("fail to reset file pointer:%w", err)
 	}
 
-	// 5. 通过Reader接口实现流式上传
-	_, err := config.Conf.MINIO.PutObject(
+	// 5. 配置了主密钥时以新生成的数据密钥加密后上传，数据密钥加密后随校验和一同记录
+	var reader io.Reader = file
+	uploadSize := size
+	dataKey, keyID, wrappedKey, err := newDataKey(key)
+	if err != nil {
+		return err
+	}
+	if dataKey != nil {
+		if reader, err = newEncryptReader(file, dataKey, size); err != nil {
+			return fmt.Errorf("fail to encrypt file:%w", err)
+		}
+		uploadSize = encryptedSize(size)
+		opts.UserMetadata["encryption"] = "aes-256-gcm"
+		opts.UserMetadata["data-key"] = dataKeyID(dataKey)
+	}
+
+	// 6. 上传前先记录数据密钥，上传完成后再转为当前记录，上传后进程退出也不会丢失解密所需的密钥
+	upload := &models.StoredObject{ObjectKey: key, Size: size, SHA256: sum, KeyID: keyID, WrappedKey: wrappedKey}
+	if err := stageObject(upload); err != nil {
+		return err
+	}
+
+	// 7. 通过Reader接口实现流式上传
+	_, err = config.Conf.MINIO.PutObject(
 		context.Background(),
 		config.Conf.BucketName,
-		ftype+id+ext,
-		file,
-		-1, // 使用-1让minio自动检测文件大小
+		key,
+		reader,
+		uploadSize,
 		opts,
 	)
 	if err != nil {
 		return fmt.Errorf("fail to upload file:%w", err)
 	}
-	return nil
+	return promoteObject(upload)
 }
 
 func RetrieveFromBucket(id string) (string, error) {
@@ -56,7 +102,8 @@ func RetrieveFromBucket(id string) (string, error) {
 	}
 
 	fileuuid := uuid.New().String()
-	fileName := "temp/" + fileuuid + "/" + id
+	// 对象键可能包含目录层级（如版本化的作品文件），本地只保留文件名
+	fileName := "temp/" + fileuuid + "/" + path.Base(id)
 	// 创建保存目录（自动处理多级目录）
 	if err := os.MkdirAll("temp/"+fileuuid, 0755); err != nil {
 		return "", fmt.Errorf("failed to create directories: %w", err)
@@ -69,31 +116,135 @@ func RetrieveFromBucket(id string) (string, error) {
 	}
 	defer file.Close()
 
-	// 获取对象流
-	obj, err := config.Conf.MINIO.GetObject(context.Background(), config.Conf.BucketName, id, minio.GetObjectOptions{})
+	// 获取对象流，加密的对象在读取时解密
+	obj, object, err := openObject(context.Background(), id)
 	if err != nil {
 		return "", fmt.Errorf("failed to get object: %w", err)
 	}
 	defer obj.Close()
 
 	bufWriter := bufio.NewWriterSize(file, 64*1024*1024) // 64MB缓冲区
-	defer bufWriter.Flush()
 
-	//带进度监控的拷贝
-	if _, err := io.CopyBuffer(bufWriter, obj, make([]byte, 4*1024*1024)); err != nil { // 4MB buffer
+	// 拷贝的同时计算SHA-256，与上传时记录的校验和比较
+	hash := sha256.New()
+	size, err := io.CopyBuffer(io.MultiWriter(bufWriter, hash), obj, make([]byte, 4*1024*1024)) // 4MB buffer
+	if err != nil {
+		return "", fmt.Errorf("failed to save object content: %w", err)
+	}
+	if err := bufWriter.Flush(); err != nil {
 		return "", fmt.Errorf("failed to save object content: %w", err)
 	}
+	if err := verifyChecksum(object, id, size, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		file.Close()
+		os.RemoveAll("temp/" + fileuuid)
+		return "", err
+	}
 
 	return fileName, nil
 }
 
-func getContentType(ext string) string {
-	switch ext {
-	case ".mp4":
-		return "video/mp4"
-	case ".splat":
-		return "application/octet-stream" // 自定义类型
-	default:
-		return "application/octet-stream"
+// contentTypes 列出允许存入对象存储的文件格式及其ContentType
+var contentTypes = map[string]string{
+	".mp4":   "video/mp4",
+	".splat": "application/octet-stream", // 自定义类型
+	".webp":  "image/webp",
+	".json":  "application/json",
+	".ply":   "application/octet-stream",
+	".xyz":   "text/plain",
+	".las":   "application/vnd.las",
+	".pth":   "application/octet-stream", // 训练检查点
+	".log":   "text/plain",               // 任务日志分段
+	".zip":   "application/zip",          // 账号数据导出、作品导入
+	".tar":   "application/x-tar",        // 作品导入
+}
+
+// RemoveObject 删除存储桶中的对象及其校验和记录，对象不存在时不报错
+func RemoveObject(key string) error {
+	if err := config.Conf.MINIO.RemoveObject(context.Background(), config.Conf.BucketName, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("fail to remove object %s: %w", key, err)
+	}
+	if err := config.Conf.DB.Unscoped().Where("object_key = ?", key).Delete(&models.StoredObject{}).Error; err != nil {
+		return fmt.Errorf("fail to remove checksum of %s: %w", key, err)
+	}
+	return nil
+}
+
+// OpenObject 打开存储桶中的对象以流式读取，加密的对象在读取时解密，调用方负责关闭。
+// 有校验和记录的对象在读取时校验，内容与记录不一致时读取返回包装了 ErrChecksumMismatch 的错误
+func OpenObject(key string) (io.ReadCloser, error) {
+	obj, object, err := openObject(context.Background(), key)
+	if err != nil || object == nil {
+		return obj, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{newVerifyReader(obj, object), obj}, nil
+}
+
+// openObject 打开对象并返回其校验和记录（没有记录时为nil），记录表明对象已加密时返回解密后的内容
+func openObject(ctx context.Context, key string) (io.ReadCloser, *models.StoredObject, error) {
+	object, err := FindObjectChecksum(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if object, err = resolveObject(ctx, object); err != nil {
+		return nil, nil, err
+	}
+	obj, err := config.Conf.MINIO.GetObject(ctx, config.Conf.BucketName, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to open object %s: %w", key, err)
+	}
+	if object == nil || object.KeyID == "" {
+		return obj, object, nil
+	}
+	reader, err := newDecryptReader(obj, object)
+	if err != nil {
+		obj.Close()
+		return nil, nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, obj}, object, nil
+}
+
+// ObjectInfo 是存储桶中对象的基本信息
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects 列出存储桶中以prefix开头的全部对象，对每个对象调用fn，fn返回错误时停止
+func ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for obj := range config.Conf.MINIO.ListObjects(ctx, config.Conf.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("fail to list objects: %w", obj.Err)
+		}
+		if err := fn(ObjectInfo{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified}); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// ObjectSize 返回存储桶中对象内容的大小，加密的对象返回明文的大小
+func ObjectSize(key string) (int64, error) {
+	object, err := FindObjectChecksum(key)
+	if err != nil {
+		return 0, err
+	}
+	if object, err = resolveObject(context.Background(), object); err != nil {
+		return 0, err
+	} else if object != nil && object.KeyID != "" {
+		return object.Size, nil
+	}
+	info, err := config.Conf.MINIO.StatObject(context.Background(), config.Conf.BucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("object %s not found: %w", key, err)
 	}
+	return info.Size, nil
 }
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"bytes"
+	"myapp/config"
+	"myapp/models"
+	"myapp/testutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// storeContent 将content写入本地文件后以key上传
+func storeContent(t *testing.T, key string, content []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), filepath.Base(key))
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := StoreObject(key, file); err != nil {
+		t.Fatalf("store %s: %v", key, err)
+	}
+}
+
+// retrieveContent 取回并校验对象，返回其内容
+func retrieveContent(t *testing.T, key string) []byte {
+	t.Helper()
+	path, err := RetrieveFromBucket(key)
+	if err != nil {
+		t.Fatalf("retrieve %s: %v", key, err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func findObject(t *testing.T, key string) *models.StoredObject {
+	t.Helper()
+	object, err := FindObjectChecksum(key)
+	if err != nil || object == nil {
+		t.Fatalf("no record of %s: %v", key, err)
+	}
+	return object
+}
+
+// interruptUpload 模拟上传新内容时进程在某一步退出：数据库中新内容只停留在待定字段，
+// uploaded 为 true 时存储中已是新内容，否则仍是旧内容
+func interruptUpload(t *testing.T, bucket *testutil.Bucket, key string, content []byte, uploaded bool) {
+	t.Helper()
+	previous := *findObject(t, key)
+	restore := bucket.Snapshot(key)
+	storeContent(t, key, content)
+	next := findObject(t, key)
+	if !uploaded {
+		restore()
+	}
+	err := config.Conf.DB.Model(&models.StoredObject{}).Where("object_key = ?", key).Updates(map[string]interface{}{
+		"size": previous.Size, "sha256": previous.SHA256, "key_id": previous.KeyID, "wrapped_key": previous.WrappedKey,
+		"pending_size": next.Size, "pending_sha256": next.SHA256, "pending_key_id": next.KeyID, "pending_wrapped_key": next.WrappedKey,
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreObjectSurvivesInterruptedOverwrite(t *testing.T) {
+	bucket := testutil.Setup(t)
+	withStorageKeys(t, "k1")
+	previous, next := []byte(`{"version":1}`), []byte(`{"version":2}`)
+
+	// 上传完成但未能转为当前记录：按对象元数据找到待定的数据密钥，并将其转为当前记录
+	storeContent(t, "work1.json", previous)
+	interruptUpload(t, bucket, "work1.json", next, true)
+	if got := retrieveContent(t, "work1.json"); !bytes.Equal(got, next) {
+		t.Errorf("after interrupted promotion got %s, want %s", got, next)
+	}
+	if object := findObject(t, "work1.json"); object.PendingSHA256 != "" || object.Size != int64(len(next)) {
+		t.Errorf("pending record was not promoted: %+v", object)
+	}
+
+	// 上传失败：存储中仍是旧内容，以当前记录解密
+	storeContent(t, "work2.json", previous)
+	interruptUpload(t, bucket, "work2.json", next, false)
+	if got := retrieveContent(t, "work2.json"); !bytes.Equal(got, previous) {
+		t.Errorf("after failed upload got %s, want %s", got, previous)
+	}
+	if object := findObject(t, "work2.json"); object.PendingSHA256 == "" {
+		t.Error("pending record of the failed upload was promoted")
+	}
+}
+
+func TestStoreObjectStagesFirstUpload(t *testing.T) {
+	testutil.Setup(t)
+	withStorageKeys(t, "k1")
+	// 首次上传前进程退出：只有待定记录而存储中没有对象
+	if err := stageObject(&models.StoredObject{ObjectKey: "work1.json", Size: 2, SHA256: "00", KeyID: "k1", WrappedKey: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RetrieveFromBucket("work1.json"); err == nil {
+		t.Fatal("retrieved an object that was never uploaded")
+	}
+	storeContent(t, "work1.json", []byte("{}"))
+	if got := retrieveContent(t, "work1.json"); string(got) != "{}" {
+		t.Errorf("got %q after upload", got)
+	}
+}
+
+func TestRotateObjectKey(t *testing.T) {
+	bucket := testutil.Setup(t)
+	plain, secret := []byte(`{"uploaded":"before encryption"}`), []byte(`{"uploaded":"with old key"}`)
+	storeContent(t, "plain.json", plain)
+	withStorageKeys(t, "old")
+	oldKey := config.Conf.StorageKeys["old"]
+	storeContent(t, "secret.json", secret)
+	sealed, _ := bucket.Get("secret.json")
+
+	withStorageKeys(t, "new")
+	config.Conf.StorageKeys["old"] = oldKey
+	for _, key := range []string{"plain.json", "secret.json"} {
+		if err := RotateObjectKey(findObject(t, key)); err != nil {
+			t.Fatalf("rotate %s: %v", key, err)
+		}
+		if object := findObject(t, key); object.KeyID != "new" {
+			t.Errorf("%s uses key %q after rotation", key, object.KeyID)
+		}
+	}
+
+	// 已加密的对象只重新加密数据密钥，存储中的内容不变；未加密的对象加密后重新上传
+	if rotated, _ := bucket.Get("secret.json"); !bytes.Equal(rotated, sealed) {
+		t.Error("encrypted object was re-uploaded instead of rewrapping its data key")
+	}
+	if stored, _ := bucket.Get("plain.json"); !bytes.HasPrefix(stored, encryptMagic) {
+		t.Error("plaintext object is still stored unencrypted")
+	}
+
+	// 移除旧主密钥后两个对象仍可解密
+	delete(config.Conf.StorageKeys, "old")
+	if got := retrieveContent(t, "plain.json"); !bytes.Equal(got, plain) {
+		t.Errorf("plain.json = %s, want %s", got, plain)
+	}
+	if got := retrieveContent(t, "secret.json"); !bytes.Equal(got, secret) {
+		t.Errorf("secret.json = %s, want %s", got, secret)
+	}
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/config"
+	"myapp/models"
+	"os"
+	"path/filepath"
+)
+
+// 加密对象的格式：4字节的格式标记，之后是按 encryptChunkSize 分块的明文各自以AES-256-GCM加密的结果。
+// 每个对象使用随机生成的数据密钥，分块的nonce为块序号，最后一块以附加数据标记，防止截断或调换分块。
+const (
+	encryptChunkSize = 64 * 1024
+	encryptOverhead  = 16 // GCM认证标签的长度
+)
+
+var encryptMagic = []byte("MAE1")
+
+// ErrUnknownStorageKey 表示对象使用的主密钥不在配置中
+var ErrUnknownStorageKey = errors.New("unknown storage encryption key")
+
+// encryptedSize 返回size字节的明文加密后的长度
+func encryptedSize(size int64) int64 {
+	return int64(len(encryptMagic)) + size + int64(encryptChunks(size))*encryptOverhead
+}
+
+// encryptChunks 返回size字节的明文的分块数，空文件也有一个块
+func encryptChunks(size int64) uint64 {
+	return max(uint64((size+encryptChunkSize-1)/encryptChunkSize), 1)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	return nonce
+}
+
+func chunkAAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// newDataKey 生成对象的数据密钥，返回密钥及以当前主密钥加密后的结果；未配置主密钥时返回nil
+func newDataKey(objectKey string) (key []byte, keyID, wrapped string, err error) {
+	keyID = config.Conf.StorageKeyID
+	if keyID == "" {
+		return nil, "", "", nil
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", "", fmt.Errorf("fail to generate data key: %w", err)
+	}
+	wrapped, err = wrapDataKey(keyID, objectKey, key)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return key, keyID, wrapped, nil
+}
+
+// dataKeyID 返回数据密钥的标识，随对象保存在元数据中，用于判断对象内容使用的是哪个数据密钥
+func dataKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// wrapDataKey 以主密钥keyID加密数据密钥，对象键作为附加数据，数据密钥不能挪用到其他对象
+func wrapDataKey(keyID, objectKey string, key []byte) (string, error) {
+	aead, err := newGCM(config.Conf.StorageKeys[keyID])
+	if err != nil {
+		return "", fmt.Errorf("fail to wrap data key: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("fail to wrap data key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, key, []byte(objectKey))), nil
+}
+
+// unwrapDataKey 以对象记录的主密钥解密数据密钥
+func unwrapDataKey(object *models.StoredObject) ([]byte, error) {
+	master, ok := config.Conf.StorageKeys[object.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (object %s)", ErrUnknownStorageKey, object.KeyID, object.ObjectKey)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(object.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("fail to decode data key of %s: %w", object.ObjectKey, err)
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("data key of %s is truncated", object.ObjectKey)
+	}
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(object.ObjectKey))
+	if err != nil {
+		return nil, fmt.Errorf("fail to unwrap data key of %s with key %s: %w", object.ObjectKey, object.KeyID, err)
+	}
+	return key, nil
+}
+
+// encryptReader 从src读取size字节明文，输出加密后的对象内容
+type encryptReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	chunks uint64
+	index  uint64
+	plain  []byte
+	sealed []byte
+	buf    []byte
+}
+
+func newEncryptReader(src io.Reader, key []byte, size int64) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		src:    io.LimitReader(src, size),
+		aead:   aead,
+		chunks: encryptChunks(size),
+		plain:  make([]byte, encryptChunkSize),
+		sealed: make([]byte, 0, encryptChunkSize+encryptOverhead),
+		buf:    encryptMagic,
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.index == r.chunks {
+			return 0, io.EOF
+		}
+		last := r.index == r.chunks-1
+		n, err := io.ReadFull(r.src, r.plain)
+		if err != nil && !(last && (err == io.EOF || err == io.ErrUnexpectedEOF)) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.buf = r.aead.Seal(r.sealed[:0], chunkNonce(r.index), r.plain[:n], chunkAAD(last))
+		r.index++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// decryptReader 从src读取加密的对象内容，输出size字节明文；内容被篡改或截断时返回包装了 ErrChecksumMismatch 的错误
+type decryptReader struct {
+	key       string
+	src       io.Reader
+	aead      cipher.AEAD
+	chunks    uint64
+	index     uint64
+	remaining int64
+	sealed    []byte
+	plain     []byte
+	buf       []byte
+	started   bool
+}
+
+func newDecryptReader(src io.Reader, object *models.StoredObject) (io.Reader, error) {
+	key, err := unwrapDataKey(object)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		key:       object.ObjectKey,
+		src:       src,
+		aead:      aead,
+		chunks:    encryptChunks(object.Size),
+		remaining: object.Size,
+		sealed:    make([]byte, encryptChunkSize+encryptOverhead),
+		plain:     make([]byte, 0, encryptChunkSize),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if !r.started {
+		magic := make([]byte, len(encryptMagic))
+		if _, err := io.ReadFull(r.src, magic); err != nil || string(magic) != string(encryptMagic) {
+			return 0, r.corrupted("missing encryption header", err)
+		}
+		r.started = true
+	}
+	for len(r.buf) == 0 {
+		if r.index == r.chunks {
+			return 0, io.EOF
+		}
+		n := min(r.remaining, encryptChunkSize) + encryptOverhead
+		if _, err := io.ReadFull(r.src, r.sealed[:n]); err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return 0, err
+			}
+			return 0, r.corrupted("truncated", err)
+		}
+		plain, err := r.aead.Open(r.plain[:0], chunkNonce(r.index), r.sealed[:n], chunkAAD(r.index == r.chunks-1))
+		if err != nil {
+			return 0, r.corrupted(fmt.Sprintf("chunk %d failed authentication", r.index), err)
+		}
+		r.buf = plain
+		r.remaining -= int64(len(plain))
+		r.index++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *decryptReader) corrupted(reason string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %s is %s: %v", ErrChecksumMismatch, r.key, reason, err)
+	}
+	return fmt.Errorf("%w: %s is %s", ErrChecksumMismatch, r.key, reason)
+}
+
+// RotateObjectKey 使对象改用当前主密钥：已加密的对象只重新加密数据密钥，对象内容不变；
+// 未加密的对象取回后加密重新上传。未配置主密钥或对象已使用当前主密钥时不做任何事
+func RotateObjectKey(object *models.StoredObject) error {
+	activeID := config.Conf.StorageKeyID
+	if activeID == "" || object.KeyID == activeID {
+		return nil
+	}
+	if object.KeyID == "" {
+		path, err := RetrieveFromBucket(object.ObjectKey)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(path))
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("fail to open %s: %w", path, err)
+		}
+		defer file.Close()
+		// 取回期间对象被覆盖时不以旧内容重新上传
+		current, err := FindObjectChecksum(object.ObjectKey)
+		if err != nil || current == nil || current.KeyID != "" || current.SHA256 != object.SHA256 {
+			return err
+		}
+		return StoreObject(object.ObjectKey, file)
+	}
+
+	key, err := unwrapDataKey(object)
+	if err != nil {
+		return err
+	}
+	wrapped, err := wrapDataKey(activeID, object.ObjectKey, key)
+	if err != nil {
+		return err
+	}
+	// 以原密钥为条件更新，对象在此期间被覆盖时不改写新的数据密钥
+	if err := config.Conf.DB.Model(&models.StoredObject{}).
+		Where("id = ? AND key_id = ? AND wrapped_key = ?", object.ID, object.KeyID, object.WrappedKey).
+		Updates(map[string]interface{}{"key_id": activeID, "wrapped_key": wrapped}).Error; err != nil {
+		return fmt.Errorf("fail to rotate key of %s: %w", object.ObjectKey, err)
+	}
+	return nil
+}
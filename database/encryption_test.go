@@ -0,0 +1,159 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"myapp/config"
+	"myapp/models"
+	"testing"
+)
+
+// withStorageKeys 为测试配置随机生成的主密钥，第一个用于加密新对象
+func withStorageKeys(t *testing.T, ids ...string) {
+	t.Helper()
+	keys, activeID := config.Conf.StorageKeys, config.Conf.StorageKeyID
+	t.Cleanup(func() { config.Conf.StorageKeys, config.Conf.StorageKeyID = keys, activeID })
+
+	config.Conf.StorageKeys = map[string][]byte{}
+	for _, id := range ids {
+		config.Conf.StorageKeys[id] = randomBytes(t, 32)
+	}
+	config.Conf.StorageKeyID = ids[0]
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// sealObject 以当前主密钥加密plain，返回对象内容及上传时会保存的记录
+func sealObject(t *testing.T, objectKey string, plain []byte) ([]byte, *models.StoredObject) {
+	t.Helper()
+	dataKey, keyID, wrapped, err := newDataKey(objectKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := newEncryptReader(bytes.NewReader(plain), dataKey, int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed, &models.StoredObject{ObjectKey: objectKey, Size: int64(len(plain)), KeyID: keyID, WrappedKey: wrapped}
+}
+
+// openSealed 解密对象内容，返回出错前已解密的部分
+func openSealed(sealed []byte, object *models.StoredObject) ([]byte, error) {
+	reader, err := newDecryptReader(bytes.NewReader(sealed), object)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	withStorageKeys(t, "k1")
+	for _, size := range []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3*encryptChunkSize + 17} {
+		plain := randomBytes(t, size)
+		sealed, object := sealObject(t, "work1.splat", plain)
+		if got, want := int64(len(sealed)), encryptedSize(int64(size)); got != want {
+			t.Errorf("size %d: encrypted length = %d, want %d", size, got, want)
+		}
+		got, err := openSealed(sealed, object)
+		if err != nil {
+			t.Errorf("size %d: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted content differs from plaintext", size)
+		}
+	}
+}
+
+func TestEncryptUsesFreshDataKeyPerObject(t *testing.T) {
+	withStorageKeys(t, "k1")
+	plain := randomBytes(t, 100)
+	first, firstObject := sealObject(t, "work1.splat", plain)
+	second, secondObject := sealObject(t, "work1.splat", plain)
+	if bytes.Equal(first, second) || firstObject.WrappedKey == secondObject.WrappedKey {
+		t.Fatal("the same plaintext was encrypted with the same data key twice")
+	}
+	// 数据密钥与内容一一对应，不能用另一次上传的记录解密
+	if _, err := openSealed(first, secondObject); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("decrypt with another upload's key: err = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	withStorageKeys(t, "k1")
+	plain := randomBytes(t, 2*encryptChunkSize+100)
+	sealed, object := sealObject(t, "work1.splat", plain)
+	header := len(encryptMagic)
+	chunk := encryptChunkSize + encryptOverhead
+	clone := func() []byte { return append([]byte{}, sealed...) }
+
+	t.Run("modified second chunk", func(t *testing.T) {
+		tampered := clone()
+		tampered[header+chunk+10] ^= 1
+		got, err := openSealed(tampered, object)
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("err = %v, want ErrChecksumMismatch", err)
+		}
+		// 被篡改的块之前的内容照常输出，被篡改的块不输出
+		if !bytes.Equal(got, plain[:encryptChunkSize]) {
+			t.Errorf("got %d bytes before the error, want the first chunk only", len(got))
+		}
+	})
+	t.Run("missing header", func(t *testing.T) {
+		if _, err := openSealed(sealed[header:], object); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("err = %v, want ErrChecksumMismatch", err)
+		}
+	})
+	t.Run("truncated", func(t *testing.T) {
+		if _, err := openSealed(sealed[:len(sealed)-1], object); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("err = %v, want ErrChecksumMismatch", err)
+		}
+	})
+	t.Run("last chunk dropped and size rewritten", func(t *testing.T) {
+		// 只剩完整的块时，最后一块没有结尾标记，不能当作更短的对象解密
+		shortened := *object
+		shortened.Size = 2 * encryptChunkSize
+		if _, err := openSealed(sealed[:header+2*chunk], &shortened); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("err = %v, want ErrChecksumMismatch", err)
+		}
+	})
+	t.Run("chunks swapped", func(t *testing.T) {
+		swapped := append([]byte{}, sealed[:header]...)
+		swapped = append(swapped, sealed[header+chunk:header+2*chunk]...)
+		swapped = append(swapped, sealed[header:header+chunk]...)
+		swapped = append(swapped, sealed[header+2*chunk:]...)
+		if _, err := openSealed(swapped, object); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("err = %v, want ErrChecksumMismatch", err)
+		}
+	})
+}
+
+func TestUnwrapDataKey(t *testing.T) {
+	withStorageKeys(t, "k1")
+	_, object := sealObject(t, "work1.splat", []byte("splat"))
+
+	moved := *object
+	moved.ObjectKey = "work2.splat"
+	if _, err := unwrapDataKey(&moved); err == nil {
+		t.Error("data key of work1.splat unwrapped for work2.splat")
+	}
+
+	retired := *object
+	retired.KeyID = "k0"
+	if _, err := unwrapDataKey(&retired); !errors.Is(err, ErrUnknownStorageKey) {
+		t.Errorf("err = %v, want ErrUnknownStorageKey", err)
+	}
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"myapp/config"
 	"myapp/models"
+	"myapp/services"
 	"myapp/utils"
 	"net/http"
 
@@ -90,6 +92,12 @@ func Login(c *gin.Context) {
 	var user models.User
 	// 根据提供的标识符（用户名或邮箱）查询用户信息
 	if err := config.Conf.DB.Where("account = ? OR email = ?", credentials.Identifier, credentials.Identifier).First(&user).Error; err != nil {
+		// 已注销的账号在宽限期内提示可以恢复
+		if deleted, err := services.FindDeletedAccount(credentials.Identifier); err == nil &&
+			bcrypt.CompareHashAndPassword([]byte(deleted.Password), []byte(credentials.Password)) == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "账号已注销，可在彻底删除前恢复", "purge_at": deleted.PurgeAt})
+			return
+		}
 		// 如果查询失败或用户不存在，返回401错误响应
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名/邮箱错误"})
 		return
@@ -114,51 +122,66 @@ func Login(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"token": token})
 }
 
-// DeleteUser 删除用户
-// 该函数接收一个 gin.Context 参数，其中包含用户ID
-// 函数通过数据库事务删除用户，并返回删除结果
+// DeleteUser 注销当前用户的账号
+// 账号及其视频、作品立即不可访问，宽限期（ACCOUNT_DELETION_GRACE_DAYS）内可通过 RestoreUser 恢复，
+// 之后由后台任务彻底删除全部记录与存储的文件
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
 func DeleteUser(c *gin.Context) {
-	// 获取用户ID
-	userID, exists := c.Get("userID")
-	if !exists {
-		// 如果用户未认证，返回未授权错误
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未认证的用户"})
+	user, ok := checkUser(c)
+	if !ok {
 		return
 	}
 
-	var user models.User
-	if err := config.Conf.DB.Where("id = ?", userID).First(&user).Error; err != nil {
-		// 如果查询用户失败，返回内部服务器错误
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询用户失败"})
+	purgeAt, err := services.RequestAccountDeletion(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除用户失败"})
 		return
 	}
 
-	// 开启事务
-	tx := config.Conf.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			// 如果发生异常，回滚事务
-			tx.Rollback()
-		}
-	}()
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "用户删除成功",
+		"purge_at": purgeAt,
+	})
+}
 
-	// 删除用户
-	result := tx.Where("id = ?", userID).Delete(&models.User{})
-	if result.Error != nil {
-		// 如果删除过程中发生错误，回滚事务并返回错误信息
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除用户失败"})
+// RestoreUser 在宽限期内恢复已注销的账号，请求体与登录相同，恢复后返回新的Token
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func RestoreUser(c *gin.Context) {
+	var credentials struct {
+		Identifier string `json:"identifier" binding:"required"` // 用户名或邮箱
+		Password   string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 提交事务
-	if err := tx.Commit().Error; err != nil {
-		// 如果事务提交失败，回滚事务并返回错误信息
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "事务提交失败"})
+	user, err := services.FindDeletedAccount(credentials.Identifier)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有可恢复的账号"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "密码错误"})
+		return
+	}
+	if err := services.RestoreAccount(user); err != nil {
+		if errors.Is(err, services.ErrAccountNotRestorable) {
+			c.JSON(http.StatusGone, gin.H{"error": "账号已超过可恢复的期限"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复账号失败"})
 		return
 	}
 
-	// 返回删除成功信息
-	c.JSON(http.StatusOK, gin.H{"message": "用户删除成功"})
+	token, err := utils.GenerateToken(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "账号已恢复", "token": token})
 }
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetWorkCameras 返回作品训练时保存的 cameras.json
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func GetWorkCameras(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+	rev, err := services.CurrentRevision(work)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rev.CamerasKey == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该作品没有相机姿态"})
+		return
+	}
+
+	camerasPath, err := database.RetrieveFromBucket(rev.CamerasKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to retrieve cameras: %v", err)})
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(camerasPath))
+	c.File(camerasPath)
+}
+
+type cameraPresetInfo struct {
+	PresetID   uint      `json:"preset_id"`
+	Name       string    `json:"name"`
+	ViewMatrix []float64 `json:"view_matrix"`
+}
+
+// ListCameraPresets 返回作品的相机预设及训练相机姿态，查看器启动时以此替代内置的默认相机
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func ListCameraPresets(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	var presets []models.CameraPreset
+	if err := config.Conf.DB.Where("work_id = ?", work.ID).Order("id").Find(&presets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "相机预设查询失败"})
+		return
+	}
+	presetInfos := make([]cameraPresetInfo, 0, len(presets))
+	for _, preset := range presets {
+		info := cameraPresetInfo{PresetID: preset.ID, Name: preset.Name}
+		if err := json.Unmarshal([]byte(preset.ViewMatrix), &info.ViewMatrix); err != nil {
+			continue
+		}
+		presetInfos = append(presetInfos, info)
+	}
+
+	// 训练相机姿态读取失败时只返回预设
+	cameras := []services.CameraPose{}
+	if rev, err := services.CurrentRevision(work); err == nil && rev.CamerasKey != "" {
+		if camerasPath, err := database.RetrieveFromBucket(rev.CamerasKey); err == nil {
+			if poses, err := services.ReadCameraPoses(camerasPath); err == nil {
+				cameras = poses
+			}
+			os.RemoveAll(filepath.Dir(camerasPath))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "相机预设查询成功",
+		"presets": presetInfos,
+		"cameras": cameras,
+	})
+}
+
+// AddCameraPreset 为作品添加一个命名相机预设
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID，请求体包含 name 与 16 个元素的 view_matrix
+func AddCameraPreset(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	var presetInfo struct {
+		Name       string    `json:"name"`
+		ViewMatrix []float64 `json:"view_matrix"`
+	}
+	if err := c.ShouldBindJSON(&presetInfo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if presetInfo.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "预设名称不能为空"})
+		return
+	}
+	if len(presetInfo.ViewMatrix) != 16 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "view_matrix 必须包含16个元素"})
+		return
+	}
+
+	viewMatrix, _ := json.Marshal(presetInfo.ViewMatrix)
+	preset := models.CameraPreset{
+		WorkID:     work.ID,
+		Name:       presetInfo.Name,
+		ViewMatrix: string(viewMatrix),
+	}
+	if err := config.Conf.DB.Create(&preset).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to create preset:%v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "Camera preset created successfully",
+		"preset_id": preset.ID,
+	})
+}
+
+// DeleteCameraPreset 删除作品的一个相机预设
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID，:presetId 为预设ID
+func DeleteCameraPreset(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	result := config.Conf.DB.Where("id = ? AND work_id = ?", c.Param("presetId"), work.ID).Delete(&models.CameraPreset{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除相机预设失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "相机预设不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "相机预设删除成功"})
+}
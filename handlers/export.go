@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportWork 创建后台任务，将作品导出为点云（ply/xyz/las）或粗略网格（mesh）
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID，请求体包含 format
+func ExportWork(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	var exportInfo services.ExportPayload
+	if err := c.ShouldBindJSON(&exportInfo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := services.ExportFormats[exportInfo.Format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的导出格式"})
+		return
+	}
+	if work.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "作品尚未完成"})
+		return
+	}
+
+	job, err := services.EnqueueJob(services.JobTypeExport, user.ID, work.ID, exportInfo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Export job queued",
+		"job_id":  job.ID,
+	})
+}
+
+// DownloadExport 下载已完成的导出文件
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID，:jobId 为导出任务ID
+func DownloadExport(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	var job models.Job
+	if err := config.Conf.DB.Where("id = ? AND work_id = ? AND type = ?", c.Param("jobId"), work.ID, services.JobTypeExport).
+		First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "导出任务不存在"})
+		return
+	}
+	if job.Status != services.JobCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "导出尚未完成", "status": job.Status})
+		return
+	}
+	if job.ArtifactsExpiredAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "导出文件已过期，请重新导出"})
+		return
+	}
+
+	var result services.ExportResult
+	if err := json.Unmarshal([]byte(job.Result), &result); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出结果无效"})
+		return
+	}
+
+	exportPath, err := database.RetrieveFromBucket(result.Object)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to retrieve export: %v", err)})
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(exportPath))
+	c.FileAttachment(exportPath, fmt.Sprintf("%s_%s%s", work.WorkName, result.Format, filepath.Ext(result.Object)))
+}
+
+// ExportAccount 创建后台任务，将当前用户的全部视频、作品、训练日志及元数据打包为zip
+// 已有未完成的导出任务时返回该任务
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func ExportAccount(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+
+	var pending models.Job
+	err := config.Conf.DB.Where("user_id = ? AND type = ? AND status IN ?", user.ID, services.JobTypeAccountExport,
+		[]string{services.JobQueued, services.JobRunning}).First(&pending).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "已有进行中的导出任务", "job_id": pending.ID})
+		return
+	}
+
+	job, err := services.EnqueueJob(services.JobTypeAccountExport, user.ID, 0, struct{}{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Account export job queued",
+		"job_id":  job.ID,
+	})
+}
+
+// GetAccountExport 返回账号导出任务的状态，完成后返回压缩包大小、过期时间及无需登录的下载链接
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :jobId 为导出任务ID
+func GetAccountExport(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+
+	var job models.Job
+	if err := config.Conf.DB.Where("id = ? AND user_id = ? AND type = ?", c.Param("jobId"), user.ID, services.JobTypeAccountExport).
+		First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "导出任务不存在或已过期"})
+		return
+	}
+	if job.Status != services.JobCompleted {
+		c.JSON(http.StatusOK, gin.H{"job_id": job.ID, "status": job.Status, "error": job.ErrorLog})
+		return
+	}
+
+	result, err := services.DecodeAccountExportResult(&job)
+	if errors.Is(err, services.ErrExportLinkInvalid) {
+		c.JSON(http.StatusGone, gin.H{"error": "导出文件已过期"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出结果无效"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":       job.ID,
+		"status":       job.Status,
+		"size":         result.Size,
+		"expires_at":   result.ExpiresAt,
+		"download_url": services.AccountExportURL(job.ID, result.ExpiresAt),
+	})
+}
+
+// DownloadAccountExport 通过签名链接下载账号导出的压缩包，不需要登录
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :jobId 为导出任务ID，查询参数 expires 与 signature 来自 GetAccountExport 返回的链接
+func DownloadAccountExport(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "导出任务不存在"})
+		return
+	}
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+	result, err := services.VerifyAccountExportLink(uint(jobID), expires, c.Query("signature"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "下载链接无效或已过期"})
+		return
+	}
+
+	reader, err := database.OpenObject(result.Object)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to retrieve export: %v", err)})
+		return
+	}
+	defer reader.Close()
+	// 内容与记录的校验和不一致时停止发送，客户端得到不完整的响应而不是损坏的压缩包
+	c.DataFromReader(http.StatusOK, result.Size, "application/zip", reader, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="account_export_%d.zip"`, jobID),
+	})
+	if err := c.Errors.Last(); err != nil {
+		log.Printf("account export %d: %v", jobID, err)
+	}
+}
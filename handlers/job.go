@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"myapp/config"
+	"myapp/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJob 查询当前用户的后台任务状态
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为任务ID
+func GetJob(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+
+	var job models.Job
+	if err := config.Conf.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":      job.ID,
+		"type":        job.Type,
+		"status":      job.Status,
+		"work_id":     job.WorkID,
+		"result":      job.Result,
+		"error":       job.ErrorLog,
+		"created_at":  job.CreatedAt,
+		"started_at":  job.StartedAt,
+		"finished_at": job.FinishedAt,
+	})
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"myapp/config"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// logFollowInterval 是跟踪模式下检查新日志的间隔
+const logFollowInterval = time.Second
+
+// GetWorkLogs 返回作品训练任务的日志（纯文本），默认为最近一次训练任务
+// 查询参数 job_id 指定任务，offset 指定起始位置（字节），follow=true 时持续输出新日志直到任务结束
+// 响应头 X-Log-Offset 为本次输出结束的位置，客户端可据此继续读取
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func GetWorkLogs(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	var jobID, offset uint64
+	var err error
+	if value := c.Query("job_id"); value != "" {
+		if jobID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+			return
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.ParseUint(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的日志位置"})
+			return
+		}
+	}
+
+	job, err := services.FindWorkLogJob(work.ID, uint(jobID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "任务查询失败"})
+		return
+	}
+
+	c.Header("X-Job-Id", strconv.FormatUint(uint64(job.ID), 10))
+	if c.Query("follow") != "true" {
+		var buf bytes.Buffer
+		next, err := services.ReadJobLog(job, int64(offset), &buf)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to read log: %v", err)})
+			return
+		}
+		c.Header("X-Log-Offset", strconv.FormatInt(next, 10))
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+		return
+	}
+
+	// 跟踪模式：流式输出新写入的日志，任务结束（不再排队或运行）且日志读完后结束
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "no-cache")
+	position := int64(offset)
+	c.Stream(func(w io.Writer) bool {
+		next, err := services.ReadJobLog(job, position, w)
+		if err != nil {
+			log.Printf("job %d: fail to read log: %v", job.ID, err)
+			return false
+		}
+		position = next
+		if job.Status != services.JobQueued && job.Status != services.JobRunning {
+			return false
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-time.After(logFollowInterval):
+		}
+		var latest models.Job
+		if err := config.Conf.DB.First(&latest, job.ID).Error; err != nil {
+			return false
+		}
+		job = &latest
+		return true
+	})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNotificationPreferences 返回当前用户的邮件通知偏好及支持的语言
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func GetNotificationPreferences(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	pref, err := services.FindNotificationPreference(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "通知设置查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, notificationPreferenceResponse(user, &pref))
+}
+
+// UpdateNotificationPreferences 更新当前用户的邮件通知偏好
+// 请求体包含 email_work_completed、email_work_failed（是否接收对应邮件）与 language（zh/en，为空时使用默认语言），
+// 未提供的字段保持不变
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func UpdateNotificationPreferences(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	var prefReq struct {
+		EmailWorkCompleted *bool   `json:"email_work_completed"`
+		EmailWorkFailed    *bool   `json:"email_work_failed"`
+		Language           *string `json:"language"`
+	}
+	if err := c.ShouldBindJSON(&prefReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pref, err := services.FindNotificationPreference(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "通知设置查询失败"})
+		return
+	}
+	if prefReq.EmailWorkCompleted != nil {
+		pref.EmailOptOutCompleted = !*prefReq.EmailWorkCompleted
+	}
+	if prefReq.EmailWorkFailed != nil {
+		pref.EmailOptOutFailed = !*prefReq.EmailWorkFailed
+	}
+	if prefReq.Language != nil {
+		pref.Language = *prefReq.Language
+	}
+	if err := services.SaveNotificationPreference(&pref); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, notificationPreferenceResponse(user, &pref))
+}
+
+func notificationPreferenceResponse(user *models.User, pref *models.NotificationPreference) gin.H {
+	return gin.H{
+		"email":                user.Email,
+		"email_enabled":        services.EmailEnabled(),
+		"email_work_completed": !pref.EmailOptOutCompleted,
+		"email_work_failed":    !pref.EmailOptOutFailed,
+		"language":             pref.Language,
+		"languages":            services.EmailLanguages,
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// findOwnedWork 根据路径参数 :id 查找属于当前用户的作品
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+//	user *models.User: 当前用户
+//
+// 返回值:
+//
+//	*models.Work: 作品信息的指针
+//	bool: 是否成功找到作品，失败时已写入错误响应
+func findOwnedWork(c *gin.Context, user *models.User) (*models.Work, bool) {
+	var work models.Work
+	if err := config.Conf.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&work).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "作品不存在"})
+		return nil, false
+	}
+	return &work, true
+}
+
+// GetWorkPreview 返回作品的环绕预览视频（MP4或动态WebP），用于在聊天中分享
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func GetWorkPreview(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	if work.PreviewStatus != "completed" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":          "预览视频尚未生成",
+			"preview_status": work.PreviewStatus,
+		})
+		return
+	}
+
+	rev, err := services.CurrentRevision(work)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	previewPath, err := database.RetrieveFromBucket(rev.PreviewKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to retrieve preview: %v", err)})
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(previewPath))
+	c.File(previewPath)
+}
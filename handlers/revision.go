@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"myapp/config"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRevisions 返回作品的版本历史及各版本的来源
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func ListRevisions(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	var revisions []models.WorkRevision
+	if err := config.Conf.DB.Where("work_id = ?", work.ID).Order("number").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "版本查询失败"})
+		return
+	}
+
+	// 将父版本ID换算为版本号
+	numbers := make(map[uint]int, len(revisions))
+	for _, rev := range revisions {
+		numbers[rev.ID] = rev.Number
+	}
+
+	type revisionInfo struct {
+		Number         int       `json:"number"`
+		Operation      string    `json:"operation"`
+		SourceVideoID  uint      `json:"source_video_id,omitempty"`
+		Iterations     string    `json:"iterations,omitempty"`
+		ParentRevision int       `json:"parent_revision,omitempty"`
+		Params         string    `json:"params,omitempty"`
+		HasCameras     bool      `json:"has_cameras"`
+		HasPreview     bool      `json:"has_preview"`
+		Current        bool      `json:"current"`
+		CreatedAt      time.Time `json:"created_at"`
+	}
+	revisionInfos := make([]revisionInfo, 0, len(revisions))
+	for _, rev := range revisions {
+		revisionInfos = append(revisionInfos, revisionInfo{
+			Number:         rev.Number,
+			Operation:      rev.Operation,
+			SourceVideoID:  rev.SourceVideoID,
+			Iterations:     rev.Iterations,
+			ParentRevision: numbers[rev.ParentRevisionID],
+			Params:         rev.Params,
+			HasCameras:     rev.CamerasKey != "",
+			HasPreview:     rev.PreviewKey != "",
+			Current:        rev.ID == work.CurrentRevisionID,
+			CreatedAt:      rev.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "版本查询成功",
+		"revisions": revisionInfos,
+	})
+}
+
+// findRevision 根据路径参数 :number 查找作品的版本
+func findRevision(c *gin.Context, work *models.Work) (*models.WorkRevision, bool) {
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return nil, false
+	}
+	rev, err := services.FindRevision(work.ID, number)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return nil, false
+	}
+	return rev, true
+}
+
+// GetRevision 下载作品指定版本的.splat文件（format=glb 时转换为.glb）
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID，:number 为版本号
+func GetRevision(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+	rev, ok := findRevision(c, work)
+	if !ok {
+		return
+	}
+	serveSplat(c, rev.SplatKey, fmt.Sprintf("work%d_r%d", work.ID, rev.Number))
+}
+
+// PromoteRevision 将作品的历史版本设为当前版本
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID，:number 为版本号
+func PromoteRevision(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+	rev, ok := findRevision(c, work)
+	if !ok {
+		return
+	}
+
+	if _, err := services.PromoteRevision(work.ID, rev.Number); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// 该版本尚无预览时补充生成
+	if rev.PreviewKey == "" {
+		services.StartWorkPreview(work.UserID, work.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Revision promoted successfully",
+		"revision": rev.Number,
+	})
+}
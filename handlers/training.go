@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"myapp/config"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListTrainingPresets 返回可选的训练参数预设及各参数的取值范围
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func ListTrainingPresets(c *gin.Context) {
+	if _, ok := checkUser(c); !ok {
+		return
+	}
+
+	var presets []models.TrainingPreset
+	if err := config.Conf.DB.Order("id").Find(&presets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "训练预设查询失败"})
+		return
+	}
+
+	type presetInfo struct {
+		Name        string               `json:"name"`
+		Description string               `json:"description"`
+		Params      services.TrainParams `json:"params"`
+	}
+	presetInfos := make([]presetInfo, 0, len(presets))
+	for _, preset := range presets {
+		info := presetInfo{Name: preset.Name, Description: preset.Description}
+		if err := json.Unmarshal([]byte(preset.Params), &info.Params); err != nil {
+			continue
+		}
+		presetInfos = append(presetInfos, info)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "训练预设查询成功",
+		"default": services.DefaultTrainingPreset,
+		"presets": presetInfos,
+		"limits": gin.H{
+			"iterations":       []int{services.MinTrainIterations, services.MaxTrainIterations},
+			"sh_degree":        []int{0, services.MaxSHDegree},
+			"resolution_scale": []int{1, 2, 4, 8},
+			"position_lr":      []float64{services.MinPositionLR, services.MaxPositionLR},
+		},
+	})
+}
+
+// trainParamsRequest 是创建训练任务时可选的参数字段
+type trainParamsRequest struct {
+	Preset string          `json:"preset"`
+	Params json.RawMessage `json:"params"`
+	// 兼容旧接口：以字符串传入的迭代次数
+	Iterations string `json:"iterations"`
+}
+
+// resolveTrainParams 根据请求中的预设与参数对象得到经过校验的训练参数，失败时返回400。
+// base 不为空且请求未指定预设时，以 base 为基础叠加参数。
+func resolveTrainParams(c *gin.Context, req trainParamsRequest, base *services.TrainParams) (services.TrainParams, bool) {
+	var params services.TrainParams
+	var err error
+	if base != nil && req.Preset == "" {
+		params, err = services.OverlayTrainParams(*base, req.Params)
+	} else {
+		params, err = services.ResolveTrainParams(req.Preset, req.Params)
+	}
+	if err == nil && req.Iterations != "" {
+		var iterations int
+		if iterations, err = strconv.Atoi(req.Iterations); err == nil {
+			if len(req.Params) == 0 || string(req.Params) == "null" {
+				// 只给出迭代次数的旧接口请求，致密化参数随迭代次数收紧
+				params = params.WithIterations(iterations)
+			} else {
+				params.Iterations = iterations
+			}
+			err = params.Validate()
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return services.TrainParams{}, false
+	}
+	return params, true
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"myapp/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// standardParams 与内置的 standard 预设相同，作为叠加参数的基础，测试无需数据库
+var standardParams = services.TrainParams{
+	Iterations:      30000,
+	DensifyInterval: 100,
+	DensifyUntil:    15000,
+	SHDegree:        3,
+	ResolutionScale: 2,
+	PositionLR:      1.6e-4,
+}
+
+func resolveForTest(t *testing.T, req trainParamsRequest) (services.TrainParams, *httptest.ResponseRecorder, bool) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	base := standardParams
+	params, ok := resolveTrainParams(c, req, &base)
+	return params, w, ok
+}
+
+func TestResolveTrainParamsKeepsBaseWithoutOverrides(t *testing.T) {
+	params, w, ok := resolveForTest(t, trainParamsRequest{})
+	if !ok {
+		t.Fatalf("rejected with %d: %s", w.Code, w.Body)
+	}
+	if params != standardParams {
+		t.Errorf("params = %+v, want %+v", params, standardParams)
+	}
+}
+
+func TestResolveTrainParamsOverlaysRequest(t *testing.T) {
+	params, w, ok := resolveForTest(t, trainParamsRequest{
+		Params:     json.RawMessage(`{"densify_until":5000,"white_background":true}`),
+		Iterations: "7000",
+	})
+	if !ok {
+		t.Fatalf("rejected with %d: %s", w.Code, w.Body)
+	}
+	want := standardParams
+	want.Iterations, want.DensifyUntil, want.WhiteBackground = 7000, 5000, true
+	if params != want {
+		t.Errorf("params = %+v, want %+v", params, want)
+	}
+}
+
+func TestResolveTrainParamsRejectsInvalidRequest(t *testing.T) {
+	requests := map[string]trainParamsRequest{
+		"iterations not a number":       {Iterations: "many"},
+		"iterations below minimum":      {Iterations: "500", Params: json.RawMessage(`{"densify_until":0}`)},
+		"iterations above maximum":      {Iterations: "200000"},
+		"densify after last iteration":  {Iterations: "7000", Params: json.RawMessage(`{"densify_until":20000}`)},
+		"unknown field":                 {Params: json.RawMessage(`{"epochs":10}`)},
+		"unsupported resolution scale":  {Params: json.RawMessage(`{"resolution_scale":3}`)},
+		"params of the wrong json type": {Params: json.RawMessage(`[1,2]`)},
+	}
+	for name, req := range requests {
+		t.Run(name, func(t *testing.T) {
+			_, w, ok := resolveForTest(t, req)
+			if ok || w.Code != http.StatusBadRequest {
+				t.Errorf("ok = %v, status = %d, want 400", ok, w.Code)
+			}
+		})
+	}
+}
+
+func TestResolveTrainParamsClampsLegacyIterations(t *testing.T) {
+	// 旧接口只传迭代次数，少于预设的停止致密化迭代时随之收紧，仍能通过校验
+	params, w, ok := resolveForTest(t, trainParamsRequest{Iterations: "7000"})
+	if !ok {
+		t.Fatalf("rejected with %d: %s", w.Code, w.Body)
+	}
+	if params.Iterations != 7000 || params.DensifyUntil != 7000 || params.DensifyInterval != standardParams.DensifyInterval {
+		t.Errorf("params = %+v, want iterations and densify_until 7000", params)
+	}
+
+	params, _, ok = resolveForTest(t, trainParamsRequest{Iterations: "50000", Params: json.RawMessage("null")})
+	if !ok || params.Iterations != 50000 || params.DensifyUntil != standardParams.DensifyUntil {
+		t.Errorf("params = %+v, want densify_until unchanged when iterations grow", params)
+	}
+}
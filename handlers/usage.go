@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUsage 返回当前用户的存储与GPU用量及配额，配额为0表示不限制
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func GetUsage(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	usage, err := services.GetUsage(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "用量查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// checkQuota 处理配额检查的结果：超过配额时返回403，检查失败时返回500
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+//	err error: services.CheckStorageQuota 或 services.CheckTrainingQuota 的返回值
+//
+// 返回值:
+//
+//	bool: 未超过配额时为true
+func checkQuota(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "超过配额", "detail": err.Error()})
+		return false
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "配额检查失败"})
+	return false
+}
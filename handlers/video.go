@@ -1,16 +1,22 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"myapp/config"
-	"myapp/database"
 	"myapp/models"
+	"myapp/services"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // checkUser 检查并返回当前请求的用户信息
@@ -64,54 +70,59 @@ func UploadVideo(c *gin.Context) {
 	fileUUID := uuid.New().String()
 	filePath := filepath.Join("temp", fileUUID, fileUUID+ext)
 
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
+	sum, err := saveUploadedFileWithHash(file, filePath)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "文件保存失败"})
 		return
 	}
 	defer os.RemoveAll(filepath.Dir(filePath))
 
-	tx := config.Conf.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	var video = models.Video{
-		UserID: user.ID,
-		Title:  title,
-	}
-	if err := tx.Create(&video).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to upload video:%v", err),
-		})
+	// 同一用户重复上传相同的视频时，直接返回已有的视频
+	existing, err := services.FindDuplicateVideo(user.ID, sum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "视频查询失败"})
 		return
 	}
-
-	fileReader, err := os.Open(filePath)
-	if err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to open file:%v", err),
-		})
+	if existing != nil {
+		respondDuplicateVideo(c, existing)
 		return
 	}
-	defer fileReader.Close()
-	if err := database.StoreInBucket(fmt.Sprintf("%d", video.ID), "video", fileReader); err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to upload video:%v", err),
-		})
+	if !checkQuota(c, services.CheckStorageQuota(user.ID, file.Size)) {
 		return
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		tx.Rollback()
+	// 视频记录与存储桶中的对象一同提交，任一步失败时都不会留下孤立的数据
+	var video = models.Video{
+		UserID:    user.ID,
+		Title:     title,
+		SizeBytes: file.Size,
+		SHA256:    sum,
+	}
+	err = services.RunStorageTx(func(tx *gorm.DB, stx *services.StorageTx) error {
+		if err := tx.Create(&video).Error; err != nil {
+			return err
+		}
+		video.ObjectKey = fmt.Sprintf("video%d%s", video.ID, ext)
+		if err := tx.Model(&video).Update("object_key", video.ObjectKey).Error; err != nil {
+			return err
+		}
+		return stx.Store(services.EntityVideo, video.ID, video.ObjectKey, filePath)
+	})
+	if err != nil {
+		// 相同的视频同时上传时，唯一索引使后提交的一方失败，返回先上传的视频
+		if existing, findErr := services.FindDuplicateVideo(user.ID, sum); findErr == nil && existing != nil {
+			respondDuplicateVideo(c, existing)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to commit :%v", err),
+			"error": fmt.Sprintf("fail to upload video:%v", err),
 		})
+		return
 	}
+	services.PublishEvent(user.ID, services.EventVideoUploaded, gin.H{
+		"video_id": video.ID,
+		"title":    video.Title,
+	})
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":  "Video uploaded successfully",
@@ -119,6 +130,51 @@ func UploadVideo(c *gin.Context) {
 	})
 }
 
+// respondDuplicateVideo 返回用户已上传的相同视频，不保存新的文件与标题
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+//	existing *models.Video: 内容相同的已有视频
+func respondDuplicateVideo(c *gin.Context, existing *models.Video) {
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Video already uploaded",
+		"video_id":  existing.ID,
+		"title":     existing.Title,
+		"duplicate": true,
+	})
+}
+
+// saveUploadedFileWithHash 将上传的文件保存到dst，并在写入的同时计算SHA-256
+// 参数:
+//
+//	file *multipart.FileHeader: 上传的文件
+//	dst string: 保存的路径，所在目录不存在时创建
+//
+// 返回值:
+//
+//	string: 文件内容的SHA-256（十六进制）
+func saveUploadedFileWithHash(file *multipart.FileHeader, dst string) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return "", err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), src); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // ShowVideo 处理用户视频列表请求，验证用户身份后查询数据库并返回视频信息
 // 参数说明:
 //   - c: *gin.Context Gin框架上下文对象，用于处理HTTP请求和响应
@@ -135,13 +191,15 @@ func ShowVideo(c *gin.Context) {
 	}
 
 	var videoInfos []struct {
-		VideoID uint   `json:"video_id"`
-		Title   string `json:"title"`
+		VideoID   uint       `json:"video_id"`
+		Title     string     `json:"title"`
+		Pinned    bool       `json:"pinned"`
+		ExpiredAt *time.Time `json:"expired_at"`
 	}
-	// 数据库查询操作：获取当前用户的视频ID和标题
+	// 数据库查询操作：获取当前用户的视频ID、标题及保留状态
 	if err := config.Conf.DB.Model(&models.Video{}).
 		Where("user_id = ?", user.ID).
-		Select("id as video_id, title").
+		Select("id as video_id, title, pinned, expired_at").
 		Scan(&videoInfos).Error; err != nil {
 		// 数据库查询错误处理
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "视频查询失败"})
@@ -163,3 +221,37 @@ func ShowVideo(c *gin.Context) {
 		"videos":  videoInfos,
 	})
 }
+
+// PinVideo 固定或取消固定视频，请求体为 {"pinned": true}
+// 固定的视频不会按保留规则删除视频文件；已过期的视频不能再固定
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为视频ID
+func PinVideo(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	var pinReq struct {
+		Pinned *bool `json:"pinned" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&pinReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var video models.Video
+	if err := config.Conf.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&video).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "视频不存在"})
+		return
+	}
+	if video.ExpiredAt != nil && *pinReq.Pinned {
+		c.JSON(http.StatusGone, gin.H{"error": "视频文件已按保留规则删除"})
+		return
+	}
+	if err := config.Conf.DB.Model(&video).Update("pinned", *pinReq.Pinned).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "视频更新失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"video_id": video.ID, "pinned": *pinReq.Pinned})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveUploadedFileWithHash(t *testing.T) {
+	content := bytes.Repeat([]byte("frame data "), 100000)
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("video", "capture.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+	req := httptest.NewRequest("POST", "/videos", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	// 较小的内存上限使上传的文件落在临时文件中，与大视频的情况相同
+	if err := req.ParseMultipartForm(1 << 10); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "upload", "capture.mp4")
+	sum, err := saveUploadedFileWithHash(req.MultipartForm.File["video"][0], dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(content)
+	if sum != hex.EncodeToString(want[:]) {
+		t.Errorf("hash = %s, want %x", sum, want)
+	}
+	if saved, err := os.ReadFile(dst); err != nil || !bytes.Equal(saved, content) {
+		t.Errorf("saved %d bytes, err = %v, want the uploaded %d bytes", len(saved), err, len(content))
+	}
+}
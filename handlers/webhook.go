@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"myapp/config"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookInfo 是返回给用户的webhook信息，不包含签名密钥
+type webhookInfo struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookInfo(webhook *models.Webhook) webhookInfo {
+	events := services.EventTypes
+	if webhook.Events != "" {
+		events = strings.Split(webhook.Events, ",")
+	}
+	return webhookInfo{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    events,
+		Active:    webhook.Active,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+// findOwnedWebhook 查询当前用户的webhook，不存在时返回404
+func findOwnedWebhook(c *gin.Context, user *models.User) (*models.Webhook, bool) {
+	var webhook models.Webhook
+	if err := config.Conf.DB.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&webhook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook不存在"})
+		return nil, false
+	}
+	return &webhook, true
+}
+
+// CreateWebhook 注册webhook，请求体包含 url 与订阅的事件 events（为空表示全部事件）
+// 响应中的 secret 用于验证 X-Webhook-Signature，只返回一次
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func CreateWebhook(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	var webhookReq struct {
+		URL    string   `json:"url" binding:"required"`
+		Events []string `json:"events"`
+	}
+	if err := c.ShouldBindJSON(&webhookReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook, err := services.CreateWebhook(user.ID, webhookReq.URL, webhookReq.Events)
+	if errors.Is(err, services.ErrInvalidWebhook) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": newWebhookInfo(webhook),
+		"secret":  webhook.Secret,
+	})
+}
+
+// ListWebhooks 返回当前用户的webhook列表及可订阅的事件类型
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func ListWebhooks(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	var webhooks []models.Webhook
+	if err := config.Conf.DB.Where("user_id = ?", user.ID).Order("id").Find(&webhooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook查询失败"})
+		return
+	}
+	infos := make([]webhookInfo, 0, len(webhooks))
+	for i := range webhooks {
+		infos = append(infos, newWebhookInfo(&webhooks[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": infos, "events": services.EventTypes})
+}
+
+// DeleteWebhook 删除webhook，尚未投递的事件不再发送
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为webhook ID
+func DeleteWebhook(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	webhook, ok := findOwnedWebhook(c, user)
+	if !ok {
+		return
+	}
+	if err := config.Conf.DB.Delete(webhook).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// ListWebhookDeliveries 返回webhook最近的投递记录，包含尝试次数、响应状态与错误
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为webhook ID
+func ListWebhookDeliveries(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	webhook, ok := findOwnedWebhook(c, user)
+	if !ok {
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := config.Conf.DB.Where("webhook_id = ?", webhook.ID).Order("id DESC").Limit(100).
+		Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "投递记录查询失败"})
+		return
+	}
+
+	type deliveryInfo struct {
+		ID             uint       `json:"id"`
+		EventID        string     `json:"event_id"`
+		Event          string     `json:"event"`
+		Status         string     `json:"status"`
+		Attempts       int        `json:"attempts"`
+		ResponseStatus int        `json:"response_status,omitempty"`
+		ResponseBody   string     `json:"response_body,omitempty"`
+		Error          string     `json:"error,omitempty"`
+		CreatedAt      time.Time  `json:"created_at"`
+		NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+		DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	}
+	infos := make([]deliveryInfo, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		infos = append(infos, deliveryInfo{
+			ID:             delivery.ID,
+			EventID:        delivery.EventID,
+			Event:          delivery.Event,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			ResponseStatus: delivery.ResponseStatus,
+			ResponseBody:   delivery.ResponseBody,
+			Error:          delivery.Error,
+			CreatedAt:      delivery.CreatedAt,
+			NextAttemptAt:  delivery.NextAttemptAt,
+			DeliveredAt:    delivery.DeliveredAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": infos})
+}
+
+// RedeliverWebhook 重新投递一次事件，例如接收方修复故障之后
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为webhook ID，:deliveryId 为投递记录ID
+func RedeliverWebhook(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	webhook, ok := findOwnedWebhook(c, user)
+	if !ok {
+		return
+	}
+	var delivery models.WebhookDelivery
+	if err := config.Conf.DB.Where("id = ? AND webhook_id = ?", c.Param("deliveryId"), webhook.ID).
+		First(&delivery).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "投递记录不存在"})
+		return
+	}
+	if err := services.RedeliverWebhook(&delivery); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新投递失败"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued", "delivery_id": delivery.ID})
+}
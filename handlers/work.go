@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"myapp/config"
@@ -10,6 +14,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,21 +23,94 @@ import (
 	"gorm.io/gorm"
 )
 
-// InitModel 初始化视频模型并进行处理
+// InitModel 初始化视频模型并进行训练，训练完成后才返回
+// 训练由后台任务执行，接口等待任务结束；客户端断开连接时训练继续在后台进行。
+// 不需要等待训练完成的客户端应使用 TrainModel。
 // 参数:
 //
 //	c *gin.Context: Gin框架的上下文对象，用于处理HTTP请求和响应
 func InitModel(c *gin.Context) {
+	work, job, ok := startModelTraining(c)
+	if !ok {
+		return
+	}
+
+	job, err := services.WaitForJob(c.Request.Context(), job.ID, time.Duration(config.Conf.JobPollInterval)*time.Second)
+	if c.Request.Context().Err() != nil {
+		c.JSON(499, gin.H{
+			"error": "canceled",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   err.Error(),
+			"work_id": work.ID,
+		})
+		return
+	}
+	if job.Status != services.JobCompleted {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "fail to train the model",
+			"work_id": work.ID,
+		})
+		return
+	}
+
+	// 返回成功响应
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Model initialization and processing completed successfully",
+		"work_id": work.ID,
+	})
+}
+
+// TrainModel 初始化视频模型并创建训练任务，请求体与 InitModel 相同
+// 训练在后台任务中执行，接口立即返回作品ID与任务ID（202），可通过作品状态或任务状态查询进度
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，用于处理HTTP请求和响应
+func TrainModel(c *gin.Context) {
+	work, job, ok := startModelTraining(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Model initialization queued",
+		"work_id": work.ID,
+		"job_id":  job.ID,
+	})
+}
+
+// startModelTraining 解析请求，为视频创建作品并创建训练任务，失败时已写入响应
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+//
+// 返回值:
+//
+//	*models.Work: 创建的作品
+//	*models.Job: 训练任务
+//	bool: 是否成功
+func startModelTraining(c *gin.Context) (*models.Work, *models.Job, bool) {
 	//获取初始化模型信息
 	var videoInfo struct {
-		VideoID    uint   `json:"id"`
-		WorkName   string `json:"workName"`
-		Iterations string `json:"iterations"`
+		VideoID  uint   `json:"id"`
+		WorkName string `json:"workName"`
+		trainParamsRequest
 	}
 	if err := c.ShouldBindJSON(&videoInfo); err != nil {
 		// 如果解析JSON失败，返回错误响应
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
-		return
+		return nil, nil, false
+	}
+
+	// 校验训练参数
+	params, ok := resolveTrainParams(c, videoInfo.trainParamsRequest, nil)
+	if !ok {
+		return nil, nil, false
+	}
+	if videoInfo.Preset == "" {
+		videoInfo.Preset = services.DefaultTrainingPreset
 	}
 
 	// 找到video信息
@@ -41,17 +120,25 @@ func InitModel(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Video Not Found",
 		})
-		return
+		return nil, nil, false
+	}
+	if video.ExpiredAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "视频文件已按保留规则删除，请重新上传"})
+		return nil, nil, false
+	}
+	if !checkQuota(c, services.CheckTrainingQuota(video.UserID)) {
+		return nil, nil, false
 	}
 
 	// 创建work记录
 	var work models.Work
 	err := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
 		work = models.Work{
-			UserID:     video.UserID,
-			WorkName:   videoInfo.WorkName,
-			Status:     "processing",
-			Iterations: videoInfo.Iterations,
+			UserID:        video.UserID,
+			WorkName:      videoInfo.WorkName,
+			Status:        "queued",
+			Iterations:    strconv.Itoa(params.Iterations),
+			SourceVideoID: video.ID,
 		}
 		return tx.Create(&work).Error
 	})
@@ -61,165 +148,146 @@ func InitModel(c *gin.Context) {
 			"init error": "Failed to initialize video model",
 			"videoid":    videoInfo.VideoID,
 		})
-		return
-	}
-
-	videoPath, err := database.RetrieveFromBucket(fmt.Sprintf("%s%d%s", "video", videoInfo.VideoID, ".mp4"))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to find video:%v", err),
-		})
-		return
+		return nil, nil, false
 	}
-	defer os.RemoveAll(filepath.Dir(videoPath))
 
-	// 执行training
-	processor, err := services.NewVideoProcessor(videoInfo.Iterations)
+	// 创建训练任务
+	job, err := services.StartTraining(&work, services.TrainPayload{
+		Mode:    services.TrainModeTrain,
+		VideoID: video.ID,
+		Preset:  videoInfo.Preset,
+		Params:  params,
+	})
 	if err != nil {
-		// 如果处理失败，更新work状态并返回错误响应
-		if updateErr := updateWorkStatus(work.ID, "process failed", err.Error(), time.Now()); updateErr != nil {
+		if updateErr := services.UpdateWorkStatus(work.ID, "process failed", err.Error(), time.Now()); updateErr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status error": updateErr.Error(),
 			})
-			return
+			return nil, nil, false
+		}
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			checkQuota(c, err)
+			return nil, nil, false
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"init error": "fail to train the model",
 		})
-		return
+		return nil, nil, false
 	}
-	defer func() {
-		if err := os.RemoveAll(filepath.Dir(processor.OutputFolder)); err != nil {
-			log.Printf("fail to remove temp file:%v", err)
-		}
-	}()
 
-	startTime := time.Now()
-	if err := processor.ProcessVideo(videoPath, processor); err != nil {
-		// 如果视频处理失败，更新work状态并返回错误响应
-		if updateErr := updateWorkStatus(work.ID, "process failed", err.Error(), startTime); updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status error": updateErr.Error(),
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "fail to train the model",
-		})
+	return &work, job, true
+}
+
+// RetrainWork 使用作品的原视频重新训练，或从当前版本的检查点继续训练，结果保存为作品的新版本
+// 请求体:
+//
+//	mode - "retrain"（默认，从头训练）或 "continue"（从检查点继续）
+//	preset - 训练参数预设，为空时沿用作品上次的训练参数
+//	params - 覆盖预设的训练参数；继续训练时 iterations 须大于当前版本的迭代次数
+//
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func RetrainWork(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
 		return
 	}
-
-	select {
-	case <-c.Request.Context().Done():
-		if updateErr := updateWorkStatus(work.ID, "splat failed", "canceled", startTime); updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status error": updateErr.Error(),
-			})
-			return
-		}
-		c.JSON(499, gin.H{
-			"error": "canceled",
-		})
+	work, ok := findOwnedWork(c, user)
+	if !ok {
 		return
-	default:
 	}
 
-	// 执行splat
-	if err := processor.Splat(); err != nil {
-		// 如果splat操作失败，更新work状态并返回错误响应
-		if updateErr := updateWorkStatus(work.ID, "splat failed", err.Error(), startTime); updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status error": updateErr.Error(),
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "fail to splat",
-		})
+	var retrainInfo struct {
+		Mode string `json:"mode"`
+		trainParamsRequest
+	}
+	if err := c.ShouldBindJSON(&retrainInfo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	splatPath := processor.OutputFolder + "/point_cloud/iteration_" + processor.Iterations + "/point_cloud.splat"
-	file, err := os.Open(splatPath)
-	if err != nil {
-		if updateErr := updateWorkStatus(work.ID, "upload failed", err.Error(), startTime); updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status error": updateErr.Error(),
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "fail to open splat file",
-		})
+	if retrainInfo.Mode == "" {
+		retrainInfo.Mode = services.TrainModeRetrain
+	}
+	if retrainInfo.Mode != services.TrainModeRetrain && retrainInfo.Mode != services.TrainModeContinue {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的训练模式"})
 		return
 	}
-	defer file.Close()
-	if err := database.StoreInBucket(fmt.Sprintf("%d", work.ID), "work", file); err != nil {
-		if updateErr := updateWorkStatus(work.ID, "upload failed", err.Error(), startTime); updateErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status error": updateErr.Error(),
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "fail to upload work",
-		})
+	if work.Status == "queued" || work.Status == "processing" || work.Status == "retrying" {
+		c.JSON(http.StatusConflict, gin.H{"error": "作品正在训练中"})
 		return
 	}
-
-	// 更新状态为完成
-	if updateErr := updateWorkStatus(work.ID, "completed", "", startTime); updateErr != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status error": updateErr.Error(),
-		})
+	if work.SourceVideoID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该作品没有原视频，无法重新训练"})
+		return
+	}
+	var sourceVideo models.Video
+	if err := config.Conf.DB.First(&sourceVideo, work.SourceVideoID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该作品的原视频已删除，无法重新训练"})
+		return
+	}
+	if sourceVideo.ExpiredAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "视频文件已按保留规则删除，请重新上传"})
+		return
+	}
+	if !checkQuota(c, services.CheckTrainingQuota(user.ID)) {
 		return
 	}
 
-	// 返回成功响应
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Model initialization and processing completed successfully",
-	})
-}
-
-// updateWorkStatus 更新工作的状态。
-// 参数:
-//
-//	workID - 工作的唯一标识符。
-//	status - 工作的新状态。
-//	outputFolder - 工作输出文件的文件夹路径。
-//	errorLog - 工作执行过程中遇到的错误日志。
-//	startTime - 工作开始的时间。
-//
-// 返回值:
-//
-//	如果更新过程中发生错误，则返回错误。
-func updateWorkStatus(workID uint, status, errorLog string, startTime time.Time) error {
-	// 使用事务来更新工作状态，确保数据的一致性。
-	err := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
-		// 初始化要更新的字段。
-		updates := map[string]interface{}{"status": status}
-
-		// 当工作完成或失败时，更新处理时间和文件路径。
-		if status == "completed" || status == "splat failed" {
-			updates["process_time"] = int(time.Since(startTime).Seconds())
+	// 未指定预设时沿用作品上次的训练参数
+	var base *services.TrainParams
+	if retrainInfo.Preset == "" && work.TrainParams != "" {
+		var lastParams services.TrainParams
+		if err := json.Unmarshal([]byte(work.TrainParams), &lastParams); err == nil {
+			base = &lastParams
 		}
+	}
+	params, ok := resolveTrainParams(c, retrainInfo.trainParamsRequest, base)
+	if !ok {
+		return
+	}
+	if retrainInfo.Preset == "" {
+		retrainInfo.Preset = work.TrainingPreset
+	}
+	if retrainInfo.Preset == "" {
+		retrainInfo.Preset = services.DefaultTrainingPreset
+	}
 
-		// 如果有错误日志，则更新错误日志字段。
-		if errorLog != "" {
-			updates["error_log"] = errorLog
+	payload := services.TrainPayload{
+		Mode:    retrainInfo.Mode,
+		VideoID: work.SourceVideoID,
+		Preset:  retrainInfo.Preset,
+		Params:  params,
+	}
+	if payload.Mode == services.TrainModeContinue {
+		rev, err := services.CurrentRevision(work)
+		if err != nil || rev.CheckpointKey == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "当前版本没有可用的检查点"})
+			return
 		}
+		current, _ := strconv.Atoi(rev.Iterations)
+		if payload.Params.Iterations <= current {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("继续训练的迭代次数须大于 %d", current)})
+			return
+		}
+		payload.CheckpointRevisionID = rev.ID
+	}
 
-		// 执行更新操作。
-		return tx.Model(&models.Work{}).Where("id = ?", workID).Updates(updates).Error
-	})
-
-	// 如果更新过程中发生错误，返回详细的错误信息。
+	job, err := services.StartTraining(work, payload)
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		checkQuota(c, err)
+		return
+	}
 	if err != nil {
-		return fmt.Errorf("status update error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	// 更新成功，返回nil表示没有发生错误。
-	return nil
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Retraining queued",
+		"work_id": work.ID,
+		"job_id":  job.ID,
+	})
 }
 
 func UploadWork(c *gin.Context) {
@@ -241,6 +309,13 @@ func UploadWork(c *gin.Context) {
 	}
 
 	ext := filepath.Ext(file.Filename)
+	if ext != ".splat" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "仅支持.splat文件"})
+		return
+	}
+	if !checkQuota(c, services.CheckStorageQuota(user.ID, file.Size)) {
+		return
+	}
 	fileUUID := uuid.New().String()
 	filePath := filepath.Join("temp", fileUUID, fileUUID+ext)
 
@@ -248,82 +323,271 @@ func UploadWork(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "文件保存失败"})
 		return
 	}
-	defer os.RemoveAll(filePath)
-
-	tx := config.Conf.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
+	defer os.RemoveAll(filepath.Dir(filePath))
 
 	var work = models.Work{
 		UserID:   user.ID,
 		Status:   "completed",
 		WorkName: title,
 	}
-	if err := tx.Create(&work).Error; err != nil {
-		tx.Rollback()
+	err = services.RunStorageTx(func(tx *gorm.DB, stx *services.StorageTx) error {
+		if err := tx.Create(&work).Error; err != nil {
+			return err
+		}
+		revision := models.WorkRevision{
+			WorkID:    work.ID,
+			Operation: "upload",
+		}
+		return services.SaveRevision(tx, stx, &revision, filePath, "")
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("fail to upload work:%v", err),
 		})
 		return
 	}
 
-	fileReader, err := os.Open(filePath)
+	services.StartWorkPreview(work.UserID, work.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Work uploaded successfully",
+		"work_id": work.ID,
+	})
+}
+
+// ImportWorks 上传包含.splat/.ply文件的zip或tar压缩包，由后台任务为每个文件创建一个作品
+// 压缩包中可以包含 manifest.json，为文件指定作品名、标签与相机预设；各项的导入结果见任务结果
+// 参数:
+//
+//	c *gin.Context - Gin框架的上下文，表单字段 archive 为压缩包
+func ImportWorks(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+
+	file, err := c.FormFile("archive")
 	if err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to open file:%v", err),
-		})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
 		return
 	}
-	defer fileReader.Close()
-	if err := database.StoreInBucket(fmt.Sprintf("%d", work.ID), "work", fileReader); err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to upload work:%v", err),
-		})
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if !services.ImportArchiveFormats[ext] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "仅支持.zip或.tar压缩包"})
 		return
 	}
-
-	if err := tx.Commit().Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("fail to commit :%v", err),
-		})
+	if !checkQuota(c, services.CheckStorageQuota(user.ID, file.Size)) {
+		return
 	}
+	fileUUID := uuid.New().String()
+	filePath := filepath.Join("temp", fileUUID, fileUUID+ext)
+	if err := c.SaveUploadedFile(file, filePath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "文件保存失败"})
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(filePath))
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Work uploaded successfully",
-		"work_id": work.ID,
+	job, err := services.EnqueueWorkImport(user.ID, filePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to import works:%v", err)})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Import job queued",
+		"job_id":  job.ID,
 	})
 }
 
 // GetWorkPath 获取作品的文件路径
-// 该函数首先尝试根据ID从数据库中获取作品信息，然后根据作品的文件路径寻找对应的.splat文件
+// 该函数首先尝试根据ID从数据库中获取当前用户的作品信息，然后根据作品的文件路径寻找对应的.splat文件
 // 如果.splat文件不存在，则尝试寻找.ply文件并将其转换为.splat文件
+// 查询参数 format=glb 时，将作品转换为遵循 KHR_gaussian_splatting 草案的 .glb 文件下载
 // 参数:
 //
 //	c *gin.Context - Gin框架的上下文，用于处理HTTP请求和响应
 func GetWork(c *gin.Context) {
-	workID := c.Query("id")
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的作品ID"})
+		return
+	}
+	var work models.Work
+	if err := config.Conf.DB.Where("id = ? AND user_id = ?", id, user.ID).First(&work).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "作品不存在"})
+		return
+	}
+	rev, err := services.CurrentRevision(&work)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	serveSplat(c, rev.SplatKey, fmt.Sprintf("work%d", work.ID))
+}
 
-	splatPath, err := database.RetrieveFromBucket("work" + workID + ".splat")
+// serveSplat 从对象存储取回.splat文件并返回给客户端，查询参数 format=glb 时转换为.glb下载
+// 参数:
+//
+//	c *gin.Context - Gin框架的上下文
+//	splatKey - .splat文件的对象键
+//	name - 下载文件名（不含扩展名）
+func serveSplat(c *gin.Context, splatKey, name string) {
+	format := c.DefaultQuery("format", "splat")
+	if format != "splat" && format != "glb" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的下载格式"})
+		return
+	}
+
+	splatPath, err := database.RetrieveFromBucket(splatKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to retrieve work: %v", err)})
+		return
+	}
 	defer func() {
 		err := os.RemoveAll(filepath.Dir(splatPath))
 		if err != nil {
 			log.Println("Failed to remove temporary directory:", err)
 		}
 	}()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to retrieve work: %v", err)})
+
+	if format == "glb" {
+		glbPath := filepath.Join(filepath.Dir(splatPath), name+".glb")
+		if err := services.ConvertSplatToGLB(c.Request.Context(), splatPath, glbPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to convert work: %v", err)})
+			return
+		}
+		c.Header("Content-Type", "model/gltf-binary")
+		c.FileAttachment(glbPath, name+".glb")
 		return
 	}
-	defer os.RemoveAll(filepath.Dir(splatPath))
+	// 下载的文件与对象存储中的对象相同，客户端可以据此校验（RFC 3230）
+	if object, err := database.FindObjectChecksum(splatKey); err == nil && object != nil {
+		if sum, err := hex.DecodeString(object.SHA256); err == nil {
+			c.Header("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+		}
+	}
 	c.File(splatPath)
 }
 
+// GetWorkStatus 返回作品的处理状态；作品有排队中的任务时，同时返回队列位置与预计时间
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func GetWorkStatus(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	response := gin.H{
+		"work_id":        work.ID,
+		"status":         work.Status,
+		"process_time":   work.ProcessTime,
+		"error_log":      work.ErrorLog,
+		"preview_status": work.PreviewStatus,
+		"resource_usage": gin.H{
+			"peak_rss_bytes": work.PeakRSSBytes,
+			"cpu_seconds":    work.CPUTimeSeconds,
+		},
+	}
+
+	// 作品最近一个未结束的任务
+	var job models.Job
+	if err := config.Conf.DB.Where("work_id = ? AND status IN ?", work.ID, []string{services.JobQueued, services.JobRunning}).
+		Order("id DESC").Limit(1).Find(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "任务查询失败"})
+		return
+	}
+	if job.ID != 0 {
+		response["job"] = gin.H{
+			"job_id":        job.ID,
+			"type":          job.Type,
+			"status":        job.Status,
+			"started_at":    job.StartedAt,
+			"attempts":      job.Attempts,
+			"max_attempts":  job.MaxAttempts,
+			"failure_class": job.FailureClass,
+			"retry_at":      job.NextRunAt,
+		}
+		if job.Status == services.JobQueued && (job.NextRunAt == nil || job.NextRunAt.Before(time.Now())) {
+			estimates, err := services.EstimateQueue()
+			if err != nil {
+				log.Printf("fail to estimate queue: %v", err)
+			} else if estimate, ok := estimates[job.ID]; ok {
+				response["queue_position"] = estimate.Position
+				response["eta_seconds"] = int(estimate.StartIn.Seconds())
+				response["eta_finish_seconds"] = int(estimate.FinishIn.Seconds())
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListWorkAttempts 返回作品相关任务的每次尝试，失败的尝试包含错误分类与日志摘录
+// 用户可据此判断应重新上传视频（invalid_input）还是等待自动重试（transient）
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为作品ID
+func ListWorkAttempts(c *gin.Context) {
+	user, ok := checkUser(c)
+	if !ok {
+		return
+	}
+	work, ok := findOwnedWork(c, user)
+	if !ok {
+		return
+	}
+
+	var attempts []models.WorkAttempt
+	if err := config.Conf.DB.Where("work_id = ?", work.ID).Order("id").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "尝试记录查询失败"})
+		return
+	}
+
+	type attemptInfo struct {
+		JobID        uint       `json:"job_id"`
+		JobType      string     `json:"job_type"`
+		Attempt      int        `json:"attempt"`
+		Succeeded    bool       `json:"succeeded"`
+		Stage        string     `json:"stage,omitempty"`
+		FailureClass string     `json:"failure_class,omitempty"`
+		Error        string     `json:"error,omitempty"`
+		LogExcerpt   string     `json:"log_excerpt,omitempty"`
+		StartedAt    *time.Time `json:"started_at"`
+		FinishedAt   time.Time  `json:"finished_at"`
+		NextRetryAt  *time.Time `json:"next_retry_at,omitempty"`
+	}
+	attemptInfos := make([]attemptInfo, 0, len(attempts))
+	for _, attempt := range attempts {
+		attemptInfos = append(attemptInfos, attemptInfo{
+			JobID:        attempt.JobID,
+			JobType:      attempt.JobType,
+			Attempt:      attempt.Attempt,
+			Succeeded:    attempt.FailureClass == "",
+			Stage:        attempt.Stage,
+			FailureClass: attempt.FailureClass,
+			Error:        attempt.Error,
+			LogExcerpt:   attempt.LogExcerpt,
+			StartedAt:    attempt.StartedAt,
+			FinishedAt:   attempt.FinishedAt,
+			NextRetryAt:  attempt.NextRetryAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "尝试记录查询成功",
+		"attempts": attemptInfos,
+	})
+}
+
 func ShowWork(c *gin.Context) {
 	user, ok := checkUser(c)
 	if !ok {
@@ -332,19 +596,30 @@ func ShowWork(c *gin.Context) {
 	}
 
 	var workInfos []struct {
-		WorkID   uint   `json:"work_id"`
-		WorkName string `json:"workName"`
-		Status   string `json:"status"`
+		WorkID   uint     `json:"work_id"`
+		WorkName string   `json:"workName"`
+		Status   string   `json:"status"`
+		Tags     string   `json:"-"`
+		TagList  []string `json:"tags" gorm:"-"`
 	}
 
 	if err := config.Conf.DB.Model(&models.Work{}).
 		Where("user_id=?", user.ID).
-		Select("id as work_id, work_name, status").
+		Select("id as work_id, work_name, status, tags").
 		Scan(&workInfos).Error; err != nil {
 		// 如果数据库查询失败，返回错误响应
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "作品查询失败"})
 		return
 	}
+	for i := range workInfos {
+		workInfos[i].TagList = []string{}
+		if workInfos[i].Tags != "" {
+			if err := json.Unmarshal([]byte(workInfos[i].Tags), &workInfos[i].TagList); err != nil {
+				log.Printf("work %d: invalid tags: %v", workInfos[i].WorkID, err)
+				workInfos[i].TagList = []string{}
+			}
+		}
+	}
 
 	if len(workInfos) == 0 {
 		// 如果没有作品记录，返回空数组
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"myapp/services"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RegisterWorker 注册远程训练节点，返回节点令牌（只返回一次）
+// 请求体包含 name、与服务器 WORKER_SECRET 一致的 secret，以及节点可执行的 job_types
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func RegisterWorker(c *gin.Context) {
+	var workerInfo struct {
+		Name     string   `json:"name"`
+		Secret   string   `json:"secret"`
+		JobTypes []string `json:"job_types"`
+	}
+	if err := c.ShouldBindJSON(&workerInfo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if workerInfo.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "节点名称不能为空"})
+		return
+	}
+
+	worker, token, err := services.RegisterWorker(workerInfo.Name, workerInfo.Secret, workerInfo.JobTypes)
+	if errors.Is(err, services.ErrInvalidWorkerSecret) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "注册密钥错误"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Worker registered successfully",
+		"worker_id":     worker.ID,
+		"token":         token,
+		"lease_seconds": config.Conf.WorkerLeaseSeconds,
+	})
+}
+
+// currentWorker 返回认证中间件设置的节点记录
+func currentWorker(c *gin.Context) *models.Worker {
+	return c.MustGet("worker").(*models.Worker)
+}
+
+// workerJobID 解析路径参数 :id 中的任务ID
+func workerJobID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// workerError 将节点接口的错误转换为响应，失去租约时返回409，节点应放弃该任务
+func workerError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrLeaseLost) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// LeaseWorkerJob 为节点租用一个任务，没有可执行的任务时返回204
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象
+func LeaseWorkerJob(c *gin.Context) {
+	leased, err := services.LeaseJob(currentWorker(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if leased == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":           leased.Job.ID,
+		"type":             leased.Job.Type,
+		"payload":          json.RawMessage(leased.Job.Payload),
+		"inputs":           leased.Inputs,
+		"artifacts":        leased.Artifacts,
+		"lease_expires_at": leased.LeaseExpiresAt,
+		"attempt":          leased.Job.Attempts,
+		"max_attempts":     leased.Job.MaxAttempts,
+		"log_offset":       leased.Job.LogSize,
+	})
+}
+
+// WorkerHeartbeat 延长节点对任务的租约
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为任务ID
+func WorkerHeartbeat(c *gin.Context) {
+	jobID, ok := workerJobID(c)
+	if !ok {
+		return
+	}
+	expires, err := services.HeartbeatJob(currentWorker(c), jobID)
+	if err != nil {
+		workerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"lease_expires_at": expires})
+}
+
+// DownloadJobInput 下载节点所租任务的输入文件
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为任务ID，:name 为输入文件名
+func DownloadJobInput(c *gin.Context) {
+	jobID, ok := workerJobID(c)
+	if !ok {
+		return
+	}
+	key, err := services.JobInputKey(currentWorker(c), jobID, c.Param("name"))
+	if err != nil {
+		workerError(c, err)
+		return
+	}
+
+	inputPath, err := database.RetrieveFromBucket(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("fail to retrieve input: %v", err)})
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(inputPath))
+	c.FileAttachment(inputPath, c.Param("name"))
+}
+
+// UploadJobArtifact 上传节点所租任务的产物文件，请求体为文件内容，超过 WORKER_ARTIFACT_MAX_MB（0表示不限制）时返回413
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为任务ID，:name 为产物文件名
+func UploadJobArtifact(c *gin.Context) {
+	jobID, ok := workerJobID(c)
+	if !ok {
+		return
+	}
+	name := filepath.Base(c.Param("name"))
+	var body io.Reader = c.Request.Body
+	if limit := int64(config.Conf.WorkerArtifactMaxMB) << 20; limit > 0 {
+		if c.Request.ContentLength > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "产物文件过大"})
+			return
+		}
+		body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+
+	fileUUID := uuid.New().String()
+	filePath := filepath.Join("temp", fileUUID, name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "文件保存失败"})
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(filePath))
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "文件保存失败"})
+		return
+	}
+	_, err = io.Copy(file, body)
+	file.Close()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "产物文件过大"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
+		return
+	}
+
+	if err := services.StoreJobArtifact(currentWorker(c), jobID, name, filePath); err != nil {
+		workerError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Artifact uploaded successfully"})
+}
+
+// UploadJobLogSegment 上传节点所租任务的一个日志分段，请求体为日志内容
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为任务ID，:offset 为分段在日志中的起始位置
+func UploadJobLogSegment(c *gin.Context) {
+	jobID, ok := workerJobID(c)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(c.Param("offset"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的日志位置"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, 2*services.JobLogSegmentBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "日志上传失败"})
+		return
+	}
+	if err := services.StoreWorkerJobLogSegment(currentWorker(c), jobID, offset, data); err != nil {
+		workerError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Log segment uploaded successfully"})
+}
+
+// UpdateJobLogTail 更新节点所租任务的日志末尾，请求体包含 tail 与日志总长度 size
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为任务ID
+func UpdateJobLogTail(c *gin.Context) {
+	jobID, ok := workerJobID(c)
+	if !ok {
+		return
+	}
+	var tailInfo struct {
+		Tail []byte `json:"tail"`
+		Size int64  `json:"size"`
+	}
+	if err := c.ShouldBindJSON(&tailInfo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.UpdateWorkerJobLogTail(currentWorker(c), jobID, tailInfo.Tail, tailInfo.Size); err != nil {
+		workerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"size": tailInfo.Size})
+}
+
+// ReportJobResult 报告节点所租任务的执行结果，成功时服务器另建任务对已上传的产物进行后处理，节点无需等待
+// 请求体包含 success 与资源使用 peak_rss_bytes、cpu_seconds，失败时还包含 error、failure_class、stage 与日志摘录 log
+// 参数:
+//
+//	c *gin.Context: Gin框架的上下文对象，路径参数 :id 为任务ID
+func ReportJobResult(c *gin.Context) {
+	jobID, ok := workerJobID(c)
+	if !ok {
+		return
+	}
+	var resultInfo struct {
+		Success      bool    `json:"success"`
+		Error        string  `json:"error"`
+		FailureClass string  `json:"failure_class"`
+		Stage        string  `json:"stage"`
+		Log          string  `json:"log"`
+		PeakRSSBytes int64   `json:"peak_rss_bytes"`
+		CPUSeconds   float64 `json:"cpu_seconds"`
+	}
+	if err := c.ShouldBindJSON(&resultInfo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.CompleteRemoteJob(currentWorker(c), jobID, services.RemoteJobResult{
+		Success:      resultInfo.Success,
+		Error:        resultInfo.Error,
+		FailureClass: resultInfo.FailureClass,
+		Stage:        resultInfo.Stage,
+		Log:          resultInfo.Log,
+		Usage: services.ResourceUsage{
+			PeakRSSBytes: resultInfo.PeakRSSBytes,
+			CPUTime:      time.Duration(resultInfo.CPUSeconds * float64(time.Second)),
+		},
+	})
+	if err != nil {
+		workerError(c, err)
+		return
+	}
+
+	var job models.Job
+	if err := config.Conf.DB.First(&job, jobID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "任务查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"job_id": job.ID,
+		"status": job.Status,
+	})
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"myapp/config"
 	"myapp/router"
+	"myapp/services"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -33,6 +36,32 @@ func main() {
 		}
 	}()
 
+	// 子命令：核对对象存储与数据库后退出
+	if len(os.Args) > 1 && os.Args[1] == "storage-gc" {
+		if err := runStorageGC(os.Args[2:]); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 写入内置训练参数预设
+	if err := services.SeedTrainingPresets(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// 启动后台任务执行器
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	services.StartJobRunner(jobCtx, config.Conf.JobWorkers, time.Duration(config.Conf.JobPollInterval)*time.Second)
+	services.StartWebhookDispatcher(jobCtx, time.Duration(config.Conf.JobPollInterval)*time.Second)
+	services.StartEmailNotifier(jobCtx, time.Duration(config.Conf.JobPollInterval)*time.Second)
+	services.StartOutboxReconciler(jobCtx, time.Minute)
+	services.StartStorageGC(jobCtx, time.Duration(config.Conf.StorageGCIntervalHours)*time.Hour, config.Conf.StorageGCDelete)
+	services.StartStorageScrub(jobCtx, time.Duration(config.Conf.ScrubIntervalHours)*time.Hour)
+	services.StartLifecycle(jobCtx, time.Duration(config.Conf.LifecycleIntervalHours)*time.Hour)
+	services.StartKeyRotation(jobCtx, time.Duration(config.Conf.KeyRotationIntervalHours)*time.Hour)
+
 	// 初始化路由
 	router := router.RouterConfig()
 	serverAddress := ":" + config.Conf.ServerPort
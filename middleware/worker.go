@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"myapp/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkerAuthMiddleware 返回远程训练节点的认证中间件。
+// 节点在请求头中以 "Authorization: Bearer <令牌>" 携带注册时获得的令牌，
+// 认证通过后将节点记录以 "worker" 为键设置到请求上下文中。
+func WorkerAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			return
+		}
+
+		worker, err := services.AuthenticateWorker(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid worker token"})
+			return
+		}
+
+		c.Set("worker", worker)
+		c.Next()
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+import "gorm.io/gorm"
+
+// CameraPreset 是作品的命名相机视角，ViewMatrix 为查看器使用的4x4视图矩阵（JSON数组）
+type CameraPreset struct {
+	gorm.Model
+	WorkID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"not null"`
+	ViewMatrix string `gorm:"type:text;not null"`
+	Work       Work
+}
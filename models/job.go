@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Job 是后台任务记录，由任务执行器按类型分派处理
+type Job struct {
+	gorm.Model
+	Type       string `gorm:"not null;index"`
+	Status     string `gorm:"not null;index"`           // queued/running/completed/failed
+	Priority   int    `gorm:"not null;default:0;index"` // 数值越大越先执行
+	UserID     uint   `gorm:"index"`
+	WorkID     uint   `gorm:"index"`
+	Payload    string `gorm:"type:text"`
+	Result     string `gorm:"type:text"`
+	ErrorLog   string `gorm:"type:text"`
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	// 已开始的尝试次数、最多尝试次数，以及失败重试前的等待截止时间
+	Attempts     int `gorm:"not null;default:0"`
+	MaxAttempts  int `gorm:"not null;default:1"`
+	NextRunAt    *time.Time
+	FailureClass string
+	// 由远程训练节点租用的任务记录节点ID，本地执行时为0并记录执行的进程；
+	// 执行方在租约到期前持续延长租约，到期的任务视为执行方已失联，被放回队列
+	WorkerID       uint   `gorm:"index"`
+	Runner         string `gorm:"size:64"`
+	LeaseExpiresAt *time.Time
+	// 已写入的日志总字节数与日志末尾；完整日志分段保存在对象存储中，见 JobLogSegment
+	LogSize int64
+	LogTail []byte `gorm:"type:blob"`
+	// 任务输出到对象存储的文件（作品导出文件、账号导出压缩包）的大小，计入用户的存储用量
+	OutputBytes int64 `gorm:"not null;default:0"`
+	// 日志分段、暂存产物与导出文件按保留规则删除的时间，之后只保留任务记录
+	ArtifactsExpiredAt *time.Time
+}
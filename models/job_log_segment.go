@@ -0,0 +1,12 @@
+package models
+
+import "gorm.io/gorm"
+
+// JobLogSegment 是任务日志在对象存储中的一个分段，StartOffset 为分段在整个日志中的起始位置
+type JobLogSegment struct {
+	gorm.Model
+	JobID       uint   `gorm:"not null;uniqueIndex:idx_job_log_offset"`
+	StartOffset int64  `gorm:"not null;uniqueIndex:idx_job_log_offset"`
+	Size        int64  `gorm:"not null"`
+	ObjectKey   string `gorm:"size:255;not null"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NotificationPreference 是用户的通知偏好，没有记录时使用默认值（全部发送、默认语言）
+type NotificationPreference struct {
+	gorm.Model
+	UserID uint `gorm:"not null;uniqueIndex"`
+	// 邮件语言（zh/en），为空时使用 EMAIL_LANGUAGE
+	Language string `gorm:"size:8"`
+	// 退订作品完成与失败的邮件
+	EmailOptOutCompleted bool `gorm:"not null;default:false"`
+	EmailOptOutFailed    bool `gorm:"not null;default:false"`
+}
+
+// EmailMessage 是待发送或已发送的通知邮件，内容在创建时已按模板生成
+type EmailMessage struct {
+	gorm.Model
+	UserID        uint       `gorm:"not null;index"`
+	Event         string     `gorm:"not null"`
+	To            string     `gorm:"not null"`
+	Subject       string     `gorm:"not null"`
+	Body          string     `gorm:"type:text"`
+	Status        string     `gorm:"not null;index"` // pending/sent/failed
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt *time.Time `gorm:"index"`
+	Error         string     `gorm:"type:text"`
+	SentAt        *time.Time
+}
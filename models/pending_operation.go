@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PendingOperation 是数据库与对象存储之间尚未完成的一次操作（发件箱记录）。
+// 上传前记录、事务提交后删除；进程中途退出时由后台协调器根据数据库中的记录完成或补偿。
+type PendingOperation struct {
+	gorm.Model
+	Kind      string `gorm:"size:16;not null"` // upload/delete
+	ObjectKey string `gorm:"size:255;not null;index"`
+	// 引用该对象的记录类型与ID，用于判断上传所在的事务是否已提交
+	Entity        string `gorm:"size:32;not null"`
+	EntityID      uint
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt *time.Time `gorm:"index"`
+	Error         string     `gorm:"type:text"`
+}
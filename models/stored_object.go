@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StoredObject 记录对象存储中每个对象上传时的大小与SHA-256，取回与定期巡检时据此校验完整性。
+// 加密的对象记录的是明文的大小与SHA-256
+type StoredObject struct {
+	gorm.Model
+	ObjectKey string `gorm:"size:255;not null;uniqueIndex"`
+	Size      int64  `gorm:"not null"`
+	SHA256    string `gorm:"size:64;not null"`
+	// 加密对象使用的主密钥ID及被主密钥加密的数据密钥（base64），为空表示对象未加密
+	KeyID      string `gorm:"size:64;index"`
+	WrappedKey string `gorm:"size:128"`
+	// 正在上传的新内容的大小、SHA-256与数据密钥，上传前写入，上传完成后转为以上字段；
+	// 上传后未能转换时，读取方按对象的元数据判断存储中的内容属于哪一份记录
+	PendingSize       int64
+	PendingSHA256     string `gorm:"size:64"`
+	PendingKeyID      string `gorm:"size:64"`
+	PendingWrappedKey string `gorm:"size:128"`
+	// 最近一次巡检的时间与发现的问题，为空表示校验通过
+	VerifiedAt  *time.Time `gorm:"index"`
+	VerifyError string     `gorm:"type:text"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+// TrainingPreset 是命名的训练参数预设，Params 为训练参数（JSON）
+type TrainingPreset struct {
+	gorm.Model
+	Name        string `gorm:"size:64;uniqueIndex;not null"`
+	Description string
+	Params      string `gorm:"type:text;not null"`
+}
@@ -1,12 +1,18 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
 	Account  string `gorm:"unique"`
 	Password string `gorm:"not null"`
 	Email    string `gorm:"unique"`
-	Videos   []Video
-	Works    []Work
+	// 注销账号后彻底清除数据的时间，之前可以恢复账号；未注销时为nil
+	PurgeAt *time.Time `json:"-"`
+	Videos  []Video
+	Works   []Work
 }
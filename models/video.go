@@ -1,10 +1,23 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Video struct {
 	gorm.Model
 	Title  string `gorm:"not null"`
-	UserID uint
-	User   User
+	UserID uint   `gorm:"uniqueIndex:idx_videos_user_sha256"`
+	// 视频文件的大小（字节），计入用户的存储用量
+	SizeBytes int64
+	// 视频文件的SHA-256（十六进制），用于识别重复上传，同一用户内唯一；旧的视频及已过期的视频为NULL
+	SHA256 string `gorm:"size:64;default:null;uniqueIndex:idx_videos_user_sha256"`
+	// 视频文件在对象存储中的键；为空表示旧的 video<ID>.mp4
+	ObjectKey string
+	// 固定的视频不受保留规则影响；ExpiredAt 为视频文件按保留规则删除的时间，之后不能再用于训练
+	Pinned    bool
+	ExpiredAt *time.Time
+	User      User
 }
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook 是用户注册的事件回调地址，事件以 Secret 进行HMAC签名
+type Webhook struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	URL    string `gorm:"size:2048;not null"`
+	Secret string `gorm:"size:64;not null"`
+	// 订阅的事件类型（逗号分隔），为空表示订阅全部事件
+	Events string
+	Active bool `gorm:"not null;default:true"`
+}
+
+// WebhookDelivery 是一次事件投递及其最近一次尝试的结果
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID uint   `gorm:"not null;index"`
+	EventID   string `gorm:"size:36;not null"`
+	Event     string `gorm:"not null"`
+	Payload   string `gorm:"type:text"`
+	Status    string `gorm:"not null;index"` // pending/delivered/failed
+	Attempts  int    `gorm:"not null;default:0"`
+	// 下一次尝试的时间，投递中时为本次尝试的超时时间
+	NextAttemptAt  *time.Time `gorm:"index"`
+	ResponseStatus int
+	ResponseBody   string `gorm:"type:text"`
+	Error          string `gorm:"type:text"`
+	DeliveredAt    *time.Time
+}
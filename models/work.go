@@ -9,6 +9,26 @@ type Work struct {
 	ProcessTime string
 	ErrorLog    string
 	Iterations  string
-	UserID      uint
-	User        User
+	// 标签（JSON字符串数组），为空表示没有标签
+	Tags string `gorm:"type:text"`
+	// 训练使用的参数预设及最终生效的训练参数（JSON）
+	TrainingPreset string
+	TrainParams    string `gorm:"type:text"`
+	// 环绕预览视频的生成状态（pending/processing/completed/failed）及格式
+	PreviewStatus string
+	PreviewFormat string
+	// 场景归一化变换（JSON），为空表示保留训练器原始坐标
+	Transform string `gorm:"type:text"`
+	// 是否已保存训练器输出的 cameras.json
+	HasCameras bool
+	// 最近一次训练子进程的资源使用：峰值内存（字节）与CPU时间（秒）
+	PeakRSSBytes   int64
+	CPUTimeSeconds float64
+	// 各次训练累计的处理时间（秒），计入用户的GPU用量
+	ComputeSeconds int64
+	// 当前版本，为0表示版本化之前创建的作品
+	CurrentRevisionID uint
+	SourceVideoID     uint
+	UserID            uint
+	User              User
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WorkAttempt 记录作品相关任务的一次执行尝试，失败时保存错误分类与日志摘录
+type WorkAttempt struct {
+	gorm.Model
+	WorkID       uint   `gorm:"index;not null"`
+	JobID        uint   `gorm:"index;not null"`
+	JobType      string `gorm:"not null"`
+	Attempt      int    `gorm:"not null"` // 任务的第几次尝试，从1开始
+	Stage        string
+	FailureClass string // 为空表示成功
+	Error        string `gorm:"type:text"`
+	LogExcerpt   string `gorm:"type:text"`
+	StartedAt    *time.Time
+	FinishedAt   time.Time
+	NextRetryAt  *time.Time // 将自动重试时的重试时间
+}
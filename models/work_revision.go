@@ -0,0 +1,27 @@
+package models
+
+import "gorm.io/gorm"
+
+// WorkRevision 是作品的一个版本，记录该版本的产物对象键及其来源
+type WorkRevision struct {
+	gorm.Model
+	WorkID uint `gorm:"uniqueIndex:idx_work_revision;not null"`
+	Number int  `gorm:"uniqueIndex:idx_work_revision;not null"` // 作品内从1开始递增的版本号
+
+	// 产物在对象存储中的键，均位于 work<ID>/r<Number>/ 下
+	SplatKey   string `gorm:"not null"`
+	CamerasKey string
+	PreviewKey string
+	Transform  string `gorm:"type:text"`
+	// 训练最终迭代的检查点，用于继续训练
+	CheckpointKey string
+	// 本版本全部产物的大小（字节），计入用户的存储用量
+	SizeBytes int64
+
+	// 版本来源
+	Operation        string `gorm:"not null"` // train/upload 等
+	SourceVideoID    uint
+	Iterations       string
+	ParentRevisionID uint
+	Params           string `gorm:"type:text"` // 产生该版本的操作参数（JSON）
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Worker 是注册到API服务器的远程训练节点，TokenHash 为节点令牌的SHA-256摘要
+type Worker struct {
+	gorm.Model
+	Name       string `gorm:"not null"`
+	TokenHash  string `gorm:"size:64;uniqueIndex;not null"`
+	JobTypes   string // 节点可执行的任务类型，以逗号分隔
+	LastSeenAt *time.Time
+}
@@ -18,6 +18,9 @@ func RouterConfig() *gin.Engine {
 	// 注册和登录路由，不需要身份验证。
 	router.POST("/register", handlers.Register)
 	router.POST("/login", handlers.Login)
+	router.POST("/account/restore", handlers.RestoreUser)
+	// 账号导出的下载链接带有签名，不需要登录
+	router.GET("/account/export/:jobId/download", handlers.DownloadAccountExport)
 
 	// 创建一个带有"/user"前缀的路由组，并应用身份验证中间件。
 	auth := router.Group("/user")
@@ -26,12 +29,55 @@ func RouterConfig() *gin.Engine {
 		// 需要身份验证的路由规则。
 		auth.POST("/video/upload", handlers.UploadVideo)
 		auth.POST("/work/init", handlers.InitModel)
+		auth.POST("/work/train", handlers.TrainModel)
 		auth.GET("/video/", handlers.ShowVideo)
+		auth.PUT("/video/:id/pin", handlers.PinVideo)
 		auth.POST("/work/upload", handlers.UploadWork)
+		auth.POST("/work/import", handlers.ImportWorks)
 		auth.GET("/work/", handlers.ShowWork)
 		auth.GET("/work/get", handlers.GetWork)
+		auth.GET("/work/:id/status", handlers.GetWorkStatus)
+		auth.GET("/work/:id/attempts", handlers.ListWorkAttempts)
+		auth.GET("/work/:id/logs", handlers.GetWorkLogs)
+		auth.GET("/work/:id/preview", handlers.GetWorkPreview)
+		auth.GET("/work/:id/cameras.json", handlers.GetWorkCameras)
+		auth.GET("/work/:id/cameras", handlers.ListCameraPresets)
+		auth.POST("/work/:id/cameras", handlers.AddCameraPreset)
+		auth.DELETE("/work/:id/cameras/:presetId", handlers.DeleteCameraPreset)
+		auth.POST("/work/:id/export", handlers.ExportWork)
+		auth.GET("/work/:id/export/:jobId", handlers.DownloadExport)
+		auth.POST("/work/:id/retrain", handlers.RetrainWork)
+		auth.GET("/work/:id/revisions", handlers.ListRevisions)
+		auth.GET("/work/:id/revisions/:number", handlers.GetRevision)
+		auth.POST("/work/:id/revisions/:number/promote", handlers.PromoteRevision)
+		auth.GET("/job/:id", handlers.GetJob)
+		auth.GET("/training/presets", handlers.ListTrainingPresets)
+		auth.GET("/usage", handlers.GetUsage)
+		auth.GET("/notifications/preferences", handlers.GetNotificationPreferences)
+		auth.PUT("/notifications/preferences", handlers.UpdateNotificationPreferences)
+		auth.POST("/webhooks", handlers.CreateWebhook)
+		auth.GET("/webhooks", handlers.ListWebhooks)
+		auth.DELETE("/webhooks/:id", handlers.DeleteWebhook)
+		auth.GET("/webhooks/:id/deliveries", handlers.ListWebhookDeliveries)
+		auth.POST("/webhooks/:id/deliveries/:deliveryId/redeliver", handlers.RedeliverWebhook)
 		auth.GET("/SplatViewer", handlers.SplatViewer)
-		auth.DELETE("/:id/delete", handlers.DeleteUser)
+		auth.DELETE("/account", handlers.DeleteUser)
+		auth.POST("/account/export", handlers.ExportAccount)
+		auth.GET("/account/export/:jobId", handlers.GetAccountExport)
+	}
+
+	// 远程训练节点使用的接口，注册后以节点令牌认证。
+	router.POST("/worker/register", handlers.RegisterWorker)
+	worker := router.Group("/worker")
+	worker.Use(middleware.WorkerAuthMiddleware())
+	{
+		worker.POST("/jobs/lease", handlers.LeaseWorkerJob)
+		worker.POST("/jobs/:id/heartbeat", handlers.WorkerHeartbeat)
+		worker.GET("/jobs/:id/inputs/:name", handlers.DownloadJobInput)
+		worker.PUT("/jobs/:id/artifacts/:name", handlers.UploadJobArtifact)
+		worker.POST("/jobs/:id/log", handlers.UpdateJobLogTail)
+		worker.PUT("/jobs/:id/log/:offset", handlers.UploadJobLogSegment)
+		worker.POST("/jobs/:id/result", handlers.ReportJobResult)
 	}
 
 	router.Static("/web", config.Conf.SplatPath)
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobTypeAccountPurge 是在注销宽限期结束后彻底删除账号数据的任务类型
+const JobTypeAccountPurge = "account_purge"
+
+// AccountPurgePayload 是账号清除任务的参数
+type AccountPurgePayload struct {
+	UserID uint `json:"user_id"`
+}
+
+// accountPurgeRetryInterval 是账号仍有任务在运行时推迟清除的时间
+const accountPurgeRetryInterval = 10 * time.Minute
+
+// ErrAccountNotRestorable 表示账号未注销或已超过可恢复的期限
+var ErrAccountNotRestorable = errors.New("account cannot be restored")
+
+func init() {
+	RegisterJobHandler(JobTypeAccountPurge, purgeAccount)
+}
+
+func accountDeletionGrace() time.Duration {
+	return time.Duration(max(config.Conf.AccountDeletionGraceDays, 0)) * 24 * time.Hour
+}
+
+// RequestAccountDeletion 注销账号：软删除用户及其视频、作品与webhook，取消排队中的任务，
+// 并创建在宽限期结束后执行的清除任务。宽限期内可以通过 RestoreAccount 恢复。
+// 返回彻底清除数据的时间。
+func RequestAccountDeletion(user *models.User) (time.Time, error) {
+	now := time.Now()
+	purgeAt := now.Add(accountDeletionGrace())
+	err := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		// 以同一时间软删除，恢复时只恢复随账号一起删除的记录
+		for _, model := range []interface{}{&models.Video{}, &models.Work{}, &models.Webhook{}} {
+			if err := tx.Model(model).Where("user_id = ?", user.ID).Update("deleted_at", now).Error; err != nil {
+				return fmt.Errorf("fail to delete user data: %w", err)
+			}
+		}
+		if err := tx.Model(&models.Job{}).Where("user_id = ? AND status = ?", user.ID, JobQueued).
+			Updates(map[string]interface{}{
+				"status":      JobFailed,
+				"error_log":   "account deleted",
+				"finished_at": now,
+			}).Error; err != nil {
+			return fmt.Errorf("fail to cancel jobs: %w", err)
+		}
+		if _, err := enqueueJob(tx, JobTypeAccountPurge, user.ID, 0, AccountPurgePayload{UserID: user.ID}, &purgeAt); err != nil {
+			return err
+		}
+		if err := tx.Model(user).Updates(map[string]interface{}{"deleted_at": now, "purge_at": purgeAt}).Error; err != nil {
+			return fmt.Errorf("fail to delete user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return purgeAt, nil
+}
+
+// FindDeletedAccount 按用户名或邮箱查找已注销、尚未清除的账号。
+func FindDeletedAccount(identifier string) (*models.User, error) {
+	var user models.User
+	err := config.Conf.DB.Unscoped().
+		Where("(account = ? OR email = ?) AND deleted_at IS NOT NULL AND purge_at IS NOT NULL", identifier, identifier).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RestoreAccount 在宽限期内恢复已注销的账号及随之删除的视频、作品与webhook，并取消清除任务。
+// 注销时取消的任务不会恢复。
+func RestoreAccount(user *models.User) error {
+	if !user.DeletedAt.Valid || user.PurgeAt == nil || !user.PurgeAt.After(time.Now()) {
+		return ErrAccountNotRestorable
+	}
+	deletedAt := user.DeletedAt.Time
+	return config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Video{}, &models.Work{}, &models.Webhook{}} {
+			if err := tx.Unscoped().Model(model).Where("user_id = ? AND deleted_at = ?", user.ID, deletedAt).
+				Update("deleted_at", nil).Error; err != nil {
+				return fmt.Errorf("fail to restore user data: %w", err)
+			}
+		}
+		if err := tx.Model(&models.Job{}).Where("user_id = ? AND type = ? AND status = ?", user.ID, JobTypeAccountPurge, JobQueued).
+			Updates(map[string]interface{}{
+				"status":      JobFailed,
+				"error_log":   "account restored",
+				"finished_at": time.Now(),
+			}).Error; err != nil {
+			return fmt.Errorf("fail to cancel purge: %w", err)
+		}
+		if err := tx.Unscoped().Model(user).Updates(map[string]interface{}{"deleted_at": nil, "purge_at": nil}).Error; err != nil {
+			return fmt.Errorf("fail to restore user: %w", err)
+		}
+		return nil
+	})
+}
+
+// purgeAccount 彻底删除已注销账号的全部记录与对象。账号已恢复或尚未到清除时间时不做任何事。
+// 仍有任务在运行时创建 accountPurgeRetryInterval 之后执行的清除任务，本任务直接完成。
+func purgeAccount(ctx context.Context, job *models.Job) (string, error) {
+	var payload AccountPurgePayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return "", NewJobError(FailureInvalidInput, "", err)
+	}
+	var user models.User
+	err := config.Conf.DB.Unscoped().First(&user, payload.UserID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "already purged", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !user.DeletedAt.Valid || user.PurgeAt == nil || user.PurgeAt.After(time.Now()) {
+		return "skipped: account restored", nil
+	}
+
+	var running int64
+	if err := config.Conf.DB.Model(&models.Job{}).
+		Where("user_id = ? AND status = ? AND id <> ?", user.ID, JobRunning, job.ID).
+		Count(&running).Error; err != nil {
+		return "", err
+	}
+	if running > 0 {
+		// 正在运行的任务无法中止，等它们结束后由新的清除任务继续，不占用本任务的重试次数
+		retryAt := time.Now().Add(accountPurgeRetryInterval)
+		if _, err := enqueueJob(config.Conf.DB, JobTypeAccountPurge, user.ID, 0, payload, &retryAt); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rescheduled: %d running jobs", running), nil
+	}
+
+	db := config.Conf.DB.Unscoped()
+	var works []models.Work
+	if err := db.Where("user_id = ?", user.ID).Find(&works).Error; err != nil {
+		return "", err
+	}
+	for i := range works {
+		if err := purgeWork(ctx, &works[i]); err != nil {
+			return "", err
+		}
+	}
+	var videos []models.Video
+	if err := db.Where("user_id = ?", user.ID).Find(&videos).Error; err != nil {
+		return "", err
+	}
+	for i := range videos {
+		if err := purgeVideo(ctx, &videos[i]); err != nil {
+			return "", err
+		}
+	}
+	var jobs []models.Job
+	if err := db.Where("user_id = ? AND id <> ?", user.ID, job.ID).Find(&jobs).Error; err != nil {
+		return "", err
+	}
+	for i := range jobs {
+		if err := purgeJob(ctx, &jobs[i]); err != nil {
+			return "", err
+		}
+	}
+
+	err = config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Unscoped()
+		if err := tx.Where("webhook_id IN (?)", tx.Model(&models.Webhook{}).Select("id").Where("user_id = ?", user.ID)).
+			Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.Webhook{}, &models.NotificationPreference{}, &models.EmailMessage{}} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("fail to purge user %d: %w", user.ID, err)
+	}
+	log.Printf("user %d purged: %d works, %d videos, %d jobs", user.ID, len(works), len(videos), len(jobs))
+	return fmt.Sprintf("purged %d works, %d videos, %d jobs", len(works), len(videos), len(jobs)), nil
+}
+
+// purgeWork 删除作品及其版本、相机预设、尝试记录与全部对象（包括版本化之前的对象键）。
+func purgeWork(ctx context.Context, work *models.Work) error {
+	prefixes := []string{
+		fmt.Sprintf("work%d/", work.ID),
+		fmt.Sprintf("work%d.", work.ID),
+		fmt.Sprintf("cameras%d.", work.ID),
+		fmt.Sprintf("preview%d.", work.ID),
+	}
+	return purgeObjects(ctx, EntityWork, work.ID, prefixes, work, func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.WorkRevision{}, &models.CameraPreset{}, &models.WorkAttempt{}} {
+			if err := tx.Where("work_id = ?", work.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// purgeVideo 删除视频记录及视频文件。
+func purgeVideo(ctx context.Context, video *models.Video) error {
+	prefix := fmt.Sprintf("video%d.", video.ID)
+	if video.ObjectKey != "" {
+		prefix = video.ObjectKey
+	}
+	return purgeObjects(ctx, EntityVideo, video.ID, []string{prefix}, video)
+}
+
+// purgeJob 删除任务及其日志分段、暂存的产物与导出文件。
+func purgeJob(ctx context.Context, job *models.Job) error {
+	prefixes := []string{
+		fmt.Sprintf("job%d/", job.ID),
+		fmt.Sprintf("export%d.", job.ID),
+	}
+	return purgeObjects(ctx, EntityJob, job.ID, prefixes, job, func(tx *gorm.DB) error {
+		return tx.Where("job_id = ?", job.ID).Delete(&models.JobLogSegment{}).Error
+	})
+}
+
+// purgeObjects 在一个事务中彻底删除记录row及related中的关联记录，
+// 并在提交后删除以prefixes开头的全部对象。
+func purgeObjects(ctx context.Context, entity string, entityID uint, prefixes []string, row interface{}, related ...func(tx *gorm.DB) error) error {
+	var keys []string
+	for _, prefix := range prefixes {
+		err := database.ListObjects(ctx, prefix, func(obj database.ObjectInfo) error {
+			keys = append(keys, obj.Key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		tx = tx.Unscoped()
+		for _, fn := range related {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(row).Error; err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := stx.Remove(tx, entity, entityID, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,421 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// JobTypeAccountExport 是将用户的全部数据打包为zip的任务类型
+const JobTypeAccountExport = "account_export"
+
+// AccountExportResult 是账号导出任务的结果，Object 为压缩包在对象存储中的键，到 ExpiresAt 后删除
+type AccountExportResult struct {
+	Object    string    `json:"object"`
+	Size      int64     `json:"size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ErrExportLinkInvalid 表示下载链接的签名不正确或已过期
+var ErrExportLinkInvalid = errors.New("export link invalid or expired")
+
+func init() {
+	RegisterJobHandler(JobTypeAccountExport, exportAccount)
+}
+
+func accountExportExpiry() time.Duration {
+	return time.Duration(max(config.Conf.AccountExportExpiryHours, 1)) * time.Hour
+}
+
+// exportedAccount 是压缩包中 metadata.json 的内容
+type exportedAccount struct {
+	ID         uint            `json:"id"`
+	Account    string          `json:"account"`
+	Email      string          `json:"email"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Videos     []exportedVideo `json:"videos"`
+	Works      []exportedWork  `json:"works"`
+}
+
+type exportedVideo struct {
+	ID        uint       `json:"id"`
+	Title     string     `json:"title"`
+	SizeBytes int64      `json:"size_bytes"`
+	SHA256    string     `json:"sha256,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+	File      string     `json:"file,omitempty"` // 压缩包中的路径，视频文件已过期时为空
+}
+
+type exportedWork struct {
+	ID              uint               `json:"id"`
+	Name            string             `json:"name"`
+	Status          string             `json:"status"`
+	Tags            []string           `json:"tags,omitempty"`
+	CreatedAt       time.Time          `json:"created_at"`
+	SourceVideoID   uint               `json:"source_video_id,omitempty"`
+	TrainingPreset  string             `json:"training_preset,omitempty"`
+	TrainParams     json.RawMessage    `json:"train_params,omitempty"`
+	CurrentRevision int                `json:"current_revision"`
+	Revisions       []exportedRevision `json:"revisions"`
+	CameraPresets   []exportedPreset   `json:"camera_presets"`
+	Logs            []string           `json:"logs"` // 各次训练任务的日志在压缩包中的路径
+}
+
+type exportedRevision struct {
+	Number     int       `json:"number"`
+	Operation  string    `json:"operation,omitempty"`
+	Iterations string    `json:"iterations,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Files      []string  `json:"files"`
+}
+
+type exportedPreset struct {
+	Name       string          `json:"name"`
+	ViewMatrix json.RawMessage `json:"view_matrix"`
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^\p{L}\p{N}._-]+`)
+
+// exportName 将标题转换为可以用作压缩包内文件名的形式
+func exportName(id uint, title string) string {
+	name := unsafeNameChars.ReplaceAllString(title, "_")
+	if len([]rune(name)) > 60 {
+		name = string([]rune(name)[:60])
+	}
+	if name == "" || name == "_" {
+		return strconv.FormatUint(uint64(id), 10)
+	}
+	return fmt.Sprintf("%d_%s", id, name)
+}
+
+// exportAccount 将用户的视频、作品各版本的产物（.splat、.ply、相机参数、预览）、训练日志及 metadata.json
+// 打包为 export<任务ID>.zip 存入对象存储。训练检查点不导出。
+func exportAccount(ctx context.Context, job *models.Job) (string, error) {
+	var user models.User
+	if err := config.Conf.DB.First(&user, job.UserID).Error; err != nil {
+		return "", NewJobError(FailureInvalidInput, "", fmt.Errorf("fail to find user: %w", err))
+	}
+
+	dir, err := os.MkdirTemp("", "account-export-")
+	if err != nil {
+		return "", fmt.Errorf("fail to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	archivePath := filepath.Join(dir, fmt.Sprintf("export%d.zip", job.ID))
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("fail to create archive: %w", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	metadata, err := writeAccountArchive(ctx, archive, &user)
+	if err != nil {
+		return "", err
+	}
+	if err := writeArchiveJSON(archive, "metadata.json", metadata); err != nil {
+		return "", err
+	}
+	if err := archive.Close(); err != nil {
+		return "", fmt.Errorf("fail to write archive: %w", err)
+	}
+
+	size := fileSize(archivePath)
+	if err := storeJobOutput(job, file); err != nil {
+		return "", err
+	}
+	result, _ := json.Marshal(AccountExportResult{
+		Object:    filepath.Base(archivePath),
+		Size:      size,
+		ExpiresAt: time.Now().Add(accountExportExpiry()),
+	})
+	return string(result), nil
+}
+
+// writeAccountArchive 将用户的视频、作品与日志写入压缩包，返回描述压缩包内容的元数据
+func writeAccountArchive(ctx context.Context, archive *zip.Writer, user *models.User) (*exportedAccount, error) {
+	metadata := &exportedAccount{
+		ID:         user.ID,
+		Account:    user.Account,
+		Email:      user.Email,
+		CreatedAt:  user.CreatedAt,
+		ExportedAt: time.Now(),
+		Videos:     []exportedVideo{},
+		Works:      []exportedWork{},
+	}
+
+	var videos []models.Video
+	if err := config.Conf.DB.Where("user_id = ?", user.ID).Order("id").Find(&videos).Error; err != nil {
+		return nil, fmt.Errorf("fail to find videos: %w", err)
+	}
+	for i := range videos {
+		video := &videos[i]
+		entry := exportedVideo{
+			ID:        video.ID,
+			Title:     video.Title,
+			SizeBytes: video.SizeBytes,
+			SHA256:    video.SHA256,
+			CreatedAt: video.CreatedAt,
+			ExpiredAt: video.ExpiredAt,
+		}
+		if video.ExpiredAt == nil {
+			entry.File = "videos/" + exportName(video.ID, video.Title) + ".mp4"
+			if err := copyObjectToArchive(ctx, archive, VideoObjectKey(video), entry.File); err != nil {
+				return nil, err
+			}
+		}
+		metadata.Videos = append(metadata.Videos, entry)
+	}
+
+	var works []models.Work
+	if err := config.Conf.DB.Where("user_id = ?", user.ID).Order("id").Find(&works).Error; err != nil {
+		return nil, fmt.Errorf("fail to find works: %w", err)
+	}
+	for i := range works {
+		entry, err := writeWorkArchive(ctx, archive, &works[i])
+		if err != nil {
+			return nil, err
+		}
+		metadata.Works = append(metadata.Works, *entry)
+	}
+	return metadata, nil
+}
+
+// writeWorkArchive 将作品各版本的产物与训练日志写入压缩包的 works/<名称>/ 下
+func writeWorkArchive(ctx context.Context, archive *zip.Writer, work *models.Work) (*exportedWork, error) {
+	base := "works/" + exportName(work.ID, work.WorkName) + "/"
+	entry := &exportedWork{
+		ID:             work.ID,
+		Name:           work.WorkName,
+		Status:         work.Status,
+		CreatedAt:      work.CreatedAt,
+		SourceVideoID:  work.SourceVideoID,
+		TrainingPreset: work.TrainingPreset,
+		Revisions:      []exportedRevision{},
+		CameraPresets:  []exportedPreset{},
+		Logs:           []string{},
+	}
+	if work.Tags != "" {
+		if err := json.Unmarshal([]byte(work.Tags), &entry.Tags); err != nil {
+			log.Printf("work %d: invalid tags: %v", work.ID, err)
+			entry.Tags = nil
+		}
+	}
+	if json.Valid([]byte(work.TrainParams)) {
+		entry.TrainParams = json.RawMessage(work.TrainParams)
+	}
+
+	var revisions []models.WorkRevision
+	if work.CurrentRevisionID == 0 && work.Status == "completed" {
+		rev, err := CurrentRevision(work)
+		if err != nil {
+			return nil, err
+		}
+		rev.CreatedAt = work.CreatedAt
+		revisions = []models.WorkRevision{*rev}
+	} else if err := config.Conf.DB.Where("work_id = ? AND number > 0", work.ID).Order("number").Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("fail to find revisions: %w", err)
+	}
+	for _, rev := range revisions {
+		if rev.ID == work.CurrentRevisionID {
+			entry.CurrentRevision = rev.Number
+		}
+		exported := exportedRevision{
+			Number:     rev.Number,
+			Operation:  rev.Operation,
+			Iterations: rev.Iterations,
+			CreatedAt:  rev.CreatedAt,
+			Files:      []string{},
+		}
+		keys, err := revisionExportKeys(ctx, work, &rev)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			name := fmt.Sprintf("%sr%d/%s", base, rev.Number, path.Base(key))
+			if err := copyObjectToArchive(ctx, archive, key, name); err != nil {
+				return nil, err
+			}
+			exported.Files = append(exported.Files, name)
+		}
+		entry.Revisions = append(entry.Revisions, exported)
+	}
+
+	var presets []models.CameraPreset
+	if err := config.Conf.DB.Where("work_id = ?", work.ID).Order("id").Find(&presets).Error; err != nil {
+		return nil, fmt.Errorf("fail to find camera presets: %w", err)
+	}
+	for _, preset := range presets {
+		entry.CameraPresets = append(entry.CameraPresets, exportedPreset{Name: preset.Name, ViewMatrix: json.RawMessage(preset.ViewMatrix)})
+	}
+
+	var jobs []models.Job
+	if err := config.Conf.DB.Where("work_id = ? AND type = ? AND log_size > 0", work.ID, JobTypeTrain).
+		Order("id").Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("fail to find training jobs: %w", err)
+	}
+	for i := range jobs {
+		name := fmt.Sprintf("%slogs/job%d.log", base, jobs[i].ID)
+		w, err := archive.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("fail to write archive: %w", err)
+		}
+		if _, err := ReadJobLog(&jobs[i], 0, w); err != nil {
+			return nil, err
+		}
+		entry.Logs = append(entry.Logs, name)
+	}
+	return entry, nil
+}
+
+// revisionExportKeys 返回版本要导出的对象键：版本目录下除训练检查点外的全部对象（.splat、.ply、相机参数与预览）。
+// 版本化之前的作品按旧的对象键导出。
+func revisionExportKeys(ctx context.Context, work *models.Work, rev *models.WorkRevision) ([]string, error) {
+	if rev.Number == 0 {
+		keys := []string{rev.SplatKey}
+		for _, key := range []string{rev.CamerasKey, rev.PreviewKey} {
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+		return keys, nil
+	}
+	var keys []string
+	err := database.ListObjects(ctx, RevisionKey(work.ID, rev.Number, ""), func(obj database.ObjectInfo) error {
+		if path.Ext(obj.Key) != ".pth" {
+			keys = append(keys, obj.Key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// copyObjectToArchive 将对象以name写入压缩包，已压缩的视频与图片不再压缩
+func copyObjectToArchive(ctx context.Context, archive *zip.Writer, key, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	reader, err := database.OpenObject(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+	switch path.Ext(name) {
+	case ".mp4", ".webp", ".webm":
+		header.Method = zip.Store
+	}
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("fail to write archive: %w", err)
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		return fmt.Errorf("fail to copy %s to archive: %w", key, err)
+	}
+	return nil
+}
+
+func writeArchiveJSON(archive *zip.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("fail to encode %s: %w", name, err)
+	}
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("fail to write archive: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("fail to write archive: %w", err)
+	}
+	return nil
+}
+
+// AccountExportSignature 返回账号导出下载链接的签名，链接在expires（Unix时间）之前有效
+func AccountExportSignature(jobID uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(config.Conf.JWTSecret))
+	fmt.Fprintf(mac, "%s:%d:%d", JobTypeAccountExport, jobID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AccountExportURL 返回无需登录即可下载导出压缩包的签名链接，配置了 APP_BASE_URL 时为完整地址
+func AccountExportURL(jobID uint, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	return fmt.Sprintf("%s/account/export/%d/download?expires=%d&signature=%s",
+		config.Conf.AppBaseURL, jobID, expires, AccountExportSignature(jobID, expires))
+}
+
+// VerifyAccountExportLink 校验下载链接的签名与有效期，返回导出任务的结果
+func VerifyAccountExportLink(jobID uint, expires int64, signature string) (*AccountExportResult, error) {
+	if err := verifyAccountExportSignature(jobID, expires, signature, time.Now()); err != nil {
+		return nil, err
+	}
+	var job models.Job
+	if err := config.Conf.DB.Where("id = ? AND type = ? AND status = ?", jobID, JobTypeAccountExport, JobCompleted).
+		First(&job).Error; err != nil {
+		return nil, ErrExportLinkInvalid
+	}
+	return DecodeAccountExportResult(&job)
+}
+
+// verifyAccountExportSignature 校验签名是否由 AccountExportSignature 生成且在now时仍未过期
+func verifyAccountExportSignature(jobID uint, expires int64, signature string, now time.Time) error {
+	expected := AccountExportSignature(jobID, expires)
+	if !hmac.Equal([]byte(signature), []byte(expected)) || now.Unix() > expires {
+		return ErrExportLinkInvalid
+	}
+	return nil
+}
+
+// DecodeAccountExportResult 解析已完成的账号导出任务的结果，压缩包已过期时返回 ErrExportLinkInvalid
+func DecodeAccountExportResult(job *models.Job) (*AccountExportResult, error) {
+	var result AccountExportResult
+	if err := json.Unmarshal([]byte(job.Result), &result); err != nil {
+		return nil, fmt.Errorf("invalid export result: %w", err)
+	}
+	if time.Now().After(result.ExpiresAt) {
+		return nil, ErrExportLinkInvalid
+	}
+	return &result, nil
+}
+
+// expireAccountExports 删除已过期的账号导出压缩包
+func expireAccountExports(ctx context.Context) (int, error) {
+	var jobs []models.Job
+	err := config.Conf.DB.Where("type = ? AND status = ? AND finished_at < ?",
+		JobTypeAccountExport, JobCompleted, time.Now().Add(-accountExportExpiry())).
+		Limit(lifecycleBatchSize).Find(&jobs).Error
+	if err != nil {
+		return 0, fmt.Errorf("fail to find expired exports: %w", err)
+	}
+
+	expired := 0
+	for i := range jobs {
+		if ctx.Err() != nil {
+			return expired, ctx.Err()
+		}
+		if err := purgeJob(ctx, &jobs[i]); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
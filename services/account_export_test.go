@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"myapp/config"
+	"myapp/models"
+	"net/url"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func withJWTSecret(t *testing.T, secret string) {
+	t.Helper()
+	previous := config.Conf.JWTSecret
+	t.Cleanup(func() { config.Conf.JWTSecret = previous })
+	config.Conf.JWTSecret = secret
+}
+
+// parseExportURL 从 AccountExportURL 生成的链接中取出任务ID、有效期与签名
+func parseExportURL(t *testing.T, link string) (uint, int64, string) {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobID, err := strconv.ParseUint(path.Base(path.Dir(u.Path)), 10, 64)
+	if err != nil {
+		t.Fatalf("no job id in %s", link)
+	}
+	expires, err := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	if err != nil {
+		t.Fatalf("no expiry in %s", link)
+	}
+	return uint(jobID), expires, u.Query().Get("signature")
+}
+
+func TestAccountExportLinkSignature(t *testing.T) {
+	withJWTSecret(t, "secret")
+	now := time.Unix(1_700_000_000, 0)
+	jobID, expires, signature := parseExportURL(t, AccountExportURL(7, now.Add(time.Hour)))
+	if jobID != 7 || expires != now.Add(time.Hour).Unix() {
+		t.Fatalf("link has job %d expiring at %d", jobID, expires)
+	}
+
+	if err := verifyAccountExportSignature(jobID, expires, signature, now); err != nil {
+		t.Errorf("link rejected before expiry: %v", err)
+	}
+	if err := verifyAccountExportSignature(jobID, expires, signature, time.Unix(expires, 0)); err != nil {
+		t.Errorf("link rejected at the expiry second: %v", err)
+	}
+
+	// 链接中的任一参数被改动、过期或签名密钥更换后均不可用
+	tampered := []byte(signature)
+	tampered[0] ^= 1
+	rejected := []struct {
+		what      string
+		jobID     uint
+		expires   int64
+		signature string
+		now       time.Time
+	}{
+		{"expired", jobID, expires, signature, time.Unix(expires+1, 0)},
+		{"other job", jobID + 1, expires, signature, now},
+		{"extended expiry", jobID, expires + 3600, signature, now},
+		{"modified signature", jobID, expires, string(tampered), now},
+		{"missing signature", jobID, expires, "", now},
+	}
+	for _, link := range rejected {
+		if err := verifyAccountExportSignature(link.jobID, link.expires, link.signature, link.now); !errors.Is(err, ErrExportLinkInvalid) {
+			t.Errorf("%s: err = %v, want ErrExportLinkInvalid", link.what, err)
+		}
+	}
+
+	withJWTSecret(t, "rotated")
+	if err := verifyAccountExportSignature(jobID, expires, signature, now); !errors.Is(err, ErrExportLinkInvalid) {
+		t.Errorf("link signed with the previous secret: err = %v, want ErrExportLinkInvalid", err)
+	}
+}
+
+func TestDecodeAccountExportResultExpiry(t *testing.T) {
+	job := func(expiresAt time.Time) *models.Job {
+		return &models.Job{Result: fmt.Sprintf(`{"object":"account_export1.zip","size":10,"expires_at":%q}`, expiresAt.Format(time.RFC3339))}
+	}
+
+	result, err := DecodeAccountExportResult(job(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Object != "account_export1.zip" || result.Size != 10 {
+		t.Errorf("result = %+v", result)
+	}
+
+	// 签名未过期但压缩包已被删除
+	if _, err := DecodeAccountExportResult(job(time.Now().Add(-time.Minute))); !errors.Is(err, ErrExportLinkInvalid) {
+		t.Errorf("expired archive: err = %v, want ErrExportLinkInvalid", err)
+	}
+	if _, err := DecodeAccountExportResult(&models.Job{Result: "running"}); err == nil || errors.Is(err, ErrExportLinkInvalid) {
+		t.Errorf("malformed result: err = %v, want a decode error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// CameraPose 对应训练器输出的 cameras.json 中的一个相机。
+// Rotation 为相机到世界的旋转矩阵（列依次为相机的x/y/z轴），Position 为相机中心。
+type CameraPose struct {
+	ID       int           `json:"id"`
+	ImgName  string        `json:"img_name"`
+	Width    int           `json:"width"`
+	Height   int           `json:"height"`
+	Position [3]float64    `json:"position"`
+	Rotation [3][3]float64 `json:"rotation"`
+	Fy       float64       `json:"fy"`
+	Fx       float64       `json:"fx"`
+}
+
+// ReadCameraPoses 读取训练器输出的 cameras.json。
+func ReadCameraPoses(path string) ([]CameraPose, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read cameras file: %w", err)
+	}
+	var cameras []CameraPose
+	if err := json.Unmarshal(data, &cameras); err != nil {
+		return nil, fmt.Errorf("fail to parse cameras file: %w", err)
+	}
+	return cameras, nil
+}
+
+// cameraUp 返回相机在世界坐标系中的上方向（OpenCV约定下为 -y 轴）。
+func (cp *CameraPose) cameraUp() vec3 {
+	return vec3{-cp.Rotation[0][1], -cp.Rotation[1][1], -cp.Rotation[2][1]}
+}
+
+// WriteCameraPoses 将相机姿态写入JSON文件，格式与训练器输出的 cameras.json 一致。
+func WriteCameraPoses(path string, cameras []CameraPose) error {
+	data, err := json.Marshal(cameras)
+	if err != nil {
+		return fmt.Errorf("fail to encode cameras: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("fail to write cameras file: %w", err)
+	}
+	return nil
+}
+
+// PrepareCamerasFile 读取训练器输出的相机姿态，并按场景归一化变换对齐后写入outputPath。
+// transform 为nil时原样复制。
+func PrepareCamerasFile(camerasPath, outputPath string, transform *SceneTransform) error {
+	cameras, err := ReadCameraPoses(camerasPath)
+	if err != nil {
+		return err
+	}
+	if transform != nil {
+		transform.ApplyToCameras(cameras)
+	}
+	return WriteCameraPoses(outputPath, cameras)
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"myapp/config"
+	"myapp/models"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 通知邮件的发送状态
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// emailBatchSize 是每次轮询发送的邮件数
+const emailBatchSize = 20
+
+func init() {
+	SubscribeEvents(enqueueWorkEmail)
+}
+
+// EmailEnabled 判断是否配置了SMTP服务器。
+func EmailEnabled() bool {
+	return config.Conf.SMTPHost != ""
+}
+
+// FindNotificationPreference 返回用户的通知偏好，没有记录时返回默认偏好。
+func FindNotificationPreference(userID uint) (models.NotificationPreference, error) {
+	var pref models.NotificationPreference
+	err := config.Conf.DB.Where("user_id = ?", userID).First(&pref).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.NotificationPreference{UserID: userID}, nil
+	}
+	if err != nil {
+		return pref, fmt.Errorf("fail to find notification preference: %w", err)
+	}
+	return pref, nil
+}
+
+// SaveNotificationPreference 保存用户的通知偏好，language 为空表示使用默认语言。
+func SaveNotificationPreference(pref *models.NotificationPreference) error {
+	if pref.Language != "" && !validEmailLanguage(pref.Language) {
+		return fmt.Errorf("unsupported language %q", pref.Language)
+	}
+	var saved models.NotificationPreference
+	// 以map赋值，使false也能写入
+	err := config.Conf.DB.Where(models.NotificationPreference{UserID: pref.UserID}).
+		Assign(map[string]interface{}{
+			"language":                pref.Language,
+			"email_opt_out_completed": pref.EmailOptOutCompleted,
+			"email_opt_out_failed":    pref.EmailOptOutFailed,
+		}).FirstOrCreate(&saved).Error
+	if err != nil {
+		return fmt.Errorf("fail to save notification preference: %w", err)
+	}
+	*pref = saved
+	return nil
+}
+
+func validEmailLanguage(language string) bool {
+	for _, l := range EmailLanguages {
+		if l == language {
+			return true
+		}
+	}
+	return false
+}
+
+// emailOptedOut 判断用户是否退订了某一事件的邮件。
+func emailOptedOut(pref *models.NotificationPreference, eventType string) bool {
+	switch eventType {
+	case EventWorkCompleted:
+		return pref.EmailOptOutCompleted
+	case EventWorkFailed:
+		return pref.EmailOptOutFailed
+	}
+	return true
+}
+
+// enqueueWorkEmail 在作品完成或失败时，按用户的偏好与语言生成通知邮件，由后台协程发送。
+func enqueueWorkEmail(event Event) {
+	data, ok := event.Data.(WorkEventData)
+	if !ok || !EmailEnabled() || (event.Type != EventWorkCompleted && event.Type != EventWorkFailed) {
+		return
+	}
+	var user models.User
+	if err := config.Conf.DB.First(&user, event.UserID).Error; err != nil || user.Email == "" {
+		return
+	}
+	pref, err := FindNotificationPreference(user.ID)
+	if err != nil {
+		log.Printf("user %d: %v", user.ID, err)
+		return
+	}
+	if emailOptedOut(&pref, event.Type) {
+		return
+	}
+
+	language := pref.Language
+	if language == "" {
+		language = config.Conf.EmailLanguage
+	}
+	templateData := emailTemplateData{
+		Account:     user.Account,
+		WorkID:      data.WorkID,
+		WorkName:    data.WorkName,
+		ProcessTime: data.ProcessTime,
+		ErrorLog:    data.ErrorLog,
+	}
+	if base := config.Conf.AppBaseURL; base != "" {
+		templateData.ViewURL = fmt.Sprintf("%s/web/index.html?id=%d", base, data.WorkID)
+		templateData.LogsURL = fmt.Sprintf("%s/user/work/%d/logs", base, data.WorkID)
+	}
+	subject, body, err := renderEmail(language, event.Type, templateData)
+	if err != nil {
+		log.Printf("work %d: %v", data.WorkID, err)
+		return
+	}
+
+	now := time.Now()
+	message := models.EmailMessage{
+		UserID:        user.ID,
+		Event:         event.Type,
+		To:            user.Email,
+		Subject:       subject,
+		Body:          body,
+		Status:        EmailPending,
+		NextAttemptAt: &now,
+	}
+	if err := config.Conf.DB.Create(&message).Error; err != nil {
+		log.Printf("work %d: fail to enqueue email: %v", data.WorkID, err)
+	}
+}
+
+// StartEmailNotifier 启动后台协程，按pollInterval轮询并发送待发送的通知邮件；未配置SMTP时不启动。
+// 本地调试时可将 SMTP_HOST/SMTP_PORT 指向 MailHog 等SMTP测试服务。
+func StartEmailNotifier(ctx context.Context, pollInterval time.Duration) {
+	if !EmailEnabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dispatchEmails()
+			}
+		}
+	}()
+}
+
+// dispatchEmails 逐封发送到期的邮件，失败时按指数退避重试，超过最多尝试次数后标记为失败。
+func dispatchEmails() {
+	var messages []models.EmailMessage
+	if err := config.Conf.DB.Where("status = ? AND next_attempt_at <= ?", EmailPending, time.Now()).
+		Order("id").Limit(emailBatchSize).Find(&messages).Error; err != nil {
+		log.Printf("fail to find pending emails: %v", err)
+		return
+	}
+	for i := range messages {
+		message := &messages[i]
+		// 乐观领取，多个进程不会重复发送同一封邮件
+		result := config.Conf.DB.Model(&models.EmailMessage{}).
+			Where("id = ? AND status = ? AND attempts = ?", message.ID, EmailPending, message.Attempts).
+			Updates(map[string]interface{}{
+				"attempts":        message.Attempts + 1,
+				"next_attempt_at": time.Now().Add(5 * time.Minute),
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		message.Attempts++
+
+		updates := map[string]interface{}{}
+		if err := sendEmail(message); err == nil {
+			now := time.Now()
+			updates["status"] = EmailSent
+			updates["sent_at"] = &now
+			updates["next_attempt_at"] = nil
+			updates["error"] = ""
+		} else if message.Attempts >= max(config.Conf.EmailMaxAttempts, 1) {
+			updates["status"] = EmailFailed
+			updates["next_attempt_at"] = nil
+			updates["error"] = err.Error()
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(jobRetryDelay(message.Attempts))
+			updates["error"] = err.Error()
+		}
+		if err := config.Conf.DB.Model(message).Updates(updates).Error; err != nil {
+			log.Printf("email %d: %v", message.ID, err)
+		}
+	}
+}
+
+// sendEmail 通过SMTP发送一封纯文本邮件，服务器支持时使用STARTTLS。
+func sendEmail(message *models.EmailMessage) error {
+	from, err := mail.ParseAddress(config.Conf.SMTPFrom)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <email-%d@%s>\r\n", message.ID, config.Conf.SMTPHost)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString([]byte(message.Body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	var auth smtp.Auth
+	if config.Conf.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.Conf.SMTPUsername, config.Conf.SMTPPassword, config.Conf.SMTPHost)
+	}
+	addr := net.JoinHostPort(config.Conf.SMTPHost, strconv.Itoa(config.Conf.SMTPPort))
+	if err := smtp.SendMail(addr, auth, from.Address, []string{to.Address}, buf.Bytes()); err != nil {
+		return fmt.Errorf("fail to send email: %w", err)
+	}
+	return nil
+}
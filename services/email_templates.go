@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// emailTemplate 是一种事件的邮件模板，主题与正文均为 text/template
+type emailTemplate struct {
+	Subject string
+	Body    string
+}
+
+// emailTemplateData 是渲染邮件模板时可用的数据
+type emailTemplateData struct {
+	Account     string
+	WorkID      uint
+	WorkName    string
+	ProcessTime string
+	ErrorLog    string
+	// 查看作品与查看训练日志的链接，未配置 APP_BASE_URL 时为空
+	ViewURL string
+	LogsURL string
+}
+
+// emailTemplates 按语言与事件类型列出邮件模板
+var emailTemplates = map[string]map[string]emailTemplate{
+	"zh": {
+		EventWorkCompleted: {
+			Subject: `作品「{{.WorkName}}」已生成`,
+			Body: `{{.Account}}，您好：
+
+您的作品「{{.WorkName}}」（#{{.WorkID}}）已训练完成{{if .ProcessTime}}，用时 {{.ProcessTime}} 秒{{end}}。
+{{if .ViewURL}}
+在线查看：{{.ViewURL}}
+{{end}}
+如不希望再收到此类邮件，可在通知设置中关闭。
+`,
+		},
+		EventWorkFailed: {
+			Subject: `作品「{{.WorkName}}」生成失败`,
+			Body: `{{.Account}}，您好：
+
+很遗憾，您的作品「{{.WorkName}}」（#{{.WorkID}}）训练失败。
+{{if .ErrorLog}}
+错误信息：{{.ErrorLog}}
+{{end}}{{if .LogsURL}}
+训练日志：{{.LogsURL}}
+{{end}}
+您可以检查视频后重新上传，或重新训练该作品。
+如不希望再收到此类邮件，可在通知设置中关闭。
+`,
+		},
+	},
+	"en": {
+		EventWorkCompleted: {
+			Subject: `Your work "{{.WorkName}}" is ready`,
+			Body: `Hi {{.Account}},
+
+Your work "{{.WorkName}}" (#{{.WorkID}}) has finished training{{if .ProcessTime}} in {{.ProcessTime}} seconds{{end}}.
+{{if .ViewURL}}
+View it online: {{.ViewURL}}
+{{end}}
+You can turn off these emails in your notification settings.
+`,
+		},
+		EventWorkFailed: {
+			Subject: `Your work "{{.WorkName}}" failed`,
+			Body: `Hi {{.Account}},
+
+Unfortunately, training of your work "{{.WorkName}}" (#{{.WorkID}}) failed.
+{{if .ErrorLog}}
+Error: {{.ErrorLog}}
+{{end}}{{if .LogsURL}}
+Training log: {{.LogsURL}}
+{{end}}
+Please check the video and upload it again, or retrain the work.
+You can turn off these emails in your notification settings.
+`,
+		},
+	},
+}
+
+// EmailLanguages 列出支持的邮件语言
+var EmailLanguages = []string{"zh", "en"}
+
+// renderEmail 按语言渲染某一事件的邮件，不支持的语言使用中文模板。
+func renderEmail(language, eventType string, data emailTemplateData) (string, string, error) {
+	templates, ok := emailTemplates[language]
+	if !ok {
+		templates = emailTemplates["zh"]
+	}
+	tmpl, ok := templates[eventType]
+	if !ok {
+		return "", "", fmt.Errorf("no email template for %s", eventType)
+	}
+	subject, err := executeEmailTemplate(tmpl.Subject, data)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := executeEmailTemplate(tmpl.Body, data)
+	if err != nil {
+		return "", "", err
+	}
+	return subject, body, nil
+}
+
+func executeEmailTemplate(text string, data emailTemplateData) (string, error) {
+	tmpl, err := template.New("email").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("fail to parse email template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("fail to render email template: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"time"
+)
+
+// JobTypeKeyRotation 是使对象改用当前主密钥加密的任务类型
+const JobTypeKeyRotation = "storage_key_rotation"
+
+// keyRotationBatchSize 是每次轮换的对象数，其余的留到下次执行
+const keyRotationBatchSize = 500
+
+func init() {
+	RegisterJobHandler(JobTypeKeyRotation, func(ctx context.Context, job *models.Job) (string, error) {
+		return RotateStorageKeys(ctx, keyRotationBatchSize)
+	})
+}
+
+// StartKeyRotation 启动后台协程，按interval创建密钥轮换任务；未配置主密钥或 interval 不大于0时不启动。
+func StartKeyRotation(ctx context.Context, interval time.Duration) {
+	if config.Conf.StorageKeyID == "" {
+		return
+	}
+	startPeriodicJob(ctx, interval, JobTypeKeyRotation, struct{}{})
+}
+
+// RotateStorageKeys 使最多limit个未使用当前主密钥的对象改用当前主密钥：以旧主密钥加密的数据密钥重新加密，
+// 未加密的对象（启用加密之前上传的）加密后重新上传。返回的摘要中 remaining 为0后即可从配置中移除旧主密钥。
+func RotateStorageKeys(ctx context.Context, limit int) (string, error) {
+	activeID := config.Conf.StorageKeyID
+	if activeID == "" {
+		return "encryption disabled", nil
+	}
+	// 主密钥已不在配置中的对象无法轮换，不选取以免阻塞其余对象
+	knownIDs := []string{""}
+	for id := range config.Conf.StorageKeys {
+		knownIDs = append(knownIDs, id)
+	}
+	var objects []models.StoredObject
+	// 尚无上传完成的内容的记录（sha256为空）无需轮换
+	if err := config.Conf.DB.Where("key_id <> ? AND key_id IN ? AND sha256 <> ''", activeID, knownIDs).Order("id").Limit(limit).
+		Find(&objects).Error; err != nil {
+		return "", fmt.Errorf("fail to find objects to rotate: %w", err)
+	}
+
+	var rewrapped, encrypted, failed int
+	for i := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		object := &objects[i]
+		if err := database.RotateObjectKey(object); err != nil {
+			log.Printf("key rotation: %s: %v", object.ObjectKey, err)
+			failed++
+			continue
+		}
+		if object.KeyID == "" {
+			encrypted++
+		} else {
+			rewrapped++
+		}
+	}
+
+	var remaining int64
+	if err := config.Conf.DB.Model(&models.StoredObject{}).Where("key_id <> ? AND sha256 <> ''", activeID).
+		Count(&remaining).Error; err != nil {
+		return "", fmt.Errorf("fail to count objects to rotate: %w", err)
+	}
+	return fmt.Sprintf("rewrapped=%d encrypted=%d failed=%d remaining=%d", rewrapped, encrypted, failed, remaining), ctx.Err()
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"log"
+	"myapp/config"
+	"myapp/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 对外通知的事件类型
+const (
+	EventWorkQueued    = "work.queued"
+	EventWorkStarted   = "work.started"
+	EventWorkCompleted = "work.completed"
+	EventWorkFailed    = "work.failed"
+	EventVideoUploaded = "video.uploaded"
+)
+
+// EventTypes 列出所有可订阅的事件类型
+var EventTypes = []string{EventWorkQueued, EventWorkStarted, EventWorkCompleted, EventWorkFailed, EventVideoUploaded}
+
+// Event 是发送给用户的通知事件，Data 为事件相关的对象
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	UserID    uint        `json:"-"`
+	Data      interface{} `json:"data"`
+}
+
+// EventSubscriber 接收发布的事件，应尽快返回，耗时的发送由各自的后台协程完成
+type EventSubscriber func(event Event)
+
+var (
+	eventSubscribersMu sync.RWMutex
+	eventSubscribers   []EventSubscriber
+)
+
+// SubscribeEvents 注册事件订阅者，例如webhook与邮件通知。
+func SubscribeEvents(subscriber EventSubscriber) {
+	eventSubscribersMu.Lock()
+	defer eventSubscribersMu.Unlock()
+	eventSubscribers = append(eventSubscribers, subscriber)
+}
+
+// PublishEvent 向所有订阅者发布用户的一个事件。
+func PublishEvent(userID uint, eventType string, data interface{}) {
+	event := Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		UserID:    userID,
+		Data:      data,
+	}
+	eventSubscribersMu.RLock()
+	defer eventSubscribersMu.RUnlock()
+	for _, subscriber := range eventSubscribers {
+		subscriber(event)
+	}
+}
+
+// WorkEventData 是作品事件的内容
+type WorkEventData struct {
+	WorkID      uint   `json:"work_id"`
+	WorkName    string `json:"work_name"`
+	Status      string `json:"status"`
+	ProcessTime string `json:"process_time,omitempty"`
+	ErrorLog    string `json:"error_log,omitempty"`
+	RevisionID  uint   `json:"revision_id,omitempty"`
+}
+
+// PublishWorkEvent 读取作品的当前状态并发布作品事件。
+func PublishWorkEvent(workID uint, eventType string) {
+	var work models.Work
+	if err := config.Conf.DB.First(&work, workID).Error; err != nil {
+		log.Printf("work %d: fail to publish %s: %v", workID, eventType, err)
+		return
+	}
+	PublishEvent(work.UserID, eventType, WorkEventData{
+		WorkID:      work.ID,
+		WorkName:    work.WorkName,
+		Status:      work.Status,
+		ProcessTime: work.ProcessTime,
+		ErrorLog:    work.ErrorLog,
+		RevisionID:  work.CurrentRevisionID,
+	})
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path/filepath"
+)
+
+// JobTypeExport 是将作品导出为点云或网格的任务类型
+const JobTypeExport = "export"
+
+// ExportFormats 列出支持的导出格式及其文件扩展名
+var ExportFormats = map[string]string{
+	"ply":  ".ply", // 带颜色的点云
+	"xyz":  ".xyz",
+	"las":  ".las",
+	"mesh": ".ply", // 由密度场提取的粗略网格
+}
+
+// ExportPayload 是导出任务的参数
+type ExportPayload struct {
+	Format string `json:"format"`
+}
+
+// ExportResult 是导出任务的结果，Object 为导出文件在对象存储中的键
+type ExportResult struct {
+	Format string `json:"format"`
+	Object string `json:"object"`
+}
+
+// cancelCheckInterval 是逐点转换时两次检查ctx之间处理的点数
+const cancelCheckInterval = 1 << 16
+
+func init() {
+	RegisterJobHandler(JobTypeExport, exportWork)
+}
+
+// checkCancelled 每处理 cancelCheckInterval 个点返回一次ctx的错误，用于在转换循环中响应取消
+func checkCancelled(ctx context.Context, i int) error {
+	if i%cancelCheckInterval != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// storeJobOutput 将任务的输出文件以 export<任务ID>.<扩展名> 存入对象存储，
+// 并在任务上记录文件大小，计入用户的存储用量
+func storeJobOutput(job *models.Job, file *os.File) error {
+	if err := database.StoreInBucket(fmt.Sprintf("%d", job.ID), "export", file); err != nil {
+		return err
+	}
+	if err := config.Conf.DB.Model(job).Update("output_bytes", fileSize(file.Name())).Error; err != nil {
+		return fmt.Errorf("fail to record export size: %w", err)
+	}
+	return nil
+}
+
+// exportWork 取回作品的.splat文件，按任务参数转换为点云或网格后以 export<任务ID>.<扩展名> 存入对象存储。
+// ctx取消时停止转换。
+func exportWork(ctx context.Context, job *models.Job) (string, error) {
+	var payload ExportPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return "", err
+	}
+	ext, ok := ExportFormats[payload.Format]
+	if !ok {
+		return "", fmt.Errorf("unsupported export format: %s", payload.Format)
+	}
+
+	var work models.Work
+	if err := config.Conf.DB.First(&work, job.WorkID).Error; err != nil {
+		return "", fmt.Errorf("fail to find work: %w", err)
+	}
+	rev, err := CurrentRevision(&work)
+	if err != nil {
+		return "", err
+	}
+
+	splatPath, err := database.RetrieveFromBucket(rev.SplatKey)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(filepath.Dir(splatPath))
+
+	splats, err := ReadSplatFile(splatPath)
+	if err != nil {
+		return "", err
+	}
+
+	outputPath := filepath.Join(filepath.Dir(splatPath), fmt.Sprintf("export%d%s", job.ID, ext))
+	switch payload.Format {
+	case "ply":
+		err = WritePointCloudPLY(ctx, outputPath, splats)
+	case "xyz":
+		err = WritePointCloudXYZ(ctx, outputPath, splats)
+	case "las":
+		err = WritePointCloudLAS(ctx, outputPath, splats)
+	case "mesh":
+		var mesh *Mesh
+		if mesh, err = BuildDensityMesh(ctx, splats, DefaultMeshOptions()); err == nil {
+			err = WriteMeshPLY(ctx, outputPath, mesh)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("fail to open export file: %w", err)
+	}
+	defer file.Close()
+	if err := storeJobOutput(job, file); err != nil {
+		return "", err
+	}
+
+	result, _ := json.Marshal(ExportResult{Format: payload.Format, Object: filepath.Base(outputPath)})
+	return string(result), nil
+}
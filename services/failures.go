@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/models"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// 任务失败的分类
+const (
+	FailureTransient    = "transient"     // 对象存储、网络或数据库的临时错误，可自动重试
+	FailureOOM          = "oom"           // 训练器内存或显存不足
+	FailureInvalidInput = "invalid_input" // 输入视频缺失或无法解析，需重新上传
+	FailureTimeout      = "timeout"       // 任务或阶段超时
+	FailureUnknown      = "unknown"
+)
+
+// validFailureClass 判断节点报告的失败分类是否有效。
+func validFailureClass(class string) bool {
+	switch class {
+	case FailureTransient, FailureOOM, FailureInvalidInput, FailureTimeout, FailureUnknown:
+		return true
+	}
+	return false
+}
+
+// retryableFailures 是会自动重试的失败分类
+var retryableFailures = map[string]bool{
+	FailureTransient: true,
+}
+
+// JobError 是带有失败分类的任务错误。
+type JobError struct {
+	Class string // 失败分类，见 Failure* 常量
+	Stage string // 失败的处理阶段，如 download/train/splat/upload
+	Err   error
+	Log   string // 失败时的日志摘录
+}
+
+func (e *JobError) Error() string {
+	if e.Stage == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// NewJobError 以分类和阶段包装错误，class 为空时按 ClassifyError 自动分类。
+func NewJobError(class, stage string, err error) *JobError {
+	if class == "" {
+		class = ClassifyError(err)
+	}
+	return &JobError{Class: class, Stage: stage, Err: err}
+}
+
+// ClassifyError 判断错误的失败分类。
+func ClassifyError(err error) string {
+	var jobErr *JobError
+	if errors.As(err, &jobErr) {
+		return jobErr.Class
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FailureTimeout
+	}
+	var storageErr minio.ErrorResponse
+	if errors.As(err, &storageErr) {
+		switch storageErr.Code {
+		case "NoSuchKey":
+			return FailureInvalidInput
+		case "AccessDenied", "NoSuchBucket", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+			return FailureUnknown
+		}
+		return FailureTransient
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) {
+		return FailureTransient
+	}
+	return FailureUnknown
+}
+
+// trainerFailurePatterns 是训练器输出中用于判断失败分类的特征文本
+var trainerFailurePatterns = []struct {
+	pattern string
+	class   string
+}{
+	{"out of memory", FailureOOM},
+	{"OutOfMemoryError", FailureOOM},
+	{"MemoryError", FailureOOM},
+	{"signal: killed", FailureOOM}, // 通常由系统的OOM killer终止
+	{"Invalid data found when processing input", FailureInvalidInput},
+	{"moov atom not found", FailureInvalidInput},
+	{"Could not open", FailureInvalidInput},
+	{"No frames", FailureInvalidInput},
+}
+
+// classifyTrainerFailure 根据训练器的错误与输出摘录判断失败分类。
+func classifyTrainerFailure(err error, output string) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FailureTimeout
+	}
+	text := err.Error() + "\n" + output
+	for _, p := range trainerFailurePatterns {
+		if strings.Contains(text, p.pattern) {
+			return p.class
+		}
+	}
+	return ClassifyError(err)
+}
+
+// tailBuffer 只保留最后 limit 字节的写入内容，用于保存子进程输出的摘录。
+type tailBuffer struct {
+	limit int
+	data  []byte
+}
+
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.data)
+}
+
+// logExcerptLimit 是每次尝试保存的日志摘录的最大长度
+const logExcerptLimit = 4096
+
+// jobRetryDelay 返回第attempt次尝试失败后的重试等待时间，按指数增长，最长1小时。
+func jobRetryDelay(attempt int) time.Duration {
+	base := time.Duration(config.Conf.JobRetryBaseSeconds) * time.Second
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+	delay := base
+	for i := 1; i < attempt && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// JobWillRetry 判断任务以err失败后是否会自动重试，处理函数据此设置作品的状态。
+func JobWillRetry(job *models.Job, err error) bool {
+	return retryableFailures[ClassifyError(err)] && job.Attempts < job.MaxAttempts
+}
+
+// failJob 记录任务的一次失败：可重试时按退避时间放回队列，否则标记为失败。
+func failJob(job *models.Job, err error) {
+	class := ClassifyError(err)
+	retry := JobWillRetry(job, err)
+	var nextRunAt *time.Time
+	if retry {
+		t := time.Now().Add(jobRetryDelay(job.Attempts))
+		nextRunAt = &t
+	}
+	recordWorkAttempt(job, err, nextRunAt)
+
+	if !retry {
+		log.Printf("job %d (%s) failed [%s]: %v", job.ID, job.Type, class, err)
+		finishJob(job.ID, JobFailed, "", err.Error())
+		config.Conf.DB.Model(&models.Job{}).Where("id = ?", job.ID).Update("failure_class", class)
+		return
+	}
+
+	log.Printf("job %d (%s) attempt %d failed [%s], retry at %s: %v",
+		job.ID, job.Type, job.Attempts, class, nextRunAt.Format(time.RFC3339), err)
+	if dbErr := config.Conf.DB.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":           JobQueued,
+		"error_log":        err.Error(),
+		"failure_class":    class,
+		"next_run_at":      nextRunAt,
+		"worker_id":        0,
+		"lease_expires_at": nil,
+	}).Error; dbErr != nil {
+		log.Printf("fail to requeue job %d: %v", job.ID, dbErr)
+	}
+}
+
+// recordWorkAttempt 在作品上记录任务的一次尝试，err为nil表示成功；训练任务的尝试同时累计GPU时间。
+func recordWorkAttempt(job *models.Job, err error, nextRetryAt *time.Time) {
+	if job.WorkID == 0 {
+		return
+	}
+	attempt := models.WorkAttempt{
+		WorkID:      job.WorkID,
+		JobID:       job.ID,
+		JobType:     job.Type,
+		Attempt:     job.Attempts,
+		StartedAt:   job.StartedAt,
+		FinishedAt:  time.Now(),
+		NextRetryAt: nextRetryAt,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.FailureClass = ClassifyError(err)
+		var jobErr *JobError
+		if errors.As(err, &jobErr) {
+			attempt.Stage = jobErr.Stage
+			attempt.LogExcerpt = jobErr.Log
+		}
+	}
+	dbErr := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		// 训练的每次尝试（包括失败、超时与重试）都计入作品消耗的GPU时间
+		if job.Type != JobTypeTrain || job.StartedAt == nil {
+			return nil
+		}
+		seconds := int64(attempt.FinishedAt.Sub(*job.StartedAt).Seconds())
+		return tx.Unscoped().Model(&models.Work{}).Where("id = ?", job.WorkID).
+			Update("compute_seconds", gorm.Expr("compute_seconds + ?", seconds)).Error
+	})
+	if dbErr != nil {
+		log.Printf("job %d: fail to record attempt: %v", job.ID, dbErr)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"myapp/config"
+	"myapp/models"
+	"myapp/testutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestClassifyError(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{NewJobError(FailureOOM, "train", errors.New("exit status 1")), FailureOOM},
+		// 已分类的错误被再次包装后保留原分类，不因其中的超时而改变
+		{fmt.Errorf("splat failed: %w", NewJobError(FailureInvalidInput, "download", context.DeadlineExceeded)), FailureInvalidInput},
+		{fmt.Errorf("trainer terminated: %w", context.DeadlineExceeded), FailureTimeout},
+		{fmt.Errorf("object video1.mp4 not found: %w", minio.ErrorResponse{Code: "NoSuchKey"}), FailureInvalidInput},
+		{minio.ErrorResponse{Code: "AccessDenied"}, FailureUnknown},
+		{minio.ErrorResponse{Code: "SignatureDoesNotMatch"}, FailureUnknown},
+		{minio.ErrorResponse{Code: "SlowDown"}, FailureTransient},
+		{minio.ErrorResponse{Code: "InternalError"}, FailureTransient},
+		{fmt.Errorf("fail to upload file:%w", dial), FailureTransient},
+		{fmt.Errorf("fail to update job: %w", driver.ErrBadConn), FailureTransient},
+		{context.Canceled, FailureUnknown},
+		{errors.New("splat file is empty"), FailureUnknown},
+	}
+	for _, c := range cases {
+		if got := ClassifyError(c.err); got != c.want {
+			t.Errorf("ClassifyError(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+}
+
+func TestClassifyTrainerFailure(t *testing.T) {
+	exit := errors.New("exit status 1")
+	if got := classifyTrainerFailure(exit, "torch.cuda.OutOfMemoryError: CUDA out of memory."); got != FailureOOM {
+		t.Errorf("CUDA OOM classified as %s", got)
+	}
+	if got := classifyTrainerFailure(errors.New("signal: killed"), ""); got != FailureOOM {
+		t.Errorf("killed trainer classified as %s", got)
+	}
+	if got := classifyTrainerFailure(exit, "[mov,mp4] moov atom not found\nvideo.mp4: Invalid data"); got != FailureInvalidInput {
+		t.Errorf("broken video classified as %s", got)
+	}
+	// 超时优先于输出中的特征文本：被终止的训练器常在输出中留下内存相关的报错
+	if got := classifyTrainerFailure(fmt.Errorf("terminated: %w", context.DeadlineExceeded), "MemoryError"); got != FailureTimeout {
+		t.Errorf("timed out trainer classified as %s", got)
+	}
+	if got := classifyTrainerFailure(exit, "Traceback: KeyError 'xyz'"); got != FailureUnknown {
+		t.Errorf("unrecognised output classified as %s", got)
+	}
+}
+
+func TestJobRetryDelay(t *testing.T) {
+	previous := config.Conf.JobRetryBaseSeconds
+	t.Cleanup(func() { config.Conf.JobRetryBaseSeconds = previous })
+
+	config.Conf.JobRetryBaseSeconds = 0
+	if got := jobRetryDelay(1); got != 30*time.Second {
+		t.Errorf("default first delay = %s, want 30s", got)
+	}
+
+	config.Conf.JobRetryBaseSeconds = 60
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour}
+	for i, w := range want {
+		if got := jobRetryDelay(i + 1); got != w {
+			t.Errorf("delay after attempt %d = %s, want %s", i+1, got, w)
+		}
+	}
+	// 很大的尝试次数不会溢出
+	if got := jobRetryDelay(500); got != time.Hour {
+		t.Errorf("delay after attempt 500 = %s, want 1h", got)
+	}
+}
+
+// runningJob 创建一个正在进行第attempts次尝试的任务
+func runningJob(t *testing.T, attempts int) *models.Job {
+	t.Helper()
+	started := time.Now().Add(-time.Minute)
+	job := models.Job{Type: JobTypeExport, Status: JobRunning, UserID: 1, Attempts: attempts,
+		MaxAttempts: max(config.Conf.JobMaxAttempts, 1), StartedAt: &started, Runner: runnerID, LeaseExpiresAt: &started}
+	if err := config.Conf.DB.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &job
+}
+
+func reloadJob(t *testing.T, id uint) models.Job {
+	t.Helper()
+	var job models.Job
+	if err := config.Conf.DB.First(&job, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestFailJobRetriesUntilMaxAttempts(t *testing.T) {
+	testutil.Setup(t)
+	config.Conf.JobRetryBaseSeconds = 10
+	config.Conf.JobMaxAttempts = 3
+	transient := NewJobError(FailureTransient, "upload", errors.New("connection reset"))
+
+	// 临时失败且未达到最多尝试次数：放回队列，按本次的尝试次数退避，租约随之释放
+	before := time.Now()
+	first := runningJob(t, 2)
+	failJob(first, transient)
+	job := reloadJob(t, first.ID)
+	if job.Status != JobQueued || job.FailureClass != FailureTransient || job.LeaseExpiresAt != nil || job.NextRunAt == nil {
+		t.Fatalf("after retryable failure: status %s, class %s, lease %v, next run %v",
+			job.Status, job.FailureClass, job.LeaseExpiresAt, job.NextRunAt)
+	}
+	if delay := job.NextRunAt.Sub(before); delay < 20*time.Second || delay > 21*time.Second {
+		t.Errorf("retry scheduled %s after the second attempt, want 20s", delay)
+	}
+
+	// 最后一次尝试失败，即使是临时错误也不再重试
+	last := runningJob(t, 3)
+	failJob(last, transient)
+	job = reloadJob(t, last.ID)
+	if job.Status != JobFailed || job.FinishedAt == nil || job.ErrorLog != transient.Error() || job.NextRunAt != nil {
+		t.Errorf("after last attempt: status %s, finished %v, log %q, next run %v", job.Status, job.FinishedAt, job.ErrorLog, job.NextRunAt)
+	}
+
+	// 不可重试的分类在第一次尝试后就结束
+	invalid := runningJob(t, 1)
+	failJob(invalid, fmt.Errorf("object missing: %w", minio.ErrorResponse{Code: "NoSuchKey"}))
+	if job = reloadJob(t, invalid.ID); job.Status != JobFailed || job.FailureClass != FailureInvalidInput {
+		t.Errorf("missing input: status %s, class %s", job.Status, job.FailureClass)
+	}
+}
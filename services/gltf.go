@@ -0,0 +1,220 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// glTF 常量
+const (
+	gltfFloat         = 5126
+	gltfUnsignedByte  = 5121
+	gltfArrayBuffer   = 34962
+	gltfModePoints    = 0
+	glbMagic          = 0x46546C67 // "glTF"
+	glbChunkJSON      = 0x4E4F534A // "JSON"
+	glbChunkBIN       = 0x004E4942 // "BIN\0"
+	khrGaussianSplats = "KHR_gaussian_splatting"
+)
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Normalized    bool      `json:"normalized,omitempty"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min,omitempty"`
+	Max           []float64 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int         `json:"attributes"`
+	Mode       int                    `json:"mode"`
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+type gltfDocument struct {
+	Asset struct {
+		Version   string `json:"version"`
+		Generator string `json:"generator"`
+	} `json:"asset"`
+	ExtensionsUsed []string                 `json:"extensionsUsed"`
+	Scene          int                      `json:"scene"`
+	Scenes         []map[string]interface{} `json:"scenes"`
+	Nodes          []map[string]interface{} `json:"nodes"`
+	Meshes         []map[string]interface{} `json:"meshes"`
+	Accessors      []gltfAccessor           `json:"accessors"`
+	BufferViews    []gltfBufferView         `json:"bufferViews"`
+	Buffers        []map[string]int         `json:"buffers"`
+}
+
+// glbBuilder 按属性依次写入二进制缓冲区，并生成对应的bufferView与accessor。
+type glbBuilder struct {
+	doc gltfDocument
+	bin bytes.Buffer
+}
+
+func (b *glbBuilder) addAccessor(data []byte, accessor gltfAccessor) int {
+	// 每个bufferView按4字节对齐
+	for b.bin.Len()%4 != 0 {
+		b.bin.WriteByte(0)
+	}
+	b.doc.BufferViews = append(b.doc.BufferViews, gltfBufferView{
+		ByteOffset: b.bin.Len(),
+		ByteLength: len(data),
+		Target:     gltfArrayBuffer,
+	})
+	b.bin.Write(data)
+	accessor.BufferView = len(b.doc.BufferViews) - 1
+	b.doc.Accessors = append(b.doc.Accessors, accessor)
+	return len(b.doc.Accessors) - 1
+}
+
+func float32Bytes(values []float32) []byte {
+	data := make([]byte, len(values)*4)
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+// WriteSplatGLB 将高斯点写为遵循 KHR_gaussian_splatting 草案的 .glb 文件。
+// 高斯点作为一个POINTS图元，属性包括：
+//
+//	POSITION                          VEC3 float 位置
+//	COLOR_0                           VEC4 unsigned byte(normalized) DC颜色与不透明度
+//	KHR_gaussian_splatting:SCALE      VEC3 float 线性尺度
+//	KHR_gaussian_splatting:ROTATION   VEC4 float 单位四元数(x,y,z,w)
+//	KHR_gaussian_splatting:OPACITY    SCALAR float 不透明度
+//
+// 场景数据沿用COLMAP约定（-Y为上），通过节点上绕X轴旋转180度转换到glTF的+Y为上。
+// 不支持该扩展的读取器仍可将其作为带颜色的点云显示。
+func WriteSplatGLB(ctx context.Context, path string, splats []Splat) error {
+	if len(splats) == 0 {
+		return fmt.Errorf("splat data is empty")
+	}
+
+	n := len(splats)
+	positions := make([]float32, 0, n*3)
+	scales := make([]float32, 0, n*3)
+	rotations := make([]float32, 0, n*4)
+	opacities := make([]float32, 0, n)
+	colors := make([]byte, 0, n*4)
+	min := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for i := range splats {
+		if err := checkCancelled(ctx, i); err != nil {
+			return err
+		}
+		s := &splats[i]
+		positions = append(positions, s.Position[:]...)
+		scales = append(scales, s.Scale[:]...)
+		q := s.Quaternion()
+		rotations = append(rotations, float32(q[1]), float32(q[2]), float32(q[3]), float32(q[0]))
+		opacities = append(opacities, float32(s.Color[3])/255)
+		colors = append(colors, s.Color[:]...)
+		for j := 0; j < 3; j++ {
+			min[j] = math.Min(min[j], float64(s.Position[j]))
+			max[j] = math.Max(max[j], float64(s.Position[j]))
+		}
+	}
+
+	var b glbBuilder
+	b.doc.Asset.Version = "2.0"
+	b.doc.Asset.Generator = "Online3D"
+	b.doc.ExtensionsUsed = []string{khrGaussianSplats}
+
+	attributes := map[string]int{
+		"POSITION": b.addAccessor(float32Bytes(positions), gltfAccessor{
+			ComponentType: gltfFloat, Count: n, Type: "VEC3", Min: min, Max: max,
+		}),
+		"COLOR_0": b.addAccessor(colors, gltfAccessor{
+			ComponentType: gltfUnsignedByte, Normalized: true, Count: n, Type: "VEC4",
+		}),
+		khrGaussianSplats + ":SCALE": b.addAccessor(float32Bytes(scales), gltfAccessor{
+			ComponentType: gltfFloat, Count: n, Type: "VEC3",
+		}),
+		khrGaussianSplats + ":ROTATION": b.addAccessor(float32Bytes(rotations), gltfAccessor{
+			ComponentType: gltfFloat, Count: n, Type: "VEC4",
+		}),
+		khrGaussianSplats + ":OPACITY": b.addAccessor(float32Bytes(opacities), gltfAccessor{
+			ComponentType: gltfFloat, Count: n, Type: "SCALAR",
+		}),
+	}
+	for b.bin.Len()%4 != 0 {
+		b.bin.WriteByte(0)
+	}
+
+	b.doc.Meshes = []map[string]interface{}{{
+		"primitives": []gltfPrimitive{{
+			Attributes: attributes,
+			Mode:       gltfModePoints,
+			Extensions: map[string]interface{}{
+				khrGaussianSplats: map[string]string{
+					"kernel":     "ellipse",
+					"colorSpace": "srgb_rec709_display",
+				},
+			},
+		}},
+	}}
+	b.doc.Nodes = []map[string]interface{}{{
+		"mesh":     0,
+		"rotation": []float64{1, 0, 0, 0},
+	}}
+	b.doc.Scenes = []map[string]interface{}{{"nodes": []int{0}}}
+	b.doc.Buffers = []map[string]int{{"byteLength": b.bin.Len()}}
+
+	jsonData, err := json.Marshal(b.doc)
+	if err != nil {
+		return fmt.Errorf("fail to encode gltf: %w", err)
+	}
+	for len(jsonData)%4 != 0 {
+		jsonData = append(jsonData, ' ')
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create glb file: %w", err)
+	}
+	defer file.Close()
+
+	total := 12 + 8 + len(jsonData) + 8 + b.bin.Len()
+	header := []uint32{
+		glbMagic, 2, uint32(total),
+		uint32(len(jsonData)), glbChunkJSON,
+	}
+	if err := binary.Write(file, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("fail to write glb file: %w", err)
+	}
+	if _, err := file.Write(jsonData); err != nil {
+		return fmt.Errorf("fail to write glb file: %w", err)
+	}
+	if err := binary.Write(file, binary.LittleEndian, []uint32{uint32(b.bin.Len()), glbChunkBIN}); err != nil {
+		return fmt.Errorf("fail to write glb file: %w", err)
+	}
+	if _, err := file.Write(b.bin.Bytes()); err != nil {
+		return fmt.Errorf("fail to write glb file: %w", err)
+	}
+	return nil
+}
+
+// ConvertSplatToGLB 将.splat文件转换为.glb文件，ctx取消时停止转换。
+func ConvertSplatToGLB(ctx context.Context, splatPath, glbPath string) error {
+	splats, err := ReadSplatFile(splatPath)
+	if err != nil {
+		return err
+	}
+	return WriteSplatGLB(ctx, glbPath, splats)
+}
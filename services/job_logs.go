@@ -0,0 +1,312 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// JobLogSegmentBytes 是任务日志分段的大小，写满一段即上传到对象存储
+	JobLogSegmentBytes = 1 << 20
+	// jobLogTailLimit 是数据库中保存的日志末尾的最大长度，供查看与跟踪正在运行的任务
+	jobLogTailLimit = 16 * 1024
+	// jobLogFlushInterval 是日志末尾写入数据库的间隔
+	jobLogFlushInterval = 2 * time.Second
+)
+
+// JobLogSink 保存任务日志：写满的分段存入对象存储，日志末尾与总长度写入数据库。
+// 本机执行的任务直接保存，远程节点通过接口上传。
+type JobLogSink interface {
+	StoreSegment(offset int64, data []byte) error
+	UpdateTail(tail []byte, size int64) error
+}
+
+// JobLogWriter 收集任务子进程的输出，按分段保存并定期更新数据库中的日志末尾，可并发写入
+type JobLogWriter struct {
+	mu      sync.Mutex
+	sink    JobLogSink
+	offset  int64 // 当前分段在整个日志中的起始位置
+	segment []byte
+	tail    *tailBuffer
+	dirty   bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewJobLogWriter 创建从offset处继续写入的任务日志，重试的任务接在之前尝试的日志之后。
+func NewJobLogWriter(sink JobLogSink, offset int64) *JobLogWriter {
+	w := &JobLogWriter{
+		sink:    sink,
+		offset:  offset,
+		tail:    newTailBuffer(jobLogTailLimit),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.flushLoop()
+	return w
+}
+
+// OpenJobLog 为本机执行的任务打开日志，接在任务已有的日志之后。
+func OpenJobLog(job *models.Job) *JobLogWriter {
+	return NewJobLogWriter(jobLogStore{jobID: job.ID}, job.LogSize)
+}
+
+// Write 追加日志，当前分段写满时上传。保存失败只记录到服务器日志，不影响子进程的执行。
+func (w *JobLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.segment = append(w.segment, p...)
+	w.tail.Write(p)
+	w.dirty = true
+	if len(w.segment) >= JobLogSegmentBytes {
+		w.storeSegment()
+	}
+	return len(p), nil
+}
+
+// Printf 向日志写入一行说明，例如每次尝试的开始与失败原因。
+func (w *JobLogWriter) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(w, "=== "+format+" ===\n", args...)
+}
+
+// storeSegment 上传当前分段，调用方需持有锁。
+func (w *JobLogWriter) storeSegment() {
+	if len(w.segment) == 0 {
+		return
+	}
+	if err := w.sink.StoreSegment(w.offset, w.segment); err != nil {
+		log.Printf("fail to store job log segment: %v", err)
+	}
+	w.offset += int64(len(w.segment))
+	w.segment = w.segment[:0]
+}
+
+// flushTail 将日志末尾与总长度写入数据库，调用方需持有锁。
+func (w *JobLogWriter) flushTail() {
+	if !w.dirty {
+		return
+	}
+	if err := w.sink.UpdateTail(w.tail.data, w.size()); err != nil {
+		log.Printf("fail to update job log tail: %v", err)
+		return
+	}
+	w.dirty = false
+}
+
+func (w *JobLogWriter) size() int64 {
+	return w.offset + int64(len(w.segment))
+}
+
+func (w *JobLogWriter) flushLoop() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(jobLogFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			w.flushTail()
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Close 上传剩余的日志并更新日志末尾。
+func (w *JobLogWriter) Close() error {
+	close(w.done)
+	<-w.stopped
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.storeSegment()
+	w.flushTail()
+	return nil
+}
+
+// jobLogStore 将本机执行的任务日志直接保存到对象存储与数据库
+type jobLogStore struct {
+	jobID uint
+}
+
+func (s jobLogStore) StoreSegment(offset int64, data []byte) error {
+	return storeJobLogSegment(s.jobID, offset, data)
+}
+
+func (s jobLogStore) UpdateTail(tail []byte, size int64) error {
+	return updateJobLogTail(s.jobID, tail, size)
+}
+
+// jobLogSegmentKey 返回日志分段的对象键，以起始位置命名，重复上传同一分段时覆盖。
+func jobLogSegmentKey(jobID uint, offset int64) string {
+	return fmt.Sprintf("job%d/log/%012d.log", jobID, offset)
+}
+
+// storeJobLogSegment 上传一个日志分段并记录，之后删除超出保留上限的最早分段。
+func storeJobLogSegment(jobID uint, offset int64, data []byte) error {
+	key := jobLogSegmentKey(jobID, offset)
+	dir, err := os.MkdirTemp("", "log-")
+	if err != nil {
+		return fmt.Errorf("fail to store log segment: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	segmentPath := filepath.Join(dir, filepath.Base(key))
+	if err := os.WriteFile(segmentPath, data, 0644); err != nil {
+		return fmt.Errorf("fail to store log segment: %w", err)
+	}
+
+	err = RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		if err := stx.Store(EntityJobLogSegment, jobID, key, segmentPath); err != nil {
+			return err
+		}
+		segment := models.JobLogSegment{JobID: jobID, StartOffset: offset}
+		if err := tx.Where(segment).
+			Assign(models.JobLogSegment{Size: int64(len(data)), ObjectKey: key}).
+			FirstOrCreate(&segment).Error; err != nil {
+			return fmt.Errorf("fail to record log segment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	rotateJobLog(jobID)
+	return nil
+}
+
+// rotateJobLog 删除超出 JOB_LOG_MAX_MB 的最早日志分段。
+func rotateJobLog(jobID uint) {
+	limit := int64(config.Conf.JobLogMaxMB) * 1024 * 1024
+	if limit <= 0 {
+		return
+	}
+	var segments []models.JobLogSegment
+	if err := config.Conf.DB.Where("job_id = ?", jobID).Order("start_offset DESC").Find(&segments).Error; err != nil {
+		log.Printf("job %d: fail to list log segments: %v", jobID, err)
+		return
+	}
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+		// 始终保留最新的分段
+		if total <= limit || segment.ID == segments[0].ID {
+			continue
+		}
+		err := RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+			if err := tx.Unscoped().Delete(&segment).Error; err != nil {
+				return err
+			}
+			return stx.Remove(tx, EntityJobLogSegment, jobID, segment.ObjectKey)
+		})
+		if err != nil {
+			log.Printf("job %d: fail to rotate log: %v", jobID, err)
+		}
+	}
+}
+
+// updateJobLogTail 更新任务的日志末尾与总长度。
+func updateJobLogTail(jobID uint, tail []byte, size int64) error {
+	return config.Conf.DB.Model(&models.Job{}).Where("id = ?", jobID).Updates(map[string]interface{}{
+		"log_tail": tail,
+		"log_size": size,
+	}).Error
+}
+
+// StoreWorkerJobLogSegment 保存节点上传的日志分段，节点已失去租约时返回 ErrLeaseLost。
+func StoreWorkerJobLogSegment(worker *models.Worker, jobID uint, offset int64, data []byte) error {
+	if _, err := leasedJob(worker, jobID); err != nil {
+		return err
+	}
+	if offset < 0 || len(data) > 2*JobLogSegmentBytes {
+		return fmt.Errorf("invalid log segment")
+	}
+	return storeJobLogSegment(jobID, offset, data)
+}
+
+// UpdateWorkerJobLogTail 更新节点所租任务的日志末尾，节点已失去租约时返回 ErrLeaseLost。
+func UpdateWorkerJobLogTail(worker *models.Worker, jobID uint, tail []byte, size int64) error {
+	if _, err := leasedJob(worker, jobID); err != nil {
+		return err
+	}
+	if len(tail) > jobLogTailLimit || int64(len(tail)) > size {
+		return fmt.Errorf("invalid log tail")
+	}
+	return updateJobLogTail(jobID, tail, size)
+}
+
+// FindWorkLogJob 返回作品的训练任务：jobID为0时返回最近一次训练任务。
+func FindWorkLogJob(workID, jobID uint) (*models.Job, error) {
+	query := config.Conf.DB.Where("work_id = ?", workID)
+	if jobID != 0 {
+		query = query.Where("id = ?", jobID)
+	} else {
+		query = query.Where("type = ?", JobTypeTrain).Order("id DESC")
+	}
+	var job models.Job
+	if err := query.First(&job).Error; err != nil {
+		return nil, fmt.Errorf("fail to find job: %w", err)
+	}
+	return &job, nil
+}
+
+// ReadJobLog 将任务日志从offset处开始写入w，返回读到的位置，供下次继续读取。
+// 已被轮转删除或未保存的部分以一行说明代替。
+func ReadJobLog(job *models.Job, offset int64, w io.Writer) (int64, error) {
+	var segments []models.JobLogSegment
+	if err := config.Conf.DB.Where("job_id = ? AND start_offset + size > ?", job.ID, offset).
+		Order("start_offset").Find(&segments).Error; err != nil {
+		return offset, fmt.Errorf("fail to list log segments: %w", err)
+	}
+	for _, segment := range segments {
+		if segment.StartOffset > offset {
+			fmt.Fprintf(w, "\n[... %d bytes not available ...]\n", segment.StartOffset-offset)
+			offset = segment.StartOffset
+		}
+		next, err := copyJobLogSegment(segment, offset, w)
+		if err != nil {
+			return offset, err
+		}
+		offset = next
+	}
+
+	// 尚未写满一个分段的部分从数据库中的日志末尾读取
+	tailStart := job.LogSize - int64(len(job.LogTail))
+	if job.LogSize <= offset {
+		return offset, nil
+	}
+	if tailStart > offset {
+		fmt.Fprintf(w, "\n[... %d bytes not available ...]\n", tailStart-offset)
+		offset = tailStart
+	}
+	if _, err := w.Write(job.LogTail[offset-tailStart:]); err != nil {
+		return offset, err
+	}
+	return job.LogSize, nil
+}
+
+// copyJobLogSegment 将分段中从offset开始的部分写入w，返回分段的结束位置。
+func copyJobLogSegment(segment models.JobLogSegment, offset int64, w io.Writer) (int64, error) {
+	reader, err := database.OpenObject(segment.ObjectKey)
+	if err != nil {
+		return offset, err
+	}
+	defer reader.Close()
+	if _, err := io.CopyN(io.Discard, reader, offset-segment.StartOffset); err != nil {
+		return offset, fmt.Errorf("fail to read log segment: %w", err)
+	}
+	n, err := io.Copy(w, reader)
+	if err != nil {
+		return offset + n, fmt.Errorf("fail to read log segment: %w", err)
+	}
+	return offset + n, nil
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/models"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 任务状态
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// JobHandler 处理一个后台任务，返回的字符串保存为任务结果。
+type JobHandler func(ctx context.Context, job *models.Job) (string, error)
+
+var (
+	jobHandlersMu sync.RWMutex
+	jobHandlers   = map[string]JobHandler{}
+)
+
+// RegisterJobHandler 注册某一类型任务的处理函数。
+func RegisterJobHandler(jobType string, handler JobHandler) {
+	jobHandlersMu.Lock()
+	defer jobHandlersMu.Unlock()
+	jobHandlers[jobType] = handler
+}
+
+func jobHandler(jobType string) (JobHandler, bool) {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+	handler, ok := jobHandlers[jobType]
+	return handler, ok
+}
+
+// localJobTypes 返回由本进程执行的任务类型，即已注册且未配置为远程执行的类型。
+func localJobTypes() []string {
+	var types []string
+	for _, t := range registeredJobTypes() {
+		if !isRemoteJobType(t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// isRemoteJobType 判断某一类型的任务是否只交给远程训练节点执行。
+func isRemoteJobType(jobType string) bool {
+	for _, t := range config.Conf.RemoteJobTypes {
+		if t == jobType {
+			return true
+		}
+	}
+	return false
+}
+
+func registeredJobTypes() []string {
+	jobHandlersMu.RLock()
+	defer jobHandlersMu.RUnlock()
+	types := make([]string, 0, len(jobHandlers))
+	for t := range jobHandlers {
+		types = append(types, t)
+	}
+	return types
+}
+
+// EnqueueJob 创建一个排队中的后台任务，优先级取该类型任务的默认优先级。
+// 参数:
+//
+//	jobType - 任务类型，需已通过RegisterJobHandler注册。
+//	userID - 任务所属用户。
+//	workID - 关联的作品ID，没有时为0。
+//	payload - 任务参数，将以JSON形式保存。
+func EnqueueJob(jobType string, userID, workID uint, payload interface{}) (*models.Job, error) {
+	return enqueueJob(config.Conf.DB, jobType, userID, workID, payload, nil)
+}
+
+// enqueueJob 在db（可以是事务）中创建任务，runAt 不为nil时任务在该时间之后才会执行。
+func enqueueJob(db *gorm.DB, jobType string, userID, workID uint, payload interface{}, runAt *time.Time) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("fail to encode job payload: %w", err)
+	}
+	job := models.Job{
+		Type:     jobType,
+		Status:   JobQueued,
+		Priority: JobPriority(jobType),
+		UserID:   userID,
+		// 未配置时只尝试一次
+		MaxAttempts: max(config.Conf.JobMaxAttempts, 1),
+		WorkID:      workID,
+		Payload:     string(data),
+		NextRunAt:   runAt,
+	}
+	if err := db.Create(&job).Error; err != nil {
+		return nil, fmt.Errorf("fail to enqueue job: %w", err)
+	}
+	return &job, nil
+}
+
+// DecodeJobPayload 将任务参数解析到v中。
+func DecodeJobPayload(job *models.Job, v interface{}) error {
+	if err := json.Unmarshal([]byte(job.Payload), v); err != nil {
+		return fmt.Errorf("invalid job payload: %w", err)
+	}
+	return nil
+}
+
+// WaitForJob 每隔interval查询一次任务，直到任务完成或最终失败（不再重试）后返回任务记录。
+// ctx取消时返回ctx的错误，任务本身不受影响。
+func WaitForJob(ctx context.Context, jobID uint, interval time.Duration) (*models.Job, error) {
+	ticker := time.NewTicker(max(interval, time.Second))
+	defer ticker.Stop()
+	for {
+		var job models.Job
+		if err := config.Conf.DB.First(&job, jobID).Error; err != nil {
+			return nil, fmt.Errorf("fail to find job %d: %w", jobID, err)
+		}
+		if job.Status == JobCompleted || job.Status == JobFailed {
+			return &job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// runnerID 标识本进程，本地执行的任务以此记录执行的进程
+var runnerID = newRunnerID()
+
+func newRunnerID() string {
+	host, _ := os.Hostname()
+	if len(host) > 32 {
+		host = host[:32]
+	}
+	raw := make([]byte, 4)
+	rand.Read(raw)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(raw))
+}
+
+// StartJobRunner 启动workers个后台协程轮询并执行排队中的任务，ctx取消后停止领取新任务。
+// 本地执行的任务与远程节点租用的任务一样持有租约，执行的进程退出后由租约到期检查放回队列，
+// 多个进程同时运行时不会放回其他进程正在执行的任务。
+func StartJobRunner(ctx context.Context, workers int, pollInterval time.Duration) {
+	// 升级前由本地执行的任务没有租约，无法判断执行的进程是否仍在运行，按已中断处理
+	if err := config.Conf.DB.Model(&models.Job{}).
+		Where("status = ? AND worker_id = 0 AND lease_expires_at IS NULL AND runner = ''", JobRunning).
+		Update("status", JobQueued).Error; err != nil {
+		log.Printf("fail to requeue interrupted jobs: %v", err)
+	}
+	requeueExpiredLeases()
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				requeueExpiredLeases()
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+			for {
+				// 有任务时连续执行，队列为空时等待下一次轮询
+				for ctx.Err() == nil && runNextJob(ctx) {
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// runNextJob 领取并执行一个任务，没有可执行任务时返回false。
+func runNextJob(ctx context.Context) bool {
+	job, err := claimNextJob(localJobTypes(), map[string]interface{}{
+		"runner":           runnerID,
+		"lease_expires_at": time.Now().Add(workerLease()),
+	})
+	if err != nil {
+		log.Printf("fail to claim job: %v", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	// 任务整体超时，超时后处理函数中的子进程被终止
+	if timeout := jobTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	lost := holdLocalLease(ctx, cancel, job)
+	handler, _ := jobHandler(job.Type)
+	result, err := runJobHandler(ctx, handler, job)
+	cancel()
+	if lost() {
+		// 任务已被放回队列，由重新领取的执行器完成
+		log.Printf("job %d: %v", job.ID, ErrLeaseLost)
+		return true
+	}
+	if err != nil {
+		failJob(job, err)
+	} else {
+		recordWorkAttempt(job, nil, nil)
+		finishJob(job.ID, JobCompleted, result, "")
+	}
+	return true
+}
+
+// holdLocalLease 在任务执行期间定期延长本进程对任务的租约，直到ctx结束。
+// 租约已被收回（进程长时间无法访问数据库，任务被放回队列）时调用cancel终止任务；
+// 返回的函数等待续约协程退出，并报告租约是否已失去，应在ctx结束后调用。
+func holdLocalLease(ctx context.Context, cancel context.CancelFunc, job *models.Job) (lost func() bool) {
+	done := make(chan bool, 1)
+	go func() {
+		ticker := time.NewTicker(workerLease() / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				done <- false
+				return
+			case <-ticker.C:
+			}
+			err := renewLocalLease(job)
+			if errors.Is(err, ErrLeaseLost) {
+				cancel()
+				done <- true
+				return
+			}
+			if err != nil {
+				log.Printf("job %d: fail to renew lease: %v", job.ID, err)
+			}
+		}
+	}()
+	return func() bool { return <-done }
+}
+
+// renewLocalLease 延长本进程对本地任务的租约，任务已不由本进程执行时返回 ErrLeaseLost。
+func renewLocalLease(job *models.Job) error {
+	result := config.Conf.DB.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND worker_id = 0 AND runner = ? AND attempts = ?", job.ID, JobRunning, runnerID, job.Attempts).
+		Update("lease_expires_at", time.Now().Add(workerLease()))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// runJobHandler 执行任务处理函数，并将panic转换为错误，避免拖垮执行器。
+func runJobHandler(ctx context.Context, handler JobHandler, job *models.Job) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// claimMu 串行化本进程内的任务领取，保证用户并发上限的判断与领取之间不被其他执行器打断
+var claimMu sync.Mutex
+
+// claimNextJob 按调度规则从types类型的任务中选出下一个，并以乐观锁的方式领取。
+// 调度规则见 schedulerState.pick：优先级、用户间轮转与用户并发上限。
+// updates 为领取时一并更新的字段，如远程节点的租约。
+func claimNextJob(types []string, updates map[string]interface{}) (*models.Job, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	claimMu.Lock()
+	defer claimMu.Unlock()
+	for attempt := 0; attempt < 5; attempt++ {
+		state, err := loadSchedulerState(types)
+		if err != nil {
+			return nil, err
+		}
+		i := state.pick()
+		if i < 0 {
+			return nil, nil
+		}
+
+		fields := map[string]interface{}{
+			"status":     JobRunning,
+			"started_at": time.Now(),
+			"attempts":   gorm.Expr("attempts + 1"),
+		}
+		for k, v := range updates {
+			fields[k] = v
+		}
+		result := config.Conf.DB.Model(&models.Job{}).
+			Where("id = ? AND status = ?", state.queued[i].ID, JobQueued).
+			Updates(fields)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			var job models.Job
+			if err := config.Conf.DB.First(&job, state.queued[i].ID).Error; err != nil {
+				return nil, err
+			}
+			return &job, nil
+		}
+		// 被其他进程抢先领取，重试
+	}
+	return nil, nil
+}
+
+func finishJob(jobID uint, status, result, errorLog string) {
+	err := config.Conf.DB.Model(&models.Job{}).Where("id = ?", jobID).Updates(map[string]interface{}{
+		"status":      status,
+		"result":      result,
+		"error_log":   errorLog,
+		"finished_at": time.Now(),
+	}).Error
+	if err != nil {
+		log.Printf("fail to update job %d: %v", jobID, err)
+	}
+}
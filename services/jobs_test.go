@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/config"
+	"myapp/models"
+	"myapp/testutil"
+	"testing"
+	"time"
+)
+
+func TestLocalJobLease(t *testing.T) {
+	testutil.Setup(t)
+	config.Conf.JobMaxAttempts = 3
+
+	// 其他进程执行中、租约未到期的任务不受影响；租约到期的任务放回队列
+	mine := runningJob(t, 1)
+	expired := time.Now().Add(-time.Second)
+	alive := time.Now().Add(time.Minute)
+	config.Conf.DB.Model(mine).Update("lease_expires_at", alive)
+	other := runningJob(t, 1)
+	config.Conf.DB.Model(other).Updates(map[string]interface{}{"runner": "other-host-1-00000000", "lease_expires_at": alive})
+	crashed := runningJob(t, 1)
+	config.Conf.DB.Model(crashed).Updates(map[string]interface{}{"runner": "crashed-host-1-00000000", "lease_expires_at": expired})
+
+	requeueExpiredLeases()
+	for _, job := range []*models.Job{mine, other} {
+		if got := reloadJob(t, job.ID); got.Status != JobRunning {
+			t.Errorf("job %d with a live lease is %s", job.ID, got.Status)
+		}
+	}
+	if got := reloadJob(t, crashed.ID); got.Status != JobQueued || got.FailureClass != FailureTransient {
+		t.Errorf("job of the crashed runner: status %s, class %s", got.Status, got.FailureClass)
+	}
+
+	// 本进程续约自己的任务；任务被放回队列后续约失败，执行中的处理函数随之终止
+	if err := renewLocalLease(mine); err != nil {
+		t.Fatalf("renew own lease: %v", err)
+	}
+	if err := renewLocalLease(other); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("renewed the lease of another runner: %v", err)
+	}
+	config.Conf.DB.Model(mine).Update("lease_expires_at", expired)
+	requeueExpiredLeases()
+	if err := renewLocalLease(mine); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("renewed a requeued job: %v", err)
+	}
+
+	config.Conf.WorkerLeaseSeconds = 1
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lost := holdLocalLease(ctx, cancel, mine)
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.Canceled) || !lost() {
+		t.Errorf("handler context: %v, want it cancelled after the lease was lost", ctx.Err())
+	}
+}
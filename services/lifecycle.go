@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobTypeLifecycle 是按保留规则删除过期数据的任务类型
+const JobTypeLifecycle = "storage_lifecycle"
+
+// lifecycleBatchSize 是每条规则每次处理的记录数，其余的留到下次执行
+const lifecycleBatchSize = 100
+
+func init() {
+	RegisterJobHandler(JobTypeLifecycle, func(ctx context.Context, job *models.Job) (string, error) {
+		return ApplyRetentionRules(ctx)
+	})
+}
+
+// StartLifecycle 启动后台协程，按interval创建执行保留规则的任务，interval 不大于0时不启动。
+func StartLifecycle(ctx context.Context, interval time.Duration) {
+	startPeriodicJob(ctx, interval, JobTypeLifecycle, struct{}{})
+}
+
+func retentionCutoff(days int) time.Time {
+	return time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+// ApplyRetentionRules 依次执行已配置的保留规则：
+//   - RETENTION_VIDEO_DAYS：作品训练成功若干天后删除原视频文件（固定的视频除外），视频记录保留；
+//   - RETENTION_FAILED_JOB_DAYS：删除失败已久的任务的日志分段与暂存产物；
+//   - RETENTION_EXPORT_DAYS：删除完成已久的作品导出任务的导出文件；
+//   - RETENTION_DELETED_DAYS：彻底删除软删除已久的作品、视频与webhook（等待注销的账号的数据由账号清除任务处理）。
+//
+// 另外总是删除超过 ACCOUNT_EXPORT_EXPIRY_HOURS 的账号导出压缩包。
+func ApplyRetentionRules(ctx context.Context) (string, error) {
+	var videos, jobs, exported, deleted int
+	exports, err := expireAccountExports(ctx)
+	if err != nil {
+		return "", err
+	}
+	if days := config.Conf.RetentionVideoDays; days > 0 {
+		if videos, err = expireSourceVideos(ctx, retentionCutoff(days)); err != nil {
+			return "", err
+		}
+	}
+	if days := config.Conf.RetentionFailedJobDays; days > 0 {
+		if jobs, err = expireFailedJobArtifacts(ctx, retentionCutoff(days)); err != nil {
+			return "", err
+		}
+	}
+	if days := config.Conf.RetentionExportDays; days > 0 {
+		if exported, err = expireWorkExports(ctx, retentionCutoff(days)); err != nil {
+			return "", err
+		}
+	}
+	if days := config.Conf.RetentionDeletedDays; days > 0 {
+		if deleted, err = purgeDeletedRows(ctx, retentionCutoff(days)); err != nil {
+			return "", err
+		}
+	}
+	summary := fmt.Sprintf("expired_videos=%d expired_job_logs=%d expired_work_exports=%d purged_rows=%d expired_exports=%d",
+		videos, jobs, exported, deleted, exports)
+	log.Printf("storage lifecycle: %s", summary)
+	return summary, nil
+}
+
+// expireSourceVideos 删除最近一次训练成功早于cutoff、且没有正在训练的作品使用的原视频文件。
+func expireSourceVideos(ctx context.Context, cutoff time.Time) (int, error) {
+	var videos []models.Video
+	err := config.Conf.DB.
+		Where("pinned = ? AND expired_at IS NULL", false).
+		Where("id IN (?)", config.Conf.DB.Model(&models.WorkRevision{}).Select("source_video_id").
+			Where("source_video_id <> 0").Group("source_video_id").Having("MAX(created_at) < ?", cutoff)).
+		Where("id NOT IN (?)", config.Conf.DB.Model(&models.Work{}).Select("source_video_id").
+			Where("status IN ?", []string{"queued", "processing", "retrying"})).
+		Limit(lifecycleBatchSize).Find(&videos).Error
+	if err != nil {
+		return 0, fmt.Errorf("fail to find expired videos: %w", err)
+	}
+
+	expired := 0
+	for i := range videos {
+		if ctx.Err() != nil {
+			return expired, ctx.Err()
+		}
+		if err := expireVideo(&videos[i]); err != nil {
+			log.Printf("video %d: %v", videos[i].ID, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// expireVideo 删除视频文件并标记视频已过期。
+// 过期的视频不再参与重复上传的识别，用户可以重新上传相同的视频。
+func expireVideo(video *models.Video) error {
+	return RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		now := time.Now()
+		if err := tx.Model(video).Updates(map[string]interface{}{"expired_at": &now, "size_bytes": 0, "sha256": nil}).Error; err != nil {
+			return err
+		}
+		return stx.Remove(tx, EntityVideo, video.ID, VideoObjectKey(video))
+	})
+}
+
+// expireFailedJobArtifacts 删除在cutoff之前失败的任务的日志分段与节点暂存的产物（无论是否有日志分段），
+// 任务记录及日志末尾保留。
+func expireFailedJobArtifacts(ctx context.Context, cutoff time.Time) (int, error) {
+	var jobs []models.Job
+	err := config.Conf.DB.Select("id").
+		Where("status = ? AND finished_at < ? AND artifacts_expired_at IS NULL", JobFailed, cutoff).
+		Limit(lifecycleBatchSize).Find(&jobs).Error
+	if err != nil {
+		return 0, fmt.Errorf("fail to find expired jobs: %w", err)
+	}
+	return expireJobObjects(ctx, jobs, "job%d/")
+}
+
+// expireWorkExports 删除在cutoff之前完成的作品导出任务的导出文件，之后下载导出文件时提示已过期。
+func expireWorkExports(ctx context.Context, cutoff time.Time) (int, error) {
+	var jobs []models.Job
+	err := config.Conf.DB.Select("id").
+		Where("type = ? AND status = ? AND finished_at < ? AND artifacts_expired_at IS NULL", JobTypeExport, JobCompleted, cutoff).
+		Limit(lifecycleBatchSize).Find(&jobs).Error
+	if err != nil {
+		return 0, fmt.Errorf("fail to find expired exports: %w", err)
+	}
+	return expireJobObjects(ctx, jobs, "export%d.")
+}
+
+// expireJobObjects 删除各任务的日志分段及以prefix（含任务ID的格式）开头的对象，并记录删除时间，
+// 已处理的任务不会再被选中。返回处理的任务数。
+func expireJobObjects(ctx context.Context, jobs []models.Job, prefix string) (int, error) {
+	expired := 0
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return expired, ctx.Err()
+		}
+		var keys []string
+		err := database.ListObjects(ctx, fmt.Sprintf(prefix, job.ID), func(obj database.ObjectInfo) error {
+			keys = append(keys, obj.Key)
+			return nil
+		})
+		if err != nil {
+			return expired, err
+		}
+		err = RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+			if err := tx.Unscoped().Where("job_id = ?", job.ID).Delete(&models.JobLogSegment{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&job).Update("artifacts_expired_at", time.Now()).Error; err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err := stx.Remove(tx, EntityJob, job.ID, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("job %d: %v", job.ID, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// purgeDeletedRows 彻底删除在cutoff之前软删除的作品、视频与webhook。
+// 属于等待注销的账号的记录在宽限期内可能恢复，由账号清除任务处理。
+func purgeDeletedRows(ctx context.Context, cutoff time.Time) (int, error) {
+	db := config.Conf.DB.Unscoped()
+	pendingUsers := db.Model(&models.User{}).Select("id").Where("purge_at IS NOT NULL")
+	purged := 0
+
+	var works []models.Work
+	if err := db.Where("deleted_at < ? AND user_id NOT IN (?)", cutoff, pendingUsers).
+		Limit(lifecycleBatchSize).Find(&works).Error; err != nil {
+		return purged, fmt.Errorf("fail to find deleted works: %w", err)
+	}
+	for i := range works {
+		if err := purgeWork(ctx, &works[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	var videos []models.Video
+	if err := db.Where("deleted_at < ? AND user_id NOT IN (?)", cutoff, pendingUsers).
+		Limit(lifecycleBatchSize).Find(&videos).Error; err != nil {
+		return purged, fmt.Errorf("fail to find deleted videos: %w", err)
+	}
+	for i := range videos {
+		if err := purgeVideo(ctx, &videos[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	var webhooks []models.Webhook
+	if err := db.Where("deleted_at < ? AND user_id NOT IN (?)", cutoff, pendingUsers).
+		Limit(lifecycleBatchSize).Find(&webhooks).Error; err != nil {
+		return purged, fmt.Errorf("fail to find deleted webhooks: %w", err)
+	}
+	for i := range webhooks {
+		err := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+			tx = tx.Unscoped()
+			if err := tx.Where("webhook_id = ?", webhooks[i].ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&webhooks[i]).Error
+		})
+		if err != nil {
+			return purged, fmt.Errorf("fail to purge webhook %d: %w", webhooks[i].ID, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// MeshOptions 描述由高斯密度场提取网格的参数。
+type MeshOptions struct {
+	Resolution int     // 包围盒最长边上的体素数
+	IsoLevel   float64 // 等值面阈值，相对于非零密度的中位数
+	MaxRadius  int     // 单个高斯影响的最大体素半径
+}
+
+// DefaultMeshOptions 返回默认的网格提取参数。
+func DefaultMeshOptions() MeshOptions {
+	return MeshOptions{
+		Resolution: 128,
+		IsoLevel:   1,
+		MaxRadius:  4,
+	}
+}
+
+// Mesh 是带顶点颜色的三角网格。
+type Mesh struct {
+	Vertices []vec3
+	Colors   [][3]uint8
+	Faces    [][3]int
+}
+
+// densityGrid 是在规则网格点上累积的高斯密度与颜色。
+type densityGrid struct {
+	origin     vec3
+	voxel      float64
+	nx, ny, nz int
+	density    []float64
+	color      [][3]float64
+}
+
+func (g *densityGrid) index(x, y, z int) int { return (z*g.ny+y)*g.nx + x }
+
+func (g *densityGrid) point(x, y, z int) vec3 {
+	return g.origin.add(vec3{float64(x), float64(y), float64(z)}.scale(g.voxel))
+}
+
+// buildDensityGrid 将每个高斯按其平均尺度近似为各向同性核，累积到网格点上。
+func buildDensityGrid(ctx context.Context, splats []Splat, opts MeshOptions) (*densityGrid, error) {
+	bmin, bmax := SplatBounds(splats, 0.01, 0.99)
+	extent := vec3(bmax).sub(vec3(bmin))
+	longest := math.Max(extent[0], math.Max(extent[1], extent[2]))
+	if longest <= 0 {
+		return nil, fmt.Errorf("degenerate scene extent")
+	}
+	voxel := longest / float64(opts.Resolution)
+	pad := voxel * float64(opts.MaxRadius)
+	origin := vec3(bmin).sub(vec3{pad, pad, pad})
+
+	g := &densityGrid{
+		origin: origin,
+		voxel:  voxel,
+		nx:     int(math.Ceil((extent[0]+2*pad)/voxel)) + 1,
+		ny:     int(math.Ceil((extent[1]+2*pad)/voxel)) + 1,
+		nz:     int(math.Ceil((extent[2]+2*pad)/voxel)) + 1,
+	}
+	g.density = make([]float64, g.nx*g.ny*g.nz)
+	g.color = make([][3]float64, len(g.density))
+
+	for i := range splats {
+		if err := checkCancelled(ctx, i); err != nil {
+			return nil, err
+		}
+		s := &splats[i]
+		opacity := float64(s.Color[3]) / 255
+		if opacity < 0.05 {
+			continue
+		}
+		sigma := math.Max((math.Abs(float64(s.Scale[0]))+math.Abs(float64(s.Scale[1]))+math.Abs(float64(s.Scale[2])))/3, voxel/2)
+		radius := int(math.Min(float64(opts.MaxRadius), math.Ceil(2*sigma/voxel)))
+		p := vec3{float64(s.Position[0]), float64(s.Position[1]), float64(s.Position[2])}
+		c := p.sub(origin).scale(1 / voxel)
+		cx, cy, cz := int(math.Round(c[0])), int(math.Round(c[1])), int(math.Round(c[2]))
+
+		for z := cz - radius; z <= cz+radius; z++ {
+			for y := cy - radius; y <= cy+radius; y++ {
+				for x := cx - radius; x <= cx+radius; x++ {
+					if x < 0 || y < 0 || z < 0 || x >= g.nx || y >= g.ny || z >= g.nz {
+						continue
+					}
+					d := g.point(x, y, z).sub(p)
+					w := opacity * math.Exp(-d.dot(d)/(2*sigma*sigma))
+					idx := g.index(x, y, z)
+					g.density[idx] += w
+					g.color[idx][0] += w * float64(s.Color[0])
+					g.color[idx][1] += w * float64(s.Color[1])
+					g.color[idx][2] += w * float64(s.Color[2])
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+// cubeCorners 是立方体8个角点相对于基点的偏移，第i个角点为 (i&1, i>>1&1, i>>2&1)。
+var cubeCorners = [8][3]int{
+	{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {1, 1, 0},
+	{0, 0, 1}, {1, 0, 1}, {0, 1, 1}, {1, 1, 1},
+}
+
+// cubeTetrahedra 是沿主对角线0-7将立方体剖分为6个四面体的方式，相邻立方体的剖分在公共面上一致。
+var cubeTetrahedra = [6][4]int{
+	{0, 1, 3, 7}, {0, 2, 3, 7}, {0, 1, 5, 7},
+	{0, 4, 5, 7}, {0, 2, 6, 7}, {0, 4, 6, 7},
+}
+
+// BuildDensityMesh 在高斯密度场上提取等值面，得到粗略的带颜色网格。
+// 采用行进立方体的四面体剖分变体（marching tetrahedra），无需256种情形的查找表且不会产生歧义面。
+// ctx取消时停止提取并返回其错误。
+func BuildDensityMesh(ctx context.Context, splats []Splat, opts MeshOptions) (*Mesh, error) {
+	g, err := buildDensityGrid(ctx, splats, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	nonZero := make([]float64, 0, len(g.density)/4)
+	for _, d := range g.density {
+		if d > 1e-6 {
+			nonZero = append(nonZero, d)
+		}
+	}
+	if len(nonZero) == 0 {
+		return nil, fmt.Errorf("density grid is empty")
+	}
+	iso := quantile(nonZero, 0.5) * opts.IsoLevel
+
+	mesh := &Mesh{}
+	edgeVertex := map[[2]int]int{}
+	vertexOn := func(a, b int) int {
+		if a > b {
+			a, b = b, a
+		}
+		if v, ok := edgeVertex[[2]int{a, b}]; ok {
+			return v
+		}
+		da, db := g.density[a], g.density[b]
+		t := 0.5
+		if db != da {
+			t = (iso - da) / (db - da)
+		}
+		pa := g.point(a%g.nx, a/g.nx%g.ny, a/(g.nx*g.ny))
+		pb := g.point(b%g.nx, b/g.nx%g.ny, b/(g.nx*g.ny))
+		var col [3]uint8
+		for k := 0; k < 3; k++ {
+			ca, cb := g.color[a][k]/math.Max(da, 1e-9), g.color[b][k]/math.Max(db, 1e-9)
+			col[k] = uint8(math.Max(0, math.Min(255, ca+(cb-ca)*t)))
+		}
+		mesh.Vertices = append(mesh.Vertices, pa.add(pb.sub(pa).scale(t)))
+		mesh.Colors = append(mesh.Colors, col)
+		edgeVertex[[2]int{a, b}] = len(mesh.Vertices) - 1
+		return len(mesh.Vertices) - 1
+	}
+
+	for z := 0; z < g.nz-1; z++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for y := 0; y < g.ny-1; y++ {
+			for x := 0; x < g.nx-1; x++ {
+				var corner [8]int
+				for i, off := range cubeCorners {
+					corner[i] = g.index(x+off[0], y+off[1], z+off[2])
+				}
+				for _, tet := range cubeTetrahedra {
+					var inside, outside []int
+					for _, ci := range tet {
+						if g.density[corner[ci]] > iso {
+							inside = append(inside, corner[ci])
+						} else {
+							outside = append(outside, corner[ci])
+						}
+					}
+					switch len(inside) {
+					case 1:
+						mesh.addTriangle(g, inside, outside,
+							vertexOn(inside[0], outside[0]), vertexOn(inside[0], outside[1]), vertexOn(inside[0], outside[2]))
+					case 3:
+						mesh.addTriangle(g, inside, outside,
+							vertexOn(outside[0], inside[0]), vertexOn(outside[0], inside[1]), vertexOn(outside[0], inside[2]))
+					case 2:
+						a := vertexOn(inside[0], outside[0])
+						b := vertexOn(inside[0], outside[1])
+						c := vertexOn(inside[1], outside[1])
+						d := vertexOn(inside[1], outside[0])
+						mesh.addTriangle(g, inside, outside, a, b, c)
+						mesh.addTriangle(g, inside, outside, a, c, d)
+					}
+				}
+			}
+		}
+	}
+	return mesh, nil
+}
+
+// addTriangle 添加三角形，并使其法向从高密度一侧指向低密度一侧。
+func (m *Mesh) addTriangle(g *densityGrid, inside, outside []int, a, b, c int) {
+	centroid := func(indices []int) vec3 {
+		var sum vec3
+		for _, idx := range indices {
+			sum = sum.add(g.point(idx%g.nx, idx/g.nx%g.ny, idx/(g.nx*g.ny)))
+		}
+		return sum.scale(1 / float64(len(indices)))
+	}
+	dir := centroid(outside).sub(centroid(inside))
+	normal := m.Vertices[b].sub(m.Vertices[a]).cross(m.Vertices[c].sub(m.Vertices[a]))
+	if normal.length() == 0 {
+		return
+	}
+	if normal.dot(dir) < 0 {
+		b, c = c, b
+	}
+	m.Faces = append(m.Faces, [3]int{a, b, c})
+}
+
+// WriteMeshPLY 将网格写为带顶点颜色的二进制PLY。
+func WriteMeshPLY(ctx context.Context, path string, mesh *Mesh) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create mesh: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 4*1024*1024)
+	fmt.Fprintf(w, "ply\nformat binary_little_endian 1.0\nelement vertex %d\n", len(mesh.Vertices))
+	fmt.Fprint(w, "property float x\nproperty float y\nproperty float z\n")
+	fmt.Fprint(w, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	fmt.Fprintf(w, "element face %d\nproperty list uchar int vertex_indices\nend_header\n", len(mesh.Faces))
+
+	row := make([]byte, 15)
+	for i, v := range mesh.Vertices {
+		if err := checkCancelled(ctx, i); err != nil {
+			return err
+		}
+		for j := 0; j < 3; j++ {
+			binary.LittleEndian.PutUint32(row[j*4:], math.Float32bits(float32(v[j])))
+		}
+		copy(row[12:15], mesh.Colors[i][:])
+		if _, err := w.Write(row); err != nil {
+			return fmt.Errorf("fail to write mesh: %w", err)
+		}
+	}
+	face := make([]byte, 13)
+	face[0] = 3
+	for i, f := range mesh.Faces {
+		if err := checkCancelled(ctx, i); err != nil {
+			return err
+		}
+		for j := 0; j < 3; j++ {
+			binary.LittleEndian.PutUint32(face[1+j*4:], uint32(f[j]))
+		}
+		if _, err := w.Write(face); err != nil {
+			return fmt.Errorf("fail to write mesh: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("fail to write mesh: %w", err)
+	}
+	return nil
+}
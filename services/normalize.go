@@ -0,0 +1,262 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// SceneUp 是归一化后场景的上方向。3DGS与网页查看器均沿用COLMAP约定，以 -Y 为上。
+var SceneUp = vec3{0, -1, 0}
+
+// SceneTransform 记录场景归一化使用的相似变换 p' = Scale * Rotation * (p - Center)。
+type SceneTransform struct {
+	Up       [3]float64    `json:"up"`       // 估计出的原始上方向
+	Center   [3]float64    `json:"center"`   // 原始坐标系下的内容中心
+	Scale    float64       `json:"scale"`    // 统一缩放系数
+	Rotation [3][3]float64 `json:"rotation"` // 将 Up 对齐到 SceneUp 的旋转
+	Matrix   [16]float64   `json:"matrix"`   // 行优先的4x4齐次变换矩阵
+}
+
+// NormalizeOptions 描述场景归一化的参数。
+type NormalizeOptions struct {
+	TargetRadius    float64 // 归一化后内容的半径
+	RansacIters     int     // RANSAC迭代次数
+	RansacSamples   int     // 参与RANSAC的采样点数
+	InlierThreshold float64 // 内点阈值，相对于场景尺度
+	MinOpacity      float64 // 参与估计的最小不透明度
+}
+
+// DefaultNormalizeOptions 返回默认的归一化参数。
+func DefaultNormalizeOptions() NormalizeOptions {
+	return NormalizeOptions{
+		TargetRadius:    2,
+		RansacIters:     300,
+		RansacSamples:   50000,
+		InlierThreshold: 0.01,
+		MinOpacity:      0.1,
+	}
+}
+
+// EstimateSceneTransform 估计将训练结果摆正、居中并统一尺度的变换。
+// 上方向优先由相机姿态给出的平均上方向确定，再用RANSAC拟合的地面法向进行修正；
+// 没有相机姿态时仅依赖RANSAC，并将法向朝向内容较多的一侧。
+func EstimateSceneTransform(splats []Splat, cameras []CameraPose, opts NormalizeOptions) (*SceneTransform, error) {
+	points := make([]vec3, 0, len(splats))
+	for i := range splats {
+		if float64(splats[i].Color[3])/255 < opts.MinOpacity {
+			continue
+		}
+		p := splats[i].Position
+		points = append(points, vec3{float64(p[0]), float64(p[1]), float64(p[2])})
+	}
+	if len(points) < 3 {
+		return nil, fmt.Errorf("not enough splats to normalize: %d", len(points))
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	if len(points) > opts.RansacSamples {
+		rng.Shuffle(len(points), func(i, j int) { points[i], points[j] = points[j], points[i] })
+		points = points[:opts.RansacSamples]
+	}
+
+	center := medianPoint(points)
+	extent := percentileDistance(points, center, 0.9)
+	if extent == 0 {
+		return nil, fmt.Errorf("degenerate scene extent")
+	}
+
+	var cameraUp vec3
+	for i := range cameras {
+		cameraUp = cameraUp.add(cameras[i].cameraUp())
+	}
+	cameraUp = cameraUp.normalize()
+
+	up, ok := ransacGroundNormal(points, cameraUp, extent*opts.InlierThreshold, opts.RansacIters, rng)
+	if !ok {
+		if cameraUp.length() == 0 {
+			return nil, fmt.Errorf("fail to estimate up direction")
+		}
+		up = cameraUp
+	}
+
+	rotation := rotationBetween(up, SceneUp)
+	scale := opts.TargetRadius / extent
+
+	t := &SceneTransform{
+		Up:       up,
+		Center:   center,
+		Scale:    scale,
+		Rotation: rotation,
+	}
+	offset := rotation.mulVec(center).scale(-scale)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			t.Matrix[i*4+j] = rotation[i][j] * scale
+		}
+		t.Matrix[i*4+3] = offset[i]
+	}
+	t.Matrix[15] = 1
+	return t, nil
+}
+
+// ransacGroundNormal 用RANSAC在高斯中心上拟合主平面，返回朝上的单位法向。
+// hint 非零时只接受与其夹角小于45度的平面，并以其确定法向朝向。
+func ransacGroundNormal(points []vec3, hint vec3, threshold float64, iters int, rng *rand.Rand) (vec3, bool) {
+	var best, bestPoint vec3
+	bestInliers := 0
+	for it := 0; it < iters; it++ {
+		a, b, c := points[rng.Intn(len(points))], points[rng.Intn(len(points))], points[rng.Intn(len(points))]
+		normal := b.sub(a).cross(c.sub(a)).normalize()
+		if normal.length() == 0 {
+			continue
+		}
+		if hint.length() > 0 {
+			if math.Abs(normal.dot(hint)) < math.Cos(math.Pi/4) {
+				continue
+			}
+			if normal.dot(hint) < 0 {
+				normal = normal.scale(-1)
+			}
+		}
+
+		inliers := 0
+		for _, p := range points {
+			if math.Abs(p.sub(a).dot(normal)) < threshold {
+				inliers++
+			}
+		}
+		if inliers > bestInliers {
+			bestInliers, best, bestPoint = inliers, normal, a
+		}
+	}
+	// 内点不足5%时认为不存在可靠的地面
+	if bestInliers < len(points)/20 {
+		return vec3{}, false
+	}
+
+	if hint.length() == 0 {
+		// 内容应位于地面之上：法向朝向平面外点较多的一侧
+		var above, below int
+		for _, p := range points {
+			if d := p.sub(bestPoint).dot(best); d > threshold {
+				above++
+			} else if d < -threshold {
+				below++
+			}
+		}
+		if below > above {
+			best = best.scale(-1)
+		}
+	}
+	return best, true
+}
+
+// rotationBetween 返回将单位向量from旋转到to的最小旋转矩阵（Rodrigues公式）。
+func rotationBetween(from, to vec3) mat3 {
+	from, to = from.normalize(), to.normalize()
+	v := from.cross(to)
+	c := from.dot(to)
+	if c < -0.999999 {
+		// 方向相反：绕任意垂直轴旋转180度
+		axis := from.cross(vec3{1, 0, 0})
+		if axis.length() < 1e-6 {
+			axis = from.cross(vec3{0, 0, 1})
+		}
+		axis = axis.normalize()
+		var r mat3
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				r[i][j] = 2 * axis[i] * axis[j]
+			}
+			r[i][i] -= 1
+		}
+		return r
+	}
+	k := 1 / (1 + c)
+	return mat3{
+		{c + k*v[0]*v[0], k*v[0]*v[1] - v[2], k*v[0]*v[2] + v[1]},
+		{k*v[1]*v[0] + v[2], c + k*v[1]*v[1], k*v[1]*v[2] - v[0]},
+		{k*v[2]*v[0] - v[1], k*v[2]*v[1] + v[0], c + k*v[2]*v[2]},
+	}
+}
+
+// medianPoint 返回逐轴中位数组成的点，对漂浮点不敏感。
+func medianPoint(points []vec3) vec3 {
+	values := make([]float64, len(points))
+	var m vec3
+	for axis := 0; axis < 3; axis++ {
+		for i, p := range points {
+			values[i] = p[axis]
+		}
+		m[axis] = quantile(values, 0.5)
+	}
+	return m
+}
+
+// percentileDistance 返回点到center距离的p分位数。
+func percentileDistance(points []vec3, center vec3, p float64) float64 {
+	values := make([]float64, len(points))
+	for i, pt := range points {
+		values[i] = pt.sub(center).length()
+	}
+	return quantile(values, p)
+}
+
+// Apply 将变换应用到高斯点的位置、缩放与旋转上。
+func (t *SceneTransform) Apply(splats []Splat) {
+	rotation := mat3(t.Rotation)
+	center := vec3(t.Center)
+	for i := range splats {
+		s := &splats[i]
+		p := rotation.mulVec(vec3{float64(s.Position[0]), float64(s.Position[1]), float64(s.Position[2])}.sub(center)).scale(t.Scale)
+		for j := 0; j < 3; j++ {
+			s.Position[j] = float32(p[j])
+			s.Scale[j] = float32(float64(s.Scale[j]) * t.Scale)
+		}
+		s.SetQuaternion(mat3ToQuat(rotation.mul(quatToMat3(s.Quaternion()))))
+	}
+}
+
+// ApplyToCameras 将变换应用到相机姿态上，使相机与归一化后的场景保持一致。
+func (t *SceneTransform) ApplyToCameras(cameras []CameraPose) {
+	rotation := mat3(t.Rotation)
+	for i := range cameras {
+		cp := &cameras[i]
+		cp.Position = rotation.mulVec(vec3(cp.Position).sub(vec3(t.Center))).scale(t.Scale)
+		cp.Rotation = rotation.mul(mat3(cp.Rotation))
+	}
+}
+
+// JSON 返回变换的JSON表示，用于保存到作品记录。
+func (t *SceneTransform) JSON() string {
+	data, _ := json.Marshal(t)
+	return string(data)
+}
+
+// NormalizeSplatFile 对.splat文件做原地归一化。
+// camerasPath 为空或读取失败时退化为仅依赖高斯中心估计上方向。
+func NormalizeSplatFile(splatPath, camerasPath string, opts NormalizeOptions) (*SceneTransform, error) {
+	splats, err := ReadSplatFile(splatPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var cameras []CameraPose
+	if camerasPath != "" {
+		if cameras, err = ReadCameraPoses(camerasPath); err != nil {
+			cameras = nil
+		}
+	}
+
+	transform, err := EstimateSceneTransform(splats, cameras, opts)
+	if err != nil {
+		return nil, err
+	}
+	transform.Apply(splats)
+	if err := WriteSplatFile(splatPath, splats); err != nil {
+		return nil, err
+	}
+	return transform, nil
+}
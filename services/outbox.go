@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 存储操作的类型
+const (
+	StorageUpload = "upload"
+	StorageDelete = "delete"
+)
+
+// 引用对象的记录类型，协调器据此判断上传所在的事务是否已提交
+const (
+	EntityVideo         = "video"
+	EntityWork          = "work"
+	EntityRevision      = "revision"
+	EntityJob           = "job"
+	EntityJobLogSegment = "job_log_segment"
+)
+
+// outboxBatchSize 是协调器每次处理的操作数
+const outboxBatchSize = 100
+
+// StorageTx 跟踪一个数据库事务中对对象存储的修改：
+// 上传在事务提交前进行并先行记录，提交失败时删除已上传的对象；
+// 删除与数据库修改在同一事务中记录，提交后才删除对象。
+// 进程在任一步骤退出时，遗留的记录由 StartOutboxReconciler 完成或补偿。
+type StorageTx struct {
+	uploads  []*models.PendingOperation
+	removals []*models.PendingOperation
+}
+
+// RunStorageTx 在数据库事务中执行fn，并根据事务结果完成或补偿fn中的存储修改。
+func RunStorageTx(fn func(tx *gorm.DB, stx *StorageTx) error) error {
+	stx := &StorageTx{}
+	err := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(tx, stx)
+	})
+	if err != nil {
+		stx.rollback()
+		return err
+	}
+	stx.commit()
+	return nil
+}
+
+// Store 将本地文件上传为对象，entity与entityID为将在事务中引用该对象的记录（ID未知时为0）。
+// 上传前的记录独立于事务提交，即使事务回滚也会保留，以便补偿。
+func (s *StorageTx) Store(entity string, entityID uint, key, filePath string) error {
+	op, err := recordStorageOperation(config.Conf.DB, StorageUpload, entity, entityID, key, outboxGrace())
+	if err != nil {
+		return err
+	}
+	s.uploads = append(s.uploads, op)
+	return storeFile(key, filePath)
+}
+
+// Remove 在事务tx中记录对象的删除，事务提交后才真正删除对象。
+func (s *StorageTx) Remove(tx *gorm.DB, entity string, entityID uint, key string) error {
+	op, err := recordStorageOperation(tx, StorageDelete, entity, entityID, key, 0)
+	if err != nil {
+		return err
+	}
+	s.removals = append(s.removals, op)
+	return nil
+}
+
+func recordStorageOperation(db *gorm.DB, kind, entity string, entityID uint, key string, delay time.Duration) (*models.PendingOperation, error) {
+	next := time.Now().Add(delay)
+	op := models.PendingOperation{
+		Kind:          kind,
+		ObjectKey:     key,
+		Entity:        entity,
+		EntityID:      entityID,
+		NextAttemptAt: &next,
+	}
+	if err := db.Create(&op).Error; err != nil {
+		return nil, fmt.Errorf("fail to record storage operation: %w", err)
+	}
+	return &op, nil
+}
+
+// commit 在事务提交后调用：上传已生效，执行记录的删除。
+func (s *StorageTx) commit() {
+	for _, op := range s.uploads {
+		finishStorageOperation(op)
+	}
+	for _, op := range s.removals {
+		if err := database.RemoveObject(op.ObjectKey); err != nil {
+			// 保留记录，由协调器重试
+			log.Printf("storage operation %d: %v", op.ID, err)
+			continue
+		}
+		finishStorageOperation(op)
+	}
+}
+
+// rollback 在事务回滚后调用：删除已上传且未被已提交记录引用的对象（覆盖已有对象时保留）；删除记录随事务一同回滚。
+func (s *StorageTx) rollback() {
+	for _, op := range s.uploads {
+		if err := reconcileStorageOperation(op); err != nil {
+			log.Printf("storage operation %d: %v", op.ID, err)
+			continue
+		}
+		finishStorageOperation(op)
+	}
+}
+
+func finishStorageOperation(op *models.PendingOperation) {
+	if err := config.Conf.DB.Unscoped().Delete(op).Error; err != nil {
+		log.Printf("storage operation %d: fail to finish: %v", op.ID, err)
+	}
+}
+
+func outboxGrace() time.Duration {
+	if config.Conf.OutboxGraceSeconds <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(config.Conf.OutboxGraceSeconds) * time.Second
+}
+
+// StartOutboxReconciler 启动后台协程，按interval处理进程中途退出而遗留的存储操作。
+func StartOutboxReconciler(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ReconcileStorageOperations()
+			}
+		}
+	}()
+}
+
+// ReconcileStorageOperations 完成或补偿到期的存储操作：
+// 上传的对象已被记录引用时保留，否则（事务未提交）删除；待删除的对象在不再被引用时删除。
+func ReconcileStorageOperations() {
+	var ops []models.PendingOperation
+	if err := config.Conf.DB.Where("next_attempt_at <= ?", time.Now()).Order("id").
+		Limit(outboxBatchSize).Find(&ops).Error; err != nil {
+		log.Printf("fail to find storage operations: %v", err)
+		return
+	}
+	for i := range ops {
+		op := &ops[i]
+		if err := reconcileStorageOperation(op); err != nil {
+			op.Attempts++
+			retryAt := time.Now().Add(jobRetryDelay(op.Attempts))
+			config.Conf.DB.Model(op).Updates(map[string]interface{}{
+				"attempts":        op.Attempts,
+				"next_attempt_at": retryAt,
+				"error":           err.Error(),
+			})
+			log.Printf("storage operation %d: %v", op.ID, err)
+			continue
+		}
+		finishStorageOperation(op)
+	}
+}
+
+func reconcileStorageOperation(op *models.PendingOperation) error {
+	referenced, err := objectReferenced(op)
+	if err != nil {
+		return err
+	}
+	if referenced {
+		if op.Kind == StorageDelete {
+			log.Printf("storage operation %d: %s is referenced again, keep it", op.ID, op.ObjectKey)
+		}
+		return nil
+	}
+	return database.RemoveObject(op.ObjectKey)
+}
+
+// objectReferenced 判断对象是否仍被数据库中的记录引用。
+// 上传时软删除的记录也算引用（对象随记录的最终清除一同删除），删除时只看未删除的记录。
+func objectReferenced(op *models.PendingOperation) (bool, error) {
+	var count int64
+	db := config.Conf.DB
+	if op.Kind == StorageUpload {
+		db = db.Unscoped()
+	}
+	var err error
+	switch op.Entity {
+	case EntityVideo:
+		query := db.Model(&models.Video{}).Where("id = ?", op.EntityID)
+		if op.Kind == StorageDelete {
+			// 按保留规则过期的视频保留记录，但不再引用视频文件
+			query = query.Where("expired_at IS NULL")
+		}
+		err = query.Count(&count).Error
+	case EntityWork:
+		err = db.Model(&models.Work{}).Where("id = ?", op.EntityID).Count(&count).Error
+	case EntityJob:
+		err = db.Model(&models.Job{}).Where("id = ?", op.EntityID).Count(&count).Error
+	case EntityRevision:
+		err = db.Model(&models.WorkRevision{}).
+			Where("splat_key = ? OR cameras_key = ? OR checkpoint_key = ? OR preview_key = ?",
+				op.ObjectKey, op.ObjectKey, op.ObjectKey, op.ObjectKey).
+			Count(&count).Error
+	case EntityJobLogSegment:
+		err = db.Model(&models.JobLogSegment{}).Where("object_key = ?", op.ObjectKey).Count(&count).Error
+	default:
+		return false, fmt.Errorf("unknown entity %q", op.Entity)
+	}
+	if err != nil {
+		return false, fmt.Errorf("fail to check references of %s: %w", op.ObjectKey, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"myapp/config"
+	"myapp/models"
+	"myapp/testutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func localFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func pendingOperations(t *testing.T) []models.PendingOperation {
+	t.Helper()
+	var ops []models.PendingOperation
+	if err := config.Conf.DB.Order("id").Find(&ops).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+func TestRunStorageTx(t *testing.T) {
+	bucket := testutil.Setup(t)
+	video := models.Video{Title: "kept", UserID: 1, ObjectKey: "video1.mp4"}
+	config.Conf.DB.Create(&video)
+	bucket.Put("video1.mp4", []byte("original"))
+
+	// 提交：上传的对象保留，删除在提交后执行
+	err := RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		if err := stx.Store(EntityVideo, 0, "video2.mp4", localFile(t, "new.mp4", "new")); err != nil {
+			return err
+		}
+		if err := tx.Create(&models.Video{Title: "new", UserID: 1, ObjectKey: "video2.mp4"}).Error; err != nil {
+			return err
+		}
+		return stx.Remove(tx, EntityVideo, video.ID, "video1.mp4")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bucket.Keys(); len(got) != 1 || got[0] != "video2.mp4" {
+		t.Errorf("bucket after commit: %v, want only video2.mp4", got)
+	}
+
+	// 回滚：新上传的对象被删除，记录的删除随事务撤销
+	bucket.Put("video1.mp4", []byte("original"))
+	failure := errors.New("insert failed")
+	err = RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		if err := stx.Store(EntityVideo, 0, "video3.mp4", localFile(t, "other.mp4", "other")); err != nil {
+			return err
+		}
+		if err := stx.Remove(tx, EntityVideo, video.ID, "video1.mp4"); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want the error from fn", err)
+	}
+	if _, ok := bucket.Get("video3.mp4"); ok {
+		t.Error("object uploaded in a rolled back transaction was kept")
+	}
+	if _, ok := bucket.Get("video1.mp4"); !ok {
+		t.Error("object removed by a rolled back transaction was deleted")
+	}
+	if ops := pendingOperations(t); len(ops) != 0 {
+		t.Errorf("%d storage operations left after the transactions finished", len(ops))
+	}
+}
+
+func TestReconcileStorageOperations(t *testing.T) {
+	bucket := testutil.Setup(t)
+	committed := models.Video{Title: "committed", UserID: 1}
+	deleted := models.Video{Title: "deleted", UserID: 1}
+	config.Conf.DB.Create(&committed)
+	config.Conf.DB.Create(&deleted)
+	config.Conf.DB.Delete(&deleted)
+
+	// 模拟进程在各个步骤退出后遗留的操作
+	leftovers := []struct {
+		kind, entity string
+		entityID     uint
+		key          string
+	}{
+		{StorageUpload, EntityVideo, committed.ID, "video1.mp4"}, // 事务已提交，对象被引用
+		{StorageUpload, EntityVideo, 999, "video999.mp4"},        // 事务未提交
+		{StorageUpload, EntityVideo, deleted.ID, "video2.mp4"},   // 记录已软删除，对象随最终清除删除
+		{StorageDelete, EntityVideo, deleted.ID, "video2.mp4"},   // 删除已提交，对象未删除
+		{StorageDelete, EntityVideo, committed.ID, "video1.mp4"}, // 删除所在的记录又被引用
+	}
+	for _, op := range leftovers {
+		bucket.Put(op.key, []byte(op.key))
+		if _, err := recordStorageOperation(config.Conf.DB, op.kind, op.entity, op.entityID, op.key, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 未到期的操作可能属于进行中的事务，不处理
+	bucket.Put("video3.mp4", []byte("uploading"))
+	if _, err := recordStorageOperation(config.Conf.DB, StorageUpload, EntityVideo, 0, "video3.mp4", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	ReconcileStorageOperations()
+	if got := bucket.Keys(); len(got) != 2 || got[0] != "video1.mp4" || got[1] != "video3.mp4" {
+		t.Errorf("bucket after reconciling: %v, want video1.mp4 and video3.mp4", got)
+	}
+	if ops := pendingOperations(t); len(ops) != 1 || ops[0].ObjectKey != "video3.mp4" {
+		t.Errorf("remaining operations: %+v, want only the one not yet due", ops)
+	}
+}
+
+func TestReconcileStorageOperationsRetriesFailures(t *testing.T) {
+	testutil.Setup(t)
+	config.Conf.JobRetryBaseSeconds = 30
+	op, err := recordStorageOperation(config.Conf.DB, StorageUpload, "album", 1, "album1.json", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ReconcileStorageOperations()
+	ops := pendingOperations(t)
+	if len(ops) != 1 || ops[0].Attempts != 1 || ops[0].Error == "" {
+		t.Fatalf("failed operation: %+v", ops)
+	}
+	if retry := time.Until(*ops[0].NextAttemptAt); retry < 29*time.Second || retry > 30*time.Second {
+		t.Errorf("retry in %s, want 30s", retry)
+	}
+
+	// 重试时间之前不再处理
+	ReconcileStorageOperations()
+	if ops = pendingOperations(t); ops[0].Attempts != 1 {
+		t.Errorf("operation %d retried before its retry time", op.ID)
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// shC0 是0阶球谐函数的系数，用于将 f_dc 转换为颜色
+const shC0 = 0.28209479177387814
+
+// plyPropertySizes 列出PLY标量属性类型的字节数
+var plyPropertySizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4,
+	"float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+// plyProperty 是顶点元素的一个属性及其在一行中的偏移
+type plyProperty struct {
+	kind   string
+	offset int
+}
+
+// gaussianPLYProperties 是3D Gaussian Splatting训练器输出的PLY中转换为.splat所需的属性，依次为
+// 位置（0~2）、DC颜色（3~5）、不透明度（6）、缩放（7~9）与旋转四元数（10~13）
+var gaussianPLYProperties = []string{
+	"x", "y", "z",
+	"f_dc_0", "f_dc_1", "f_dc_2", "opacity",
+	"scale_0", "scale_1", "scale_2",
+	"rot_0", "rot_1", "rot_2", "rot_3",
+}
+
+// ReadGaussianPLY 读取3D Gaussian Splatting训练器输出的二进制PLY（binary_little_endian），
+// 按与 splat.py 相同的方式转换为高斯点：缩放取指数、颜色取DC分量、不透明度取sigmoid，
+// 并按体积与不透明度从大到小排序。不含高斯属性的普通点云返回错误。
+func ReadGaussianPLY(path string) ([]Splat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open ply file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("fail to stat ply file: %w", err)
+	}
+	reader := bufio.NewReaderSize(file, 4*1024*1024)
+	count, properties, rowLength, err := readPLYHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	// 文件头之后剩余的字节数
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("fail to seek ply file: %w", err)
+	}
+	if int64(count)*int64(rowLength) > info.Size()-offset+int64(reader.Buffered()) {
+		return nil, fmt.Errorf("ply file is truncated: %d vertices of %d bytes", count, rowLength)
+	}
+	props := make([]plyProperty, len(gaussianPLYProperties))
+	for i, name := range gaussianPLYProperties {
+		p, ok := properties[name]
+		if !ok {
+			return nil, fmt.Errorf("ply file is not a gaussian splat: missing property %s", name)
+		}
+		props[i] = p
+	}
+
+	splats := make([]Splat, count)
+	weights := make([]float64, count)
+	row := make([]byte, rowLength)
+	v := make([]float64, len(props))
+	for i := range splats {
+		if _, err := io.ReadFull(reader, row); err != nil {
+			return nil, fmt.Errorf("fail to read ply vertex %d: %w", i, err)
+		}
+		for j, p := range props {
+			v[j] = p.value(row)
+		}
+
+		s := &splats[i]
+		for j := 0; j < 3; j++ {
+			s.Position[j] = float32(v[j])
+			s.Scale[j] = float32(math.Exp(v[7+j]))
+			s.Color[j] = uint8(math.Max(0, math.Min(255, (0.5+shC0*v[3+j])*255)))
+		}
+		alpha := 1 / (1 + math.Exp(-v[6]))
+		s.Color[3] = uint8(math.Max(0, math.Min(255, alpha*255)))
+		s.SetQuaternion([4]float64{v[10], v[11], v[12], v[13]})
+		weights[i] = math.Exp(v[7]+v[8]+v[9]) * alpha
+	}
+
+	order := make([]int, count)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return weights[order[a]] > weights[order[b]] })
+	sorted := make([]Splat, count)
+	for i, index := range order {
+		sorted[i] = splats[index]
+	}
+	return sorted, nil
+}
+
+// readPLYHeader 读取PLY文件头，返回顶点数、顶点属性及每个顶点的字节数。
+// 只支持顶点为第一个元素、且顶点属性均为标量的二进制小端格式。
+func readPLYHeader(reader *bufio.Reader) (int, map[string]plyProperty, int, error) {
+	errInvalid := errors.New("invalid ply header")
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ply" {
+		return 0, nil, 0, errInvalid
+	}
+
+	count := -1
+	inVertex := false
+	rowLength := 0
+	properties := map[string]plyProperty{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, nil, 0, errInvalid
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 || fields[1] != "binary_little_endian" {
+				return 0, nil, 0, fmt.Errorf("unsupported ply format: %s", strings.TrimSpace(line))
+			}
+		case "element":
+			if len(fields) != 3 {
+				return 0, nil, 0, errInvalid
+			}
+			if count >= 0 || fields[1] != "vertex" {
+				if count < 0 {
+					return 0, nil, 0, fmt.Errorf("unsupported ply element before vertex: %s", fields[1])
+				}
+				inVertex = false
+				continue
+			}
+			n, err := strconv.Atoi(fields[2])
+			if err != nil || n < 0 {
+				return 0, nil, 0, errInvalid
+			}
+			count, inVertex = n, true
+		case "property":
+			if !inVertex {
+				continue
+			}
+			if len(fields) != 3 {
+				return 0, nil, 0, fmt.Errorf("unsupported ply property: %s", strings.TrimSpace(line))
+			}
+			size, ok := plyPropertySizes[fields[1]]
+			if !ok {
+				return 0, nil, 0, fmt.Errorf("unsupported ply property type: %s", fields[1])
+			}
+			properties[fields[2]] = plyProperty{kind: fields[1], offset: rowLength}
+			rowLength += size
+		case "end_header":
+			if count < 0 {
+				return 0, nil, 0, errors.New("ply file has no vertex element")
+			}
+			return count, properties, rowLength, nil
+		}
+	}
+}
+
+// value 从一行顶点数据中读取属性值
+func (p plyProperty) value(row []byte) float64 {
+	b := row[p.offset:]
+	switch p.kind {
+	case "char", "int8":
+		return float64(int8(b[0]))
+	case "uchar", "uint8":
+		return float64(b[0])
+	case "short", "int16":
+		return float64(int16(binary.LittleEndian.Uint16(b)))
+	case "ushort", "uint16":
+		return float64(binary.LittleEndian.Uint16(b))
+	case "int", "int32":
+		return float64(int32(binary.LittleEndian.Uint32(b)))
+	case "uint", "uint32":
+		return float64(binary.LittleEndian.Uint32(b))
+	case "float", "float32":
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+}
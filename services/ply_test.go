@@ -0,0 +1,185 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// gaussianPLYHeader 是训练器输出的高斯点PLY文件头，全部属性为float
+func gaussianPLYHeader(count int) string {
+	var b strings.Builder
+	b.WriteString("ply\nformat binary_little_endian 1.0\n")
+	b.WriteString("element vertex " + strconv.Itoa(count) + "\n")
+	for _, name := range gaussianPLYProperties {
+		b.WriteString("property float " + name + "\n")
+	}
+	b.WriteString("end_header\n")
+	return b.String()
+}
+
+func writeTempPLY(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "point_cloud.ply")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadPLYHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		wantErr    string
+		wantCount  int
+		wantRow    int
+		wantOffset map[string]int
+	}{
+		{
+			name:       "vertex properties",
+			header:     "ply\nformat binary_little_endian 1.0\ncomment trainer\nelement vertex 3\nproperty float x\nproperty uchar red\nproperty double y\nend_header\n",
+			wantCount:  3,
+			wantRow:    13,
+			wantOffset: map[string]int{"x": 0, "red": 4, "y": 5},
+		},
+		{
+			name:       "elements after vertex are ignored",
+			header:     "ply\nformat binary_little_endian 1.0\nelement vertex 2\nproperty float x\nelement face 1\nproperty list uchar int vertex_indices\nend_header\n",
+			wantCount:  2,
+			wantRow:    4,
+			wantOffset: map[string]int{"x": 0},
+		},
+		{
+			name:      "empty vertex element",
+			header:    "ply\nformat binary_little_endian 1.0\nelement vertex 0\nend_header\n",
+			wantCount: 0,
+		},
+		{name: "bad magic", header: "plx\nformat binary_little_endian 1.0\nend_header\n", wantErr: "invalid ply header"},
+		{name: "empty file", header: "", wantErr: "invalid ply header"},
+		{name: "ascii format", header: "ply\nformat ascii 1.0\nelement vertex 1\nend_header\n", wantErr: "unsupported ply format"},
+		{name: "big endian format", header: "ply\nformat binary_big_endian 1.0\nelement vertex 1\nend_header\n", wantErr: "unsupported ply format"},
+		{name: "no vertex element", header: "ply\nformat binary_little_endian 1.0\nend_header\n", wantErr: "no vertex element"},
+		{name: "element before vertex", header: "ply\nformat binary_little_endian 1.0\nelement face 1\nelement vertex 1\nend_header\n", wantErr: "element before vertex"},
+		{name: "malformed element", header: "ply\nformat binary_little_endian 1.0\nelement vertex\nend_header\n", wantErr: "invalid ply header"},
+		{name: "negative vertex count", header: "ply\nformat binary_little_endian 1.0\nelement vertex -1\nend_header\n", wantErr: "invalid ply header"},
+		{name: "list vertex property", header: "ply\nformat binary_little_endian 1.0\nelement vertex 1\nproperty list uchar float x\nend_header\n", wantErr: "unsupported ply property"},
+		{name: "unknown property type", header: "ply\nformat binary_little_endian 1.0\nelement vertex 1\nproperty half x\nend_header\n", wantErr: "unsupported ply property type"},
+		{name: "missing end_header", header: "ply\nformat binary_little_endian 1.0\nelement vertex 1\nproperty float x\n", wantErr: "invalid ply header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, properties, rowLength, err := readPLYHeader(bufio.NewReader(strings.NewReader(tt.header)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if count != tt.wantCount || rowLength != tt.wantRow {
+				t.Fatalf("count, row length = %d, %d, want %d, %d", count, rowLength, tt.wantCount, tt.wantRow)
+			}
+			if len(properties) != len(tt.wantOffset) {
+				t.Fatalf("properties = %v, want offsets %v", properties, tt.wantOffset)
+			}
+			for name, offset := range tt.wantOffset {
+				if p, ok := properties[name]; !ok || p.offset != offset {
+					t.Fatalf("property %s = %+v, want offset %d", name, p, offset)
+				}
+			}
+		})
+	}
+}
+
+// gaussianVertex 返回一个高斯点的全部属性值，依次对应 gaussianPLYProperties
+func gaussianVertex(x, scale, opacity float32) []float32 {
+	return []float32{
+		x, 2, 3, // 位置
+		0, 0, 0, // DC颜色，转换后为127
+		opacity,
+		scale, scale, scale,
+		1, 0, 0, 0, // 单位四元数
+	}
+}
+
+func encodeVertices(vertices ...[]float32) []byte {
+	var buf bytes.Buffer
+	for _, v := range vertices {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func TestReadGaussianPLYSortsByVolumeAndOpacity(t *testing.T) {
+	small := gaussianVertex(1, -2, 0)
+	large := gaussianVertex(10, 0, 0)
+	faint := gaussianVertex(20, 0, -4)
+	path := writeTempPLY(t, append([]byte(gaussianPLYHeader(3)), encodeVertices(small, faint, large)...))
+	splats, err := ReadGaussianPLY(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []float32
+	for _, s := range splats {
+		order = append(order, s.Position[0])
+	}
+	if len(order) != 3 || order[0] != 10 || order[1] != 20 || order[2] != 1 {
+		t.Errorf("splats ordered by x = %v, want [10 20 1]", order)
+	}
+}
+
+func TestReadGaussianPLYRejectsTruncatedFile(t *testing.T) {
+	vertex := encodeVertices(gaussianVertex(1, 0, 0))
+	// 只缺少最后一个顶点的一部分，文件总长仍大于顶点数据的长度
+	short := append([]byte(gaussianPLYHeader(2)), vertex...)
+	short = append(short, vertex[:len(vertex)-1]...)
+	for name, content := range map[string][]byte{
+		"missing last bytes":        short,
+		"count exceeds file length": append([]byte(gaussianPLYHeader(1_000_000)), vertex...),
+	} {
+		if _, err := ReadGaussianPLY(writeTempPLY(t, content)); err == nil || !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("%s: err = %v, want truncated", name, err)
+		}
+	}
+}
+
+func TestReadGaussianPLYRejectsPlainPointCloud(t *testing.T) {
+	header := "ply\nformat binary_little_endian 1.0\nelement vertex 1\nproperty float x\nproperty float y\nproperty float z\n" +
+		"property uchar red\nproperty uchar green\nproperty uchar blue\nend_header\n"
+	content := append([]byte(header), encodeVertices([]float32{1, 2, 3})...)
+	content = append(content, 255, 0, 0)
+	_, err := ReadGaussianPLY(writeTempPLY(t, content))
+	if err == nil || !strings.Contains(err.Error(), "not a gaussian splat") {
+		t.Errorf("err = %v, want not a gaussian splat", err)
+	}
+}
+
+func TestReadGaussianPLYConversion(t *testing.T) {
+	path := writeTempPLY(t, append([]byte(gaussianPLYHeader(1)), encodeVertices(gaussianVertex(1, float32(math.Log(2)), 0))...))
+	splats, err := ReadGaussianPLY(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := splats[0]
+	if s.Position != [3]float32{1, 2, 3} {
+		t.Errorf("position = %v", s.Position)
+	}
+	for i, scale := range s.Scale {
+		if math.Abs(float64(scale)-2) > 1e-6 {
+			t.Errorf("scale[%d] = %v, want exp(log 2) = 2", i, scale)
+		}
+	}
+	// DC分量为0时颜色为0.5，不透明度0经sigmoid为0.5
+	if s.Color != [4]uint8{127, 127, 127, 127} {
+		t.Errorf("color = %v", s.Color)
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// WritePointCloudPLY 将高斯中心与DC颜色写为带颜色的二进制PLY点云。
+func WritePointCloudPLY(ctx context.Context, path string, splats []Splat) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create point cloud: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 4*1024*1024)
+	fmt.Fprintf(w, "ply\nformat binary_little_endian 1.0\nelement vertex %d\n", len(splats))
+	fmt.Fprint(w, "property float x\nproperty float y\nproperty float z\n")
+	fmt.Fprint(w, "property uchar red\nproperty uchar green\nproperty uchar blue\nproperty uchar alpha\nend_header\n")
+
+	row := make([]byte, 16)
+	for i := range splats {
+		if err := checkCancelled(ctx, i); err != nil {
+			return err
+		}
+		s := &splats[i]
+		for j := 0; j < 3; j++ {
+			binary.LittleEndian.PutUint32(row[j*4:], math.Float32bits(s.Position[j]))
+		}
+		copy(row[12:16], s.Color[:])
+		if _, err := w.Write(row); err != nil {
+			return fmt.Errorf("fail to write point cloud: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("fail to write point cloud: %w", err)
+	}
+	return nil
+}
+
+// WritePointCloudXYZ 将高斯中心与DC颜色写为 "x y z r g b" 格式的文本点云。
+func WritePointCloudXYZ(ctx context.Context, path string, splats []Splat) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create point cloud: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 4*1024*1024)
+	for i := range splats {
+		if err := checkCancelled(ctx, i); err != nil {
+			return err
+		}
+		s := &splats[i]
+		if _, err := fmt.Fprintf(w, "%g %g %g %d %d %d\n",
+			s.Position[0], s.Position[1], s.Position[2], s.Color[0], s.Color[1], s.Color[2]); err != nil {
+			return fmt.Errorf("fail to write point cloud: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("fail to write point cloud: %w", err)
+	}
+	return nil
+}
+
+// lasHeader 是LAS 1.2的公共文件头（227字节）。
+type lasHeader struct {
+	Signature          [4]byte
+	FileSourceID       uint16
+	GlobalEncoding     uint16
+	ProjectID          [16]byte
+	VersionMajor       uint8
+	VersionMinor       uint8
+	SystemIdentifier   [32]byte
+	GeneratingSoftware [32]byte
+	CreationDay        uint16
+	CreationYear       uint16
+	HeaderSize         uint16
+	OffsetToPointData  uint32
+	NumberOfVLRs       uint32
+	PointFormat        uint8
+	PointRecordLength  uint16
+	NumberOfPoints     uint32
+	PointsByReturn     [5]uint32
+	Scale              [3]float64
+	Offset             [3]float64
+	MaxX, MinX         float64
+	MaxY, MinY         float64
+	MaxZ, MinZ         float64
+}
+
+// lasPoint 是LAS点格式2（含RGB）的点记录（26字节）。
+type lasPoint struct {
+	X, Y, Z        int32
+	Intensity      uint16
+	ReturnInfo     uint8
+	Classification uint8
+	ScanAngle      int8
+	UserData       uint8
+	PointSourceID  uint16
+	Red            uint16
+	Green          uint16
+	Blue           uint16
+}
+
+// WritePointCloudLAS 将高斯中心与DC颜色写为LAS 1.2（点格式2）点云。
+func WritePointCloudLAS(ctx context.Context, path string, splats []Splat) error {
+	if len(splats) == 0 {
+		return fmt.Errorf("point cloud is empty")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create point cloud: %w", err)
+	}
+	defer file.Close()
+
+	header := lasHeader{
+		VersionMajor:      1,
+		VersionMinor:      2,
+		HeaderSize:        227,
+		OffsetToPointData: 227,
+		PointFormat:       2,
+		PointRecordLength: 26,
+		NumberOfPoints:    uint32(len(splats)),
+		Scale:             [3]float64{0.0001, 0.0001, 0.0001},
+		MinX:              math.Inf(1), MinY: math.Inf(1), MinZ: math.Inf(1),
+		MaxX: math.Inf(-1), MaxY: math.Inf(-1), MaxZ: math.Inf(-1),
+	}
+	copy(header.Signature[:], "LASF")
+	copy(header.SystemIdentifier[:], "Online3D")
+	copy(header.GeneratingSoftware[:], "Online3D export")
+	now := time.Now()
+	header.CreationDay = uint16(now.YearDay())
+	header.CreationYear = uint16(now.Year())
+	header.PointsByReturn[0] = header.NumberOfPoints
+	for i := range splats {
+		p := splats[i].Position
+		header.MinX, header.MaxX = math.Min(header.MinX, float64(p[0])), math.Max(header.MaxX, float64(p[0]))
+		header.MinY, header.MaxY = math.Min(header.MinY, float64(p[1])), math.Max(header.MaxY, float64(p[1]))
+		header.MinZ, header.MaxZ = math.Min(header.MinZ, float64(p[2])), math.Max(header.MaxZ, float64(p[2]))
+	}
+	header.Offset = [3]float64{header.MinX, header.MinY, header.MinZ}
+
+	w := bufio.NewWriterSize(file, 4*1024*1024)
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("fail to write las header: %w", err)
+	}
+	for i := range splats {
+		if err := checkCancelled(ctx, i); err != nil {
+			return err
+		}
+		s := &splats[i]
+		point := lasPoint{
+			X:          int32(math.Round((float64(s.Position[0]) - header.Offset[0]) / header.Scale[0])),
+			Y:          int32(math.Round((float64(s.Position[1]) - header.Offset[1]) / header.Scale[1])),
+			Z:          int32(math.Round((float64(s.Position[2]) - header.Offset[2]) / header.Scale[2])),
+			Intensity:  uint16(s.Color[3]) * 257,
+			ReturnInfo: 1<<3 | 1, // 第1次回波，共1次回波
+			Red:        uint16(s.Color[0]) * 257,
+			Green:      uint16(s.Color[1]) * 257,
+			Blue:       uint16(s.Color[2]) * 257,
+		}
+		if err := binary.Write(w, binary.LittleEndian, &point); err != nil {
+			return fmt.Errorf("fail to write point cloud: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("fail to write point cloud: %w", err)
+	}
+	return nil
+}
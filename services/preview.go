@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// JobTypePreview 是生成环绕预览视频的任务类型
+const JobTypePreview = "preview"
+
+func init() {
+	RegisterJobHandler(JobTypePreview, func(ctx context.Context, job *models.Job) (string, error) {
+		return "", GenerateWorkPreview(ctx, job.WorkID)
+	})
+}
+
+// StartWorkPreview 创建后台任务为作品生成环绕预览视频
+func StartWorkPreview(userID, workID uint) {
+	if _, err := EnqueueJob(JobTypePreview, userID, workID, nil); err != nil {
+		log.Printf("work %d: %v", workID, err)
+		return
+	}
+	if err := config.Conf.DB.Model(&models.Work{}).Where("id = ?", workID).
+		Update("preview_status", "pending").Error; err != nil {
+		log.Printf("work %d: fail to update preview status: %v", workID, err)
+	}
+}
+
+// GenerateWorkPreview 为已完成的作品生成环绕预览视频，作为训练结束后的后处理阶段。
+// 该函数从对象存储取回作品当前版本的.splat文件，渲染后存入该版本下，
+// 并在作品记录上维护预览的生成状态。预览失败不会影响作品本身的状态。
+// ctx取消（任务被取消或服务退出）时停止渲染。
+func GenerateWorkPreview(ctx context.Context, workID uint) error {
+	opts := DefaultTurntableOptions()
+	opts.FFmpegPath = config.Conf.FFmpegPath
+	opts.Format = config.Conf.PreviewFormat
+	opts.Frames = config.Conf.PreviewFrames
+	opts.Width = config.Conf.PreviewSize
+	opts.Height = config.Conf.PreviewSize
+
+	var work models.Work
+	if err := config.Conf.DB.First(&work, workID).Error; err != nil {
+		return fmt.Errorf("fail to find work: %w", err)
+	}
+	rev, err := CurrentRevision(&work)
+	if err != nil {
+		return err
+	}
+
+	if err := setPreviewStatus(workID, rev.ID, "processing", opts.Format); err != nil {
+		return err
+	}
+
+	previewKey := fmt.Sprintf("preview%d.%s", workID, opts.Format)
+	if rev.ID != 0 {
+		previewKey = RevisionKey(workID, rev.Number, "preview."+opts.Format)
+	}
+	err = func() error {
+		splatPath, err := database.RetrieveFromBucket(rev.SplatKey)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(splatPath))
+
+		previewPath := filepath.Join(filepath.Dir(splatPath), "preview."+opts.Format)
+		if err := RenderTurntable(ctx, splatPath, previewPath, opts); err != nil {
+			return err
+		}
+		if rev.ID == 0 {
+			return storeFile(previewKey, previewPath)
+		}
+		// 覆盖已有的预览时，版本大小中减去旧文件的大小
+		added := fileSize(previewPath)
+		if rev.PreviewKey == previewKey {
+			if oldSize, err := database.ObjectSize(previewKey); err == nil {
+				added -= oldSize
+			}
+		}
+		return RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+			if err := stx.Store(EntityRevision, rev.ID, previewKey, previewPath); err != nil {
+				return err
+			}
+			return tx.Model(rev).Updates(map[string]interface{}{
+				"preview_key": previewKey,
+				"size_bytes":  gorm.Expr("size_bytes + ?", added),
+			}).Error
+		})
+	}()
+	if err != nil {
+		if updateErr := setPreviewStatus(workID, rev.ID, "failed", opts.Format); updateErr != nil {
+			log.Printf("fail to update preview status: %v", updateErr)
+		}
+		return fmt.Errorf("fail to generate preview: %w", err)
+	}
+
+	return setPreviewStatus(workID, rev.ID, "completed", opts.Format)
+}
+
+// setPreviewStatus 更新作品的预览状态；若作品的当前版本已不是revisionID，则不做修改
+func setPreviewStatus(workID, revisionID uint, status, format string) error {
+	err := config.Conf.DB.Model(&models.Work{}).Where("id = ? AND current_revision_id = ?", workID, revisionID).
+		Updates(map[string]interface{}{"preview_status": status, "preview_format": format}).Error
+	if err != nil {
+		return fmt.Errorf("preview status update error: %v", err)
+	}
+	return nil
+}
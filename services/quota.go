@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"myapp/config"
+	"myapp/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrQuotaExceeded 表示用户的存储或计算用量已达到配额
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Usage 是用户当前的资源用量，配额为0表示不限制
+type Usage struct {
+	// 存储用量（字节）：视频、作品各版本的产物、任务日志及未过期的导出文件
+	VideoBytes        int64 `json:"video_bytes"`
+	WorkBytes         int64 `json:"work_bytes"`
+	LogBytes          int64 `json:"log_bytes"`
+	ExportBytes       int64 `json:"export_bytes"`
+	StorageBytes      int64 `json:"storage_bytes"`
+	StorageQuotaBytes int64 `json:"storage_quota_bytes"`
+	// 训练累计消耗的GPU时间（分钟），包括已删除的作品、失败的尝试及正在进行的训练
+	GPUMinutes      float64 `json:"gpu_minutes"`
+	GPUQuotaMinutes int     `json:"gpu_quota_minutes"`
+}
+
+// GetUsage 统计用户的存储与计算用量。
+// 版本化之前创建的作品没有记录大小，不计入存储用量。
+func GetUsage(userID uint) (*Usage, error) {
+	return getUsage(config.Conf.DB, userID)
+}
+
+// getUsage 在db（可以是事务）中统计用户的用量
+func getUsage(db *gorm.DB, userID uint) (*Usage, error) {
+	usage := &Usage{
+		StorageQuotaBytes: int64(config.Conf.QuotaStorageMB) * 1024 * 1024,
+		GPUQuotaMinutes:   config.Conf.QuotaGPUMinutes,
+	}
+	if err := db.Model(&models.Video{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size_bytes), 0)").Scan(&usage.VideoBytes).Error; err != nil {
+		return nil, fmt.Errorf("fail to sum video usage: %w", err)
+	}
+	if err := db.Model(&models.WorkRevision{}).
+		Joins("JOIN works ON works.id = work_revisions.work_id AND works.deleted_at IS NULL").
+		Where("works.user_id = ?", userID).
+		Select("COALESCE(SUM(work_revisions.size_bytes), 0)").Scan(&usage.WorkBytes).Error; err != nil {
+		return nil, fmt.Errorf("fail to sum work usage: %w", err)
+	}
+	if err := db.Model(&models.JobLogSegment{}).
+		Joins("JOIN jobs ON jobs.id = job_log_segments.job_id").
+		Where("jobs.user_id = ?", userID).
+		Select("COALESCE(SUM(job_log_segments.size), 0)").Scan(&usage.LogBytes).Error; err != nil {
+		return nil, fmt.Errorf("fail to sum log usage: %w", err)
+	}
+	// 作品导出文件与账号导出压缩包，过期删除后不再计入
+	if err := db.Model(&models.Job{}).Where("user_id = ? AND output_bytes > 0 AND artifacts_expired_at IS NULL", userID).
+		Select("COALESCE(SUM(output_bytes), 0)").Scan(&usage.ExportBytes).Error; err != nil {
+		return nil, fmt.Errorf("fail to sum export usage: %w", err)
+	}
+	usage.StorageBytes = usage.VideoBytes + usage.WorkBytes + usage.LogBytes + usage.ExportBytes
+
+	var computeSeconds int64
+	if err := db.Unscoped().Model(&models.Work{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(compute_seconds), 0)").Scan(&computeSeconds).Error; err != nil {
+		return nil, fmt.Errorf("fail to sum compute usage: %w", err)
+	}
+	// 正在进行的训练尚未计入作品，按已运行的时间计算
+	var running []models.Job
+	if err := db.Select("started_at").Where("user_id = ? AND type = ? AND status = ? AND started_at IS NOT NULL",
+		userID, JobTypeTrain, JobRunning).Find(&running).Error; err != nil {
+		return nil, fmt.Errorf("fail to find running trainings: %w", err)
+	}
+	for _, job := range running {
+		computeSeconds += int64(time.Since(*job.StartedAt).Seconds())
+	}
+	usage.GPUMinutes = float64(computeSeconds) / 60
+	return usage, nil
+}
+
+// CheckStorageQuota 检查用户再存储incoming字节后是否超过存储配额，超过时返回包装了 ErrQuotaExceeded 的错误。
+func CheckStorageQuota(userID uint, incoming int64) error {
+	if config.Conf.QuotaStorageMB <= 0 {
+		return nil
+	}
+	usage, err := GetUsage(userID)
+	if err != nil {
+		return err
+	}
+	if usage.StorageBytes+incoming > usage.StorageQuotaBytes {
+		return fmt.Errorf("%w: storage %d of %d bytes used, %d bytes requested",
+			ErrQuotaExceeded, usage.StorageBytes, usage.StorageQuotaBytes, incoming)
+	}
+	return nil
+}
+
+// CheckTrainingQuota 检查用户能否开始新的训练：GPU用量未达到配额，且存储用量未超过配额。
+// StartTraining 在创建任务的事务中会再次检查。
+func CheckTrainingQuota(userID uint) error {
+	return checkTrainingQuota(config.Conf.DB, userID)
+}
+
+func checkTrainingQuota(db *gorm.DB, userID uint) error {
+	if config.Conf.QuotaGPUMinutes <= 0 && config.Conf.QuotaStorageMB <= 0 {
+		return nil
+	}
+	usage, err := getUsage(db, userID)
+	if err != nil {
+		return err
+	}
+	if usage.GPUQuotaMinutes > 0 && usage.GPUMinutes >= float64(usage.GPUQuotaMinutes) {
+		return fmt.Errorf("%w: %.1f of %d GPU minutes used", ErrQuotaExceeded, usage.GPUMinutes, usage.GPUQuotaMinutes)
+	}
+	if usage.StorageQuotaBytes > 0 && usage.StorageBytes >= usage.StorageQuotaBytes {
+		return fmt.Errorf("%w: storage %d of %d bytes used", ErrQuotaExceeded, usage.StorageBytes, usage.StorageQuotaBytes)
+	}
+	return nil
+}
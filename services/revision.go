@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevisionKey 返回作品某一版本下产物的对象键，如 work12/r3/work.splat。
+func RevisionKey(workID uint, number int, name string) string {
+	return fmt.Sprintf("work%d/r%d/%s", workID, number, name)
+}
+
+// CurrentRevision 返回作品当前版本的产物信息。
+// 对于版本化之前创建的作品，按旧的对象键（work<ID>.splat 等）构造一个编号为0的虚拟版本。
+func CurrentRevision(work *models.Work) (*models.WorkRevision, error) {
+	if work.CurrentRevisionID == 0 {
+		rev := &models.WorkRevision{
+			WorkID:        work.ID,
+			SplatKey:      fmt.Sprintf("work%d.splat", work.ID),
+			Transform:     work.Transform,
+			SourceVideoID: work.SourceVideoID,
+			Iterations:    work.Iterations,
+		}
+		if work.HasCameras {
+			rev.CamerasKey = fmt.Sprintf("cameras%d.json", work.ID)
+		}
+		if work.PreviewStatus == "completed" {
+			rev.PreviewKey = fmt.Sprintf("preview%d.%s", work.ID, work.PreviewFormat)
+		}
+		return rev, nil
+	}
+
+	var rev models.WorkRevision
+	if err := config.Conf.DB.First(&rev, work.CurrentRevisionID).Error; err != nil {
+		return nil, fmt.Errorf("fail to find current revision: %w", err)
+	}
+	return &rev, nil
+}
+
+// FindRevision 按版本号查找作品的版本。
+func FindRevision(workID uint, number int) (*models.WorkRevision, error) {
+	var rev models.WorkRevision
+	if err := config.Conf.DB.Where("work_id = ? AND number = ?", workID, number).First(&rev).Error; err != nil {
+		return nil, fmt.Errorf("revision %d not found: %w", number, err)
+	}
+	return &rev, nil
+}
+
+// SaveRevision 将一次处理结果保存为作品的新版本，并设为当前版本。
+// 参数:
+//
+//	tx - 数据库事务，上传失败时由调用方回滚。
+//	stx - 事务中的存储修改，事务回滚时删除已上传的对象。
+//	rev - 版本的来源信息，WorkID 必填，版本号与对象键由本函数填写。
+//	splatPath - 本版本的.splat文件。
+//	camerasPath - 本版本的相机姿态文件（已与场景对齐），为空表示没有。
+func SaveRevision(tx *gorm.DB, stx *StorageTx, rev *models.WorkRevision, splatPath, camerasPath string) error {
+	// 锁定作品记录，保证同一作品的版本号顺序分配
+	var work models.Work
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&work, rev.WorkID).Error; err != nil {
+		return fmt.Errorf("fail to lock work: %w", err)
+	}
+	var maxNumber int
+	if err := tx.Model(&models.WorkRevision{}).Where("work_id = ?", rev.WorkID).
+		Select("COALESCE(MAX(number), 0)").Scan(&maxNumber).Error; err != nil {
+		return fmt.Errorf("fail to allocate revision number: %w", err)
+	}
+
+	rev.Number = maxNumber + 1
+	rev.ParentRevisionID = work.CurrentRevisionID
+	rev.SplatKey = RevisionKey(rev.WorkID, rev.Number, "work.splat")
+	if err := stx.Store(EntityRevision, 0, rev.SplatKey, splatPath); err != nil {
+		return err
+	}
+	rev.SizeBytes = fileSize(splatPath)
+	if camerasPath != "" {
+		rev.CamerasKey = RevisionKey(rev.WorkID, rev.Number, "cameras.json")
+		if err := stx.Store(EntityRevision, 0, rev.CamerasKey, camerasPath); err != nil {
+			return err
+		}
+		rev.SizeBytes += fileSize(camerasPath)
+	}
+	if err := tx.Create(rev).Error; err != nil {
+		return fmt.Errorf("fail to create revision: %w", err)
+	}
+	return setCurrentRevision(tx, rev)
+}
+
+// PromoteRevision 将作品的某个历史版本设为当前版本。
+func PromoteRevision(workID uint, number int) (*models.WorkRevision, error) {
+	rev, err := FindRevision(workID, number)
+	if err != nil {
+		return nil, err
+	}
+	err = config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		return setCurrentRevision(tx, rev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// setCurrentRevision 将作品的当前版本指向rev，并同步作品上与版本相关的字段。
+func setCurrentRevision(tx *gorm.DB, rev *models.WorkRevision) error {
+	updates := map[string]interface{}{
+		"current_revision_id": rev.ID,
+		"transform":           rev.Transform,
+		"has_cameras":         rev.CamerasKey != "",
+		"iterations":          rev.Iterations,
+		"preview_status":      "",
+	}
+	if rev.SourceVideoID != 0 {
+		updates["source_video_id"] = rev.SourceVideoID
+	}
+	if rev.PreviewKey != "" {
+		updates["preview_status"] = "completed"
+		updates["preview_format"] = strings.TrimPrefix(path.Ext(rev.PreviewKey), ".")
+	}
+	if err := tx.Model(&models.Work{}).Where("id = ?", rev.WorkID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("fail to update current revision: %w", err)
+	}
+	return nil
+}
+
+// fileSize 返回本地文件的大小，文件不存在时返回0。
+func fileSize(filePath string) int64 {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func storeFile(key, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("fail to open file: %w", err)
+	}
+	defer file.Close()
+	return database.StoreObject(key, file)
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"fmt"
+	"myapp/config"
+	"myapp/models"
+	"sort"
+	"time"
+)
+
+// 任务优先级，数值越大越先执行
+const (
+	JobPriorityLow    = -10
+	JobPriorityNormal = 0
+	JobPriorityHigh   = 10
+)
+
+// jobTypePriorities 是各类型任务的默认优先级。
+// 预览与导出耗时短且用户在等待结果，优先于训练执行；远程任务的后处理是训练的最后一步，同样优先。
+var jobTypePriorities = map[string]int{
+	JobTypePreview:        JobPriorityHigh,
+	JobTypeExport:         JobPriorityHigh,
+	JobTypeRemoteComplete: JobPriorityHigh,
+	JobTypeStorageGC:      JobPriorityLow,
+	JobTypeAccountPurge:   JobPriorityLow,
+	JobTypeStorageScrub:   JobPriorityLow,
+	JobTypeLifecycle:      JobPriorityLow,
+	JobTypeKeyRotation:    JobPriorityLow,
+}
+
+// defaultJobDurations 是没有历史记录时各类型任务的预计耗时
+var defaultJobDurations = map[string]time.Duration{
+	JobTypeTrain:     30 * time.Minute,
+	JobTypePreview:   2 * time.Minute,
+	JobTypeExport:    time.Minute,
+	JobTypeStorageGC: 5 * time.Minute,
+}
+
+const fallbackJobDuration = 5 * time.Minute
+
+// maxSchedulerQueue 是一次调度最多考虑的排队任务数
+const maxSchedulerQueue = 1000
+
+// JobPriority 返回某一类型任务的默认优先级。
+func JobPriority(jobType string) int {
+	if priority, ok := jobTypePriorities[jobType]; ok {
+		return priority
+	}
+	return JobPriorityNormal
+}
+
+// schedulerState 是调度所需的队列快照
+type schedulerState struct {
+	queued      []models.Job       // 按优先级降序、ID升序排列
+	running     []models.Job       // 运行中的任务
+	userRunning map[uint]int       // 每个用户运行中的任务数
+	lastStarted map[uint]time.Time // 每个用户最近一次有任务开始执行的时间
+	userCap     int                // 每个用户同时运行的任务数上限
+}
+
+// loadSchedulerState 读取types类型任务的排队与运行情况。
+func loadSchedulerState(types []string) (*schedulerState, error) {
+	state := &schedulerState{
+		userRunning: map[uint]int{},
+		lastStarted: map[uint]time.Time{},
+		userCap:     config.Conf.JobUserConcurrency,
+	}
+	if state.userCap < 1 {
+		state.userCap = 1
+	}
+
+	// 等待重试的任务在重试时间之前不参与调度
+	if err := config.Conf.DB.Where("status = ? AND type IN ?", JobQueued, types).
+		Where("next_run_at IS NULL OR next_run_at <= ?", time.Now()).
+		Order("priority DESC, id").Limit(maxSchedulerQueue).
+		Select("id", "type", "status", "priority", "user_id", "work_id", "created_at").
+		Find(&state.queued).Error; err != nil {
+		return nil, fmt.Errorf("fail to load queued jobs: %w", err)
+	}
+	// 用户的并发上限对所有运行中的任务生效，包括其他执行器领取的任务
+	if err := config.Conf.DB.Where("status = ?", JobRunning).
+		Select("id", "type", "status", "user_id", "started_at").
+		Find(&state.running).Error; err != nil {
+		return nil, fmt.Errorf("fail to load running jobs: %w", err)
+	}
+	for _, job := range state.running {
+		state.userRunning[job.UserID]++
+	}
+
+	// 轮转只在有排队任务的用户之间进行，只需这些用户的记录
+	userIDs := make([]uint, 0, len(state.queued))
+	seen := map[uint]bool{}
+	for _, job := range state.queued {
+		if !seen[job.UserID] {
+			seen[job.UserID] = true
+			userIDs = append(userIDs, job.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return state, nil
+	}
+	var lastStarted []struct {
+		UserID    uint
+		StartedAt time.Time
+	}
+	if err := config.Conf.DB.Model(&models.Job{}).Where("user_id IN ? AND started_at IS NOT NULL", userIDs).
+		Select("user_id, MAX(started_at) AS started_at").Group("user_id").
+		Scan(&lastStarted).Error; err != nil {
+		return nil, fmt.Errorf("fail to load job history: %w", err)
+	}
+	for _, row := range lastStarted {
+		state.lastStarted[row.UserID] = row.StartedAt
+	}
+	return state, nil
+}
+
+// pick 选出下一个应执行的任务在queued中的下标，没有可执行任务时返回-1。
+// 先比较优先级；同一优先级内轮转各用户，最久没有任务开始执行的用户优先；
+// 已达到并发上限的用户的任务暂不执行。
+func (s *schedulerState) pick() int {
+	best := -1
+	for i, job := range s.queued {
+		if s.userRunning[job.UserID] >= s.userCap {
+			continue
+		}
+		if best == -1 {
+			best = i
+			continue
+		}
+		current := s.queued[best]
+		if job.Priority != current.Priority {
+			// queued按优先级降序排列，之后的任务优先级只会更低
+			break
+		}
+		if s.lastStarted[job.UserID].Before(s.lastStarted[current.UserID]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// start 在快照中将下标为i的任务记为在now开始执行。
+func (s *schedulerState) start(i int, now time.Time) models.Job {
+	job := s.queued[i]
+	s.queued = append(s.queued[:i], s.queued[i+1:]...)
+	s.userRunning[job.UserID]++
+	s.lastStarted[job.UserID] = now
+	return job
+}
+
+// QueueEstimate 是排队任务的队列位置与预计时间
+type QueueEstimate struct {
+	Position int           // 从1开始的执行顺序
+	StartIn  time.Duration // 预计多久后开始执行
+	FinishIn time.Duration // 预计多久后执行完成
+}
+
+// EstimateQueue 按调度规则模拟执行顺序，估算所有排队任务的队列位置与预计时间。
+// 任务耗时按各类型已完成任务的平均耗时估算，执行器数量取本进程的JobWorkers与活跃的远程节点数之和。
+func EstimateQueue() (map[uint]QueueEstimate, error) {
+	types := registeredJobTypes()
+	estimates := map[uint]QueueEstimate{}
+	if len(types) == 0 {
+		return estimates, nil
+	}
+	state, err := loadSchedulerState(types)
+	if err != nil {
+		return nil, err
+	}
+	durations, err := averageJobDurations()
+	if err != nil {
+		return nil, err
+	}
+	duration := func(jobType string) time.Duration {
+		if d, ok := durations[jobType]; ok {
+			return d
+		}
+		if d, ok := defaultJobDurations[jobType]; ok {
+			return d
+		}
+		return fallbackJobDuration
+	}
+
+	workers := config.Conf.JobWorkers + ActiveWorkerCount()
+	return state.estimate(workers, time.Now(), duration), nil
+}
+
+// estimate 以workers个执行器从now开始模拟执行顺序，duration 返回各类型任务的预计耗时。
+// 模拟会消耗快照中的排队任务。
+func (s *schedulerState) estimate(workers int, now time.Time, duration func(jobType string) time.Duration) map[uint]QueueEstimate {
+	estimates := map[uint]QueueEstimate{}
+	if workers < 1 {
+		workers = 1
+	}
+
+	// 以“从现在起的时间”模拟，slots记录每个执行中任务的预计结束时间
+	type slot struct {
+		userID uint
+		end    time.Duration
+	}
+	var slots []slot
+	for _, job := range s.running {
+		remaining := duration(job.Type)
+		if job.StartedAt != nil {
+			remaining -= now.Sub(*job.StartedAt)
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+		slots = append(slots, slot{userID: job.UserID, end: remaining})
+	}
+
+	var elapsed time.Duration
+	position := 0
+	for len(s.queued) > 0 {
+		// 有空闲执行器时按调度规则开始任务
+		for len(slots) < workers {
+			i := s.pick()
+			if i < 0 {
+				break
+			}
+			job := s.start(i, now.Add(elapsed))
+			position++
+			d := duration(job.Type)
+			estimates[job.ID] = QueueEstimate{Position: position, StartIn: elapsed, FinishIn: elapsed + d}
+			slots = append(slots, slot{userID: job.UserID, end: elapsed + d})
+		}
+		if len(slots) == 0 {
+			// 剩余任务无法执行（不应发生）
+			break
+		}
+		// 推进到最早结束的任务
+		sort.Slice(slots, func(a, b int) bool { return slots[a].end < slots[b].end })
+		elapsed = slots[0].end
+		s.userRunning[slots[0].userID]--
+		slots = slots[1:]
+	}
+	return estimates
+}
+
+// averageJobDurations 返回各类型已完成任务的平均耗时。
+func averageJobDurations() (map[string]time.Duration, error) {
+	var rows []struct {
+		Type    string
+		Seconds float64
+	}
+	if err := config.Conf.DB.Model(&models.Job{}).
+		Where("status = ? AND started_at IS NOT NULL AND finished_at IS NOT NULL", JobCompleted).
+		Select("type, AVG(TIMESTAMPDIFF(SECOND, started_at, finished_at)) AS seconds").
+		Group("type").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("fail to load job durations: %w", err)
+	}
+	durations := make(map[string]time.Duration, len(rows))
+	for _, row := range rows {
+		durations[row.Type] = time.Duration(row.Seconds * float64(time.Second))
+	}
+	return durations, nil
+}
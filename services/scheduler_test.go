@@ -0,0 +1,95 @@
+package services
+
+import (
+	"myapp/models"
+	"testing"
+	"time"
+)
+
+// queuedJob 构造排队任务，ID同时表示创建顺序
+func queuedJob(id, userID uint, priority int) models.Job {
+	job := models.Job{Type: JobTypeTrain, Status: JobQueued, Priority: priority, UserID: userID}
+	job.ID = id
+	return job
+}
+
+// newSchedulerState 以按优先级降序、ID升序排列的queued构造调度快照
+func newSchedulerState(userCap int, queued ...models.Job) *schedulerState {
+	return &schedulerState{
+		queued:      queued,
+		userRunning: map[uint]int{},
+		lastStarted: map[uint]time.Time{},
+		userCap:     userCap,
+	}
+}
+
+func TestSchedulerPick(t *testing.T) {
+	now := time.Now()
+
+	// 优先级高的任务先执行，即使其用户刚有任务开始
+	s := newSchedulerState(1, queuedJob(5, 1, JobPriorityHigh), queuedJob(2, 2, JobPriorityNormal))
+	s.lastStarted[1] = now
+	if got := s.pick(); got != 0 {
+		t.Errorf("picked %d, want the high priority job", got)
+	}
+
+	// 同一优先级内，最久没有任务开始的用户先执行；从未执行过任务的用户最先
+	s = newSchedulerState(1, queuedJob(1, 1, 0), queuedJob(2, 2, 0), queuedJob(3, 3, 0), queuedJob(4, 3, 0))
+	s.lastStarted[1] = now.Add(-time.Minute)
+	s.lastStarted[2] = now.Add(-time.Hour)
+	if got := s.queued[s.pick()].ID; got != 3 {
+		t.Errorf("picked job %d, want job 3 of the user without history", got)
+	}
+	s.lastStarted[3] = now
+	if got := s.queued[s.pick()].ID; got != 2 {
+		t.Errorf("picked job %d, want job 2 of the longest waiting user", got)
+	}
+
+	// 达到并发上限的用户被跳过，即使这意味着先执行优先级较低的任务
+	s = newSchedulerState(2, queuedJob(1, 1, JobPriorityHigh), queuedJob(2, 1, 0), queuedJob(3, 2, JobPriorityLow))
+	s.userRunning[1] = 2
+	if got := s.queued[s.pick()].ID; got != 3 {
+		t.Errorf("picked job %d, want job 3 while user 1 is at its cap", got)
+	}
+	s.userRunning[2] = 2
+	if got := s.pick(); got != -1 {
+		t.Errorf("picked %d with every user at its cap, want -1", got)
+	}
+}
+
+func TestSchedulerEstimate(t *testing.T) {
+	now := time.Now()
+	tenMinutes := func(string) time.Duration { return 10 * time.Minute }
+
+	// 单个执行器：用户1排了三个任务、用户2排了一个，两人轮流执行
+	s := newSchedulerState(1, queuedJob(1, 1, 0), queuedJob(2, 1, 0), queuedJob(3, 1, 0), queuedJob(4, 2, 0))
+	estimates := s.estimate(1, now, tenMinutes)
+	want := map[uint]QueueEstimate{
+		1: {Position: 1, StartIn: 0, FinishIn: 10 * time.Minute},
+		4: {Position: 2, StartIn: 10 * time.Minute, FinishIn: 20 * time.Minute},
+		2: {Position: 3, StartIn: 20 * time.Minute, FinishIn: 30 * time.Minute},
+		3: {Position: 4, StartIn: 30 * time.Minute, FinishIn: 40 * time.Minute},
+	}
+	for id, w := range want {
+		if got := estimates[id]; got != w {
+			t.Errorf("job %d: %+v, want %+v", id, got, w)
+		}
+	}
+
+	// 两个执行器均空闲，但用户的并发上限为1，第二个任务要等第一个结束
+	s = newSchedulerState(1, queuedJob(1, 1, 0), queuedJob(2, 1, 0))
+	estimates = s.estimate(2, now, tenMinutes)
+	if got := estimates[2].StartIn; got != 10*time.Minute {
+		t.Errorf("second job of a capped user starts in %s, want 10m", got)
+	}
+
+	// 已运行4分钟的任务还需6分钟，之后才有空闲的执行器
+	started := now.Add(-4 * time.Minute)
+	s = newSchedulerState(2, queuedJob(7, 2, 0))
+	s.running = []models.Job{{Type: JobTypeTrain, UserID: 1, StartedAt: &started}}
+	s.userRunning[1] = 1
+	estimates = s.estimate(1, now, tenMinutes)
+	if got := estimates[7]; got.Position != 1 || got.StartIn != 6*time.Minute {
+		t.Errorf("job behind a running job: %+v, want position 1 starting in 6m", got)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// JobTypeStorageScrub 是定期重新校验对象完整性的任务类型
+const JobTypeStorageScrub = "storage_scrub"
+
+func init() {
+	RegisterJobHandler(JobTypeStorageScrub, func(ctx context.Context, job *models.Job) (string, error) {
+		return ScrubObjects(ctx, max(config.Conf.ScrubBatchSize, 1))
+	})
+}
+
+// StartStorageScrub 启动后台协程，按interval创建完整性巡检任务，interval 不大于0时不启动。
+func StartStorageScrub(ctx context.Context, interval time.Duration) {
+	startPeriodicJob(ctx, interval, JobTypeStorageScrub, struct{}{})
+}
+
+// ScrubObjects 重新读取最久未校验的limit个对象，与上传时记录的大小和SHA-256比较，
+// 记录校验时间与发现的问题。多次执行后轮流覆盖全部对象。
+func ScrubObjects(ctx context.Context, limit int) (string, error) {
+	var objects []models.StoredObject
+	if err := config.Conf.DB.Order("verified_at IS NOT NULL, verified_at, id").Limit(limit).
+		Find(&objects).Error; err != nil {
+		return "", fmt.Errorf("fail to find objects to scrub: %w", err)
+	}
+
+	var verified, corrupted, missing int
+	var storageErr minio.ErrorResponse
+	for i := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		object := &objects[i]
+		err := database.VerifyObject(ctx, object.ObjectKey)
+		verifyError := ""
+		switch {
+		case err == nil:
+		case errors.Is(err, database.ErrChecksumMismatch):
+			corrupted++
+			verifyError = err.Error()
+		case errors.As(err, &storageErr) && storageErr.Code == "NoSuchKey":
+			missing++
+			verifyError = err.Error()
+		default:
+			// 临时错误，下次巡检时重试
+			log.Printf("storage scrub: %v", err)
+			continue
+		}
+		if verifyError != "" {
+			log.Printf("storage scrub: %s", verifyError)
+		}
+		verified++
+		now := time.Now()
+		if err := config.Conf.DB.Model(object).Updates(map[string]interface{}{
+			"verified_at":  &now,
+			"verify_error": verifyError,
+		}).Error; err != nil {
+			log.Printf("storage scrub: %v", err)
+		}
+	}
+	return fmt.Sprintf("verified=%d corrupted=%d missing=%d", verified, corrupted, missing), ctx.Err()
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// SplatRowLength 是.splat文件中单个高斯点所占的字节数：
+// 位置(3*float32) + 缩放(3*float32) + 颜色RGBA(4*uint8) + 旋转四元数(4*uint8)。
+const SplatRowLength = 3*4 + 3*4 + 4 + 4
+
+// Splat 表示.splat文件中的一个高斯点。
+type Splat struct {
+	Position [3]float32
+	Scale    [3]float32
+	Color    [4]uint8
+	Rotation [4]uint8 // 按 w,x,y,z 顺序编码为 (q*128+128)
+}
+
+// Quaternion 将编码后的旋转解码为单位四元数（w,x,y,z）。
+func (s *Splat) Quaternion() [4]float64 {
+	var q [4]float64
+	var norm float64
+	for i := 0; i < 4; i++ {
+		q[i] = (float64(s.Rotation[i]) - 128) / 128
+		norm += q[i] * q[i]
+	}
+	if norm == 0 {
+		return [4]float64{1, 0, 0, 0}
+	}
+	norm = math.Sqrt(norm)
+	for i := range q {
+		q[i] /= norm
+	}
+	return q
+}
+
+// SetQuaternion 将单位四元数（w,x,y,z）编码回.splat的字节表示。
+func (s *Splat) SetQuaternion(q [4]float64) {
+	var norm float64
+	for _, v := range q {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		q, norm = [4]float64{1, 0, 0, 0}, 1
+	}
+	for i := 0; i < 4; i++ {
+		v := q[i]/norm*128 + 128
+		s.Rotation[i] = uint8(math.Max(0, math.Min(255, math.Round(v))))
+	}
+}
+
+// ReadSplatFile 读取.splat文件中的全部高斯点。
+func ReadSplatFile(path string) ([]Splat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open splat file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("fail to stat splat file: %w", err)
+	}
+	if info.Size()%SplatRowLength != 0 {
+		return nil, fmt.Errorf("invalid splat file size: %d", info.Size())
+	}
+
+	splats := make([]Splat, 0, info.Size()/SplatRowLength)
+	reader := bufio.NewReaderSize(file, 4*1024*1024)
+	row := make([]byte, SplatRowLength)
+	for {
+		if _, err := io.ReadFull(reader, row); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("fail to read splat file: %w", err)
+		}
+		var s Splat
+		for i := 0; i < 3; i++ {
+			s.Position[i] = math.Float32frombits(binary.LittleEndian.Uint32(row[i*4:]))
+			s.Scale[i] = math.Float32frombits(binary.LittleEndian.Uint32(row[12+i*4:]))
+		}
+		copy(s.Color[:], row[24:28])
+		copy(s.Rotation[:], row[28:32])
+		splats = append(splats, s)
+	}
+	return splats, nil
+}
+
+// WriteSplatFile 将高斯点写入.splat文件（覆盖已有文件）。
+func WriteSplatFile(path string, splats []Splat) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create splat file: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriterSize(file, 4*1024*1024)
+	row := make([]byte, SplatRowLength)
+	for i := range splats {
+		s := &splats[i]
+		for j := 0; j < 3; j++ {
+			binary.LittleEndian.PutUint32(row[j*4:], math.Float32bits(s.Position[j]))
+			binary.LittleEndian.PutUint32(row[12+j*4:], math.Float32bits(s.Scale[j]))
+		}
+		copy(row[24:28], s.Color[:])
+		copy(row[28:32], s.Rotation[:])
+		if _, err := writer.Write(row); err != nil {
+			return fmt.Errorf("fail to write splat file: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("fail to write splat file: %w", err)
+	}
+	return nil
+}
+
+// SplatBounds 计算高斯中心的稳健包围盒。
+// 使用lower/upper分位数（0~1）剔除离群的漂浮点，返回包围盒的最小点与最大点。
+func SplatBounds(splats []Splat, lower, upper float64) (min, max [3]float64) {
+	if len(splats) == 0 {
+		return
+	}
+	values := make([]float64, len(splats))
+	for axis := 0; axis < 3; axis++ {
+		for i := range splats {
+			values[i] = float64(splats[i].Position[axis])
+		}
+		min[axis] = quantile(values, lower)
+		max[axis] = quantile(values, upper)
+	}
+	return min, max
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobTypeStorageGC 是核对对象存储与数据库的任务类型
+const JobTypeStorageGC = "storage_gc"
+
+// StorageGCPayload 是存储核对任务的参数
+type StorageGCPayload struct {
+	// 是否删除孤立对象与过期的临时目录并补全缺少的大小，为false时只报告差异
+	Delete bool `json:"delete"`
+}
+
+// OrphanObject 是没有任何记录（包括软删除的记录）引用的对象
+type OrphanObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Reason       string    `json:"reason"`
+}
+
+// MissingObject 是记录引用了但存储桶中不存在的对象
+type MissingObject struct {
+	Key      string `json:"key"`
+	Entity   string `json:"entity"`
+	EntityID uint   `json:"entity_id"`
+}
+
+// StorageReport 是一次存储核对的结果
+type StorageReport struct {
+	Objects        int             `json:"objects"`
+	Bytes          int64           `json:"bytes"`
+	OrphanObjects  []OrphanObject  `json:"orphan_objects"`
+	OrphanBytes    int64           `json:"orphan_bytes"`
+	UnknownObjects []string        `json:"unknown_objects"`
+	MissingObjects []MissingObject `json:"missing_objects"`
+	StaleTempDirs  []string        `json:"stale_temp_dirs"`
+	// Delete 为true时实际删除的对象数与临时目录数
+	RemovedObjects  int `json:"removed_objects"`
+	RemovedTempDirs int `json:"removed_temp_dirs"`
+}
+
+// storageTempDir 是上传与下载文件使用的本地临时目录
+const storageTempDir = "temp"
+
+// 对象键的格式，见 RevisionKey、jobArtifactKey、jobLogSegmentKey 等
+var (
+	videoKeyPattern       = regexp.MustCompile(`^video(\d+)\.\w+$`)
+	legacyWorkKeyPattern  = regexp.MustCompile(`^(?:work|cameras|preview)(\d+)\.\w+$`)
+	revisionKeyPattern    = regexp.MustCompile(`^work(\d+)/r\d+/[^/]+$`)
+	jobLogKeyPattern      = regexp.MustCompile(`^job(\d+)/log/[^/]+$`)
+	jobArtifactKeyPattern = regexp.MustCompile(`^job(\d+)/[^/]+$`)
+	exportKeyPattern      = regexp.MustCompile(`^export(\d+)\.\w+$`)
+)
+
+func init() {
+	RegisterJobHandler(JobTypeStorageGC, func(ctx context.Context, job *models.Job) (string, error) {
+		var payload StorageGCPayload
+		if err := DecodeJobPayload(job, &payload); err != nil {
+			return "", NewJobError(FailureInvalidInput, "", err)
+		}
+		report, err := ReconcileStorage(ctx, payload.Delete)
+		if err != nil {
+			return "", err
+		}
+		return report.Summary(), nil
+	})
+}
+
+// Summary 返回报告的一行摘要，作为任务结果保存。
+func (r *StorageReport) Summary() string {
+	return fmt.Sprintf("objects=%d orphans=%d (%d bytes) unknown=%d missing=%d stale_temp_dirs=%d removed_objects=%d removed_temp_dirs=%d",
+		r.Objects, len(r.OrphanObjects), r.OrphanBytes, len(r.UnknownObjects), len(r.MissingObjects),
+		len(r.StaleTempDirs), r.RemovedObjects, r.RemovedTempDirs)
+}
+
+// storageReferences 是数据库中（包括软删除的记录）引用对象的ID与对象键
+type storageReferences struct {
+	videos    map[uint]bool
+	works     map[uint]bool
+	jobs      map[uint]string // 任务ID -> 状态
+	keys      map[string]bool // 版本产物与日志分段的对象键
+	operating map[string]bool // 存在未完成存储操作的对象键，交由协调器处理
+}
+
+func loadStorageReferences() (*storageReferences, error) {
+	refs := &storageReferences{
+		videos:    map[uint]bool{},
+		works:     map[uint]bool{},
+		jobs:      map[uint]string{},
+		keys:      map[string]bool{},
+		operating: map[string]bool{},
+	}
+	db := config.Conf.DB.Unscoped()
+
+	var ids []uint
+	if err := db.Model(&models.Video{}).Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("fail to load videos: %w", err)
+	}
+	for _, id := range ids {
+		refs.videos[id] = true
+	}
+	ids = nil
+	if err := db.Model(&models.Work{}).Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("fail to load works: %w", err)
+	}
+	for _, id := range ids {
+		refs.works[id] = true
+	}
+
+	var jobs []models.Job
+	if err := db.Select("id", "status").Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("fail to load jobs: %w", err)
+	}
+	for _, job := range jobs {
+		refs.jobs[job.ID] = job.Status
+	}
+
+	var revisions []models.WorkRevision
+	if err := db.Select("splat_key", "cameras_key", "checkpoint_key", "preview_key").Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("fail to load revisions: %w", err)
+	}
+	for _, rev := range revisions {
+		for _, key := range []string{rev.SplatKey, rev.CamerasKey, rev.CheckpointKey, rev.PreviewKey} {
+			if key != "" {
+				refs.keys[key] = true
+			}
+		}
+	}
+	var keys []string
+	if err := db.Model(&models.JobLogSegment{}).Pluck("object_key", &keys).Error; err != nil {
+		return nil, fmt.Errorf("fail to load log segments: %w", err)
+	}
+	for _, key := range keys {
+		refs.keys[key] = true
+	}
+	keys = nil
+	if err := config.Conf.DB.Model(&models.PendingOperation{}).Pluck("object_key", &keys).Error; err != nil {
+		return nil, fmt.Errorf("fail to load storage operations: %w", err)
+	}
+	for _, key := range keys {
+		refs.operating[key] = true
+	}
+	return refs, nil
+}
+
+// orphanReason 判断对象是否孤立，返回原因；对象被引用时返回空字符串，
+// 对象键不符合已知格式时 known 为false。
+func (refs *storageReferences) orphanReason(key string) (reason string, known bool) {
+	parseID := func(s string) uint {
+		id, _ := strconv.ParseUint(s, 10, 64)
+		return uint(id)
+	}
+	if m := videoKeyPattern.FindStringSubmatch(key); m != nil {
+		if !refs.videos[parseID(m[1])] {
+			return "video not found", true
+		}
+		return "", true
+	}
+	if m := legacyWorkKeyPattern.FindStringSubmatch(key); m != nil {
+		if !refs.works[parseID(m[1])] {
+			return "work not found", true
+		}
+		return "", true
+	}
+	if revisionKeyPattern.MatchString(key) {
+		if !refs.keys[key] {
+			return "revision not found", true
+		}
+		return "", true
+	}
+	if jobLogKeyPattern.MatchString(key) {
+		if !refs.keys[key] {
+			return "log segment not found", true
+		}
+		return "", true
+	}
+	if m := jobArtifactKeyPattern.FindStringSubmatch(key); m != nil {
+		// 远程节点上传的产物只在任务完成前暂存
+		status, ok := refs.jobs[parseID(m[1])]
+		if !ok {
+			return "job not found", true
+		}
+		if status != JobQueued && status != JobRunning {
+			return "job " + status, true
+		}
+		return "", true
+	}
+	if m := exportKeyPattern.FindStringSubmatch(key); m != nil {
+		if _, ok := refs.jobs[parseID(m[1])]; !ok {
+			return "export job not found", true
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// ReconcileStorage 列出存储桶中的全部对象并与数据库比较：
+// 没有记录（包括软删除的记录）引用的对象为孤立对象，记录引用但不存在的对象为缺失对象。
+// 同时检查本地临时目录中长时间未修改的目录。del为true时删除孤立对象与过期的临时目录，
+// 并补全缺少大小的视频与作品版本的大小；为false时不修改任何数据。缺失对象无法恢复，只报告。
+// 最近（OUTBOX_GRACE_SECONDS 内）修改的对象及存在未完成存储操作的对象可能属于进行中的事务，不视为孤立。
+func ReconcileStorage(ctx context.Context, del bool) (*StorageReport, error) {
+	// 先读取引用再列出对象，列出期间新上传的对象因修改时间较新而被跳过
+	refs, err := loadStorageReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StorageReport{}
+	present := map[string]int64{}
+	cutoff := time.Now().Add(-outboxGrace())
+	err = database.ListObjects(ctx, "", func(obj database.ObjectInfo) error {
+		report.Objects++
+		report.Bytes += obj.Size
+		present[obj.Key] = obj.Size
+		reason, known := refs.orphanReason(obj.Key)
+		if !known {
+			report.UnknownObjects = append(report.UnknownObjects, obj.Key)
+			return nil
+		}
+		if reason == "" || refs.operating[obj.Key] || obj.LastModified.After(cutoff) {
+			return nil
+		}
+		report.OrphanObjects = append(report.OrphanObjects, OrphanObject{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+			Reason:       reason,
+		})
+		report.OrphanBytes += obj.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if report.MissingObjects, err = findMissingObjects(present); err != nil {
+		return nil, err
+	}
+	if report.StaleTempDirs, err = findStaleTempDirs(); err != nil {
+		return nil, err
+	}
+
+	if del {
+		if err := backfillObjectSizes(present); err != nil {
+			return nil, err
+		}
+		for _, obj := range report.OrphanObjects {
+			if err := database.RemoveObject(obj.Key); err != nil {
+				log.Printf("storage gc: %v", err)
+				continue
+			}
+			report.RemovedObjects++
+		}
+		for _, dir := range report.StaleTempDirs {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Printf("storage gc: fail to remove %s: %v", dir, err)
+				continue
+			}
+			report.RemovedTempDirs++
+		}
+	}
+	log.Printf("storage gc: %s", report.Summary())
+	return report, nil
+}
+
+// findMissingObjects 返回未删除的视频、作品版本与日志分段中引用的、存储桶中不存在的对象。
+func findMissingObjects(sizes map[string]int64) ([]MissingObject, error) {
+	present := func(key string) bool {
+		_, ok := sizes[key]
+		return ok
+	}
+	var missing []MissingObject
+
+	// 旧的视频记录没有保存对象键，扩展名取决于上传的文件，按ID匹配
+	videoIDs := map[uint]bool{}
+	for key := range sizes {
+		if m := videoKeyPattern.FindStringSubmatch(key); m != nil {
+			id, _ := strconv.ParseUint(m[1], 10, 64)
+			videoIDs[uint(id)] = true
+		}
+	}
+	var videos []models.Video
+	if err := config.Conf.DB.Select("id", "object_key").Where("expired_at IS NULL").Find(&videos).Error; err != nil {
+		return nil, fmt.Errorf("fail to load videos: %w", err)
+	}
+	for _, video := range videos {
+		if video.ObjectKey != "" {
+			if !present(video.ObjectKey) {
+				missing = append(missing, MissingObject{Key: video.ObjectKey, Entity: EntityVideo, EntityID: video.ID})
+			}
+		} else if !videoIDs[video.ID] {
+			missing = append(missing, MissingObject{Key: fmt.Sprintf("video%d.*", video.ID), Entity: EntityVideo, EntityID: video.ID})
+		}
+	}
+
+	var works []models.Work
+	if err := config.Conf.DB.Where("status = ?", "completed").Find(&works).Error; err != nil {
+		return nil, fmt.Errorf("fail to load works: %w", err)
+	}
+	for i := range works {
+		if works[i].CurrentRevisionID != 0 {
+			continue // 版本的产物在下面检查
+		}
+		rev, err := CurrentRevision(&works[i])
+		if err != nil {
+			return nil, err
+		}
+		if !present(rev.SplatKey) {
+			missing = append(missing, MissingObject{Key: rev.SplatKey, Entity: "work", EntityID: works[i].ID})
+		}
+	}
+
+	var revisions []models.WorkRevision
+	if err := config.Conf.DB.Find(&revisions).Error; err != nil {
+		return nil, fmt.Errorf("fail to load revisions: %w", err)
+	}
+	for _, rev := range revisions {
+		for _, key := range []string{rev.SplatKey, rev.CamerasKey, rev.CheckpointKey, rev.PreviewKey} {
+			if key != "" && !present(key) {
+				missing = append(missing, MissingObject{Key: key, Entity: EntityRevision, EntityID: rev.ID})
+			}
+		}
+	}
+
+	var segments []models.JobLogSegment
+	if err := config.Conf.DB.Find(&segments).Error; err != nil {
+		return nil, fmt.Errorf("fail to load log segments: %w", err)
+	}
+	for _, segment := range segments {
+		if !present(segment.ObjectKey) {
+			missing = append(missing, MissingObject{Key: segment.ObjectKey, Entity: EntityJobLogSegment, EntityID: segment.ID})
+		}
+	}
+	return missing, nil
+}
+
+// backfillObjectSizes 补全记录大小之前上传的视频与作品版本的大小，用于统计用量。
+// bucketSizes 是存储桶中对象的大小，加密的对象为密文的大小，有上传记录的对象改用记录中的明文大小。
+func backfillObjectSizes(bucketSizes map[string]int64) error {
+	sizes := make(map[string]int64, len(bucketSizes))
+	for key, size := range bucketSizes {
+		sizes[key] = size
+	}
+	var objects []models.StoredObject
+	if err := config.Conf.DB.Select("object_key", "size").Where("sha256 <> ''").Find(&objects).Error; err != nil {
+		return fmt.Errorf("fail to load object sizes: %w", err)
+	}
+	for _, object := range objects {
+		if _, ok := sizes[object.ObjectKey]; ok {
+			sizes[object.ObjectKey] = object.Size
+		}
+	}
+
+	videoSizes := map[uint]int64{}
+	for key, size := range sizes {
+		if m := videoKeyPattern.FindStringSubmatch(key); m != nil {
+			id, _ := strconv.ParseUint(m[1], 10, 64)
+			videoSizes[uint(id)] += size
+		}
+	}
+	var videos []models.Video
+	if err := config.Conf.DB.Unscoped().Select("id").Where("size_bytes = 0").Find(&videos).Error; err != nil {
+		return fmt.Errorf("fail to load videos: %w", err)
+	}
+	for _, video := range videos {
+		if size := videoSizes[video.ID]; size > 0 {
+			config.Conf.DB.Unscoped().Model(&video).UpdateColumn("size_bytes", size)
+		}
+	}
+
+	var revisions []models.WorkRevision
+	if err := config.Conf.DB.Unscoped().Where("size_bytes = 0").Find(&revisions).Error; err != nil {
+		return fmt.Errorf("fail to load revisions: %w", err)
+	}
+	for _, rev := range revisions {
+		var size int64
+		for _, key := range []string{rev.SplatKey, rev.CamerasKey, rev.CheckpointKey, rev.PreviewKey} {
+			size += sizes[key]
+		}
+		if size > 0 {
+			config.Conf.DB.Unscoped().Model(&rev).UpdateColumn("size_bytes", size)
+		}
+	}
+	return nil
+}
+
+// tempMaxAge 返回临时目录的过期时间：目录中最新的文件超过该时间未修改，且不短于任务超时，
+// 避免删除仍在训练中的任务的文件。
+func tempMaxAge() time.Duration {
+	age := time.Duration(config.Conf.TempMaxAgeHours) * time.Hour
+	if age <= 0 {
+		age = 24 * time.Hour
+	}
+	return max(age, jobTimeout())
+}
+
+// findStaleTempDirs 返回本地临时目录下长时间未修改的子目录。
+func findStaleTempDirs() ([]string, error) {
+	entries, err := os.ReadDir(storageTempDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to read temp dir: %w", err)
+	}
+	cutoff := time.Now().Add(-tempMaxAge())
+	var stale []string
+	for _, entry := range entries {
+		dir := filepath.Join(storageTempDir, entry.Name())
+		if newestModTime(dir).Before(cutoff) {
+			stale = append(stale, dir)
+		}
+	}
+	return stale, nil
+}
+
+// newestModTime 返回目录树中最新的修改时间。
+func newestModTime(root string) time.Time {
+	var newest time.Time
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest
+}
+
+// StartStorageGC 启动后台协程，按interval创建存储核对任务；已有排队或运行中的核对任务时跳过。
+// interval 不大于0时不启动。
+func StartStorageGC(ctx context.Context, interval time.Duration, del bool) {
+	startPeriodicJob(ctx, interval, JobTypeStorageGC, StorageGCPayload{Delete: del})
+}
+
+// startPeriodicJob 启动后台协程，按interval创建jobType类型的系统任务；已有排队或运行中的同类任务时跳过。
+// interval 不大于0时不启动。
+func startPeriodicJob(ctx context.Context, interval time.Duration, jobType string, payload interface{}) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				var pending int64
+				if err := config.Conf.DB.Model(&models.Job{}).
+					Where("type = ? AND status IN ?", jobType, []string{JobQueued, JobRunning}).
+					Count(&pending).Error; err != nil || pending > 0 {
+					continue
+				}
+				if _, err := EnqueueJob(jobType, 0, 0, payload); err != nil {
+					log.Printf("%s: %v", jobType, err)
+				}
+			}
+		}
+	}()
+}
+
+// FormatStorageReport 以便于阅读的文本列出报告中的差异。
+func FormatStorageReport(r *StorageReport) string {
+	var b strings.Builder
+	for _, obj := range r.OrphanObjects {
+		fmt.Fprintf(&b, "orphan   %s (%d bytes, %s)\n", obj.Key, obj.Size, obj.Reason)
+	}
+	for _, key := range r.UnknownObjects {
+		fmt.Fprintf(&b, "unknown  %s\n", key)
+	}
+	for _, obj := range r.MissingObjects {
+		fmt.Fprintf(&b, "missing  %s (%s %d)\n", obj.Key, obj.Entity, obj.EntityID)
+	}
+	for _, dir := range r.StaleTempDirs {
+		fmt.Fprintf(&b, "stale    %s\n", dir)
+	}
+	b.WriteString(r.Summary())
+	b.WriteString("\n")
+	return b.String()
+}
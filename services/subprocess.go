@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/models"
+	"os/exec"
+	"time"
+)
+
+// ResourceUsage 是子进程（含其已结束的子进程）的资源使用情况
+type ResourceUsage struct {
+	PeakRSSBytes int64         // 峰值常驻内存
+	CPUTime      time.Duration // 用户态与内核态CPU时间之和
+}
+
+// Add 合并另一阶段的资源使用：峰值内存取较大值，CPU时间累加。
+func (u *ResourceUsage) Add(other ResourceUsage) {
+	if other.PeakRSSBytes > u.PeakRSSBytes {
+		u.PeakRSSBytes = other.PeakRSSBytes
+	}
+	u.CPUTime += other.CPUTime
+}
+
+// ProcessLimits 是子进程的资源限制，0表示不限制
+type ProcessLimits struct {
+	// 内存上限与可使用的CPU核数，仅在Linux上配置了cgroup时生效
+	MemoryBytes int64
+	CPUs        float64
+}
+
+// TrainerLimits 返回配置的训练器子进程资源限制。
+func TrainerLimits() ProcessLimits {
+	return ProcessLimits{
+		MemoryBytes: int64(config.Conf.TrainerMemoryLimitMB) * 1024 * 1024,
+		CPUs:        config.Conf.TrainerCPULimit,
+	}
+}
+
+// stageTimeout 将以秒为单位的阶段超时配置转换为时长，0表示不限制。
+func stageTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// runProcess 在独立的进程组中运行cmd，并施加资源限制。
+// ctx取消或超过timeout（0表示不限制）时终止整个进程组，返回的错误包装 context.DeadlineExceeded 或 context.Canceled。
+// 无论成功与否都返回子进程的资源使用情况。
+func runProcess(ctx context.Context, cmd *exec.Cmd, timeout time.Duration, limits ProcessLimits) (ResourceUsage, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	sandbox, err := prepareProcess(cmd, limits)
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	defer sandbox.cleanup()
+
+	// 进程组被终止后，仍持有输出管道的孙进程最多再等待这么久
+	cmd.WaitDelay = 10 * time.Second
+	if err := cmd.Start(); err != nil {
+		return ResourceUsage{}, fmt.Errorf("fail to start %s: %w", cmd.Path, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		sandbox.kill(cmd.Process)
+		<-done
+		err = fmt.Errorf("%s terminated: %w", cmd.Path, ctx.Err())
+	}
+	return sandbox.usage(cmd.ProcessState), err
+}
+
+// SetWorkUsage 在作品上记录最近一次训练的资源使用。
+func SetWorkUsage(workID uint, usage ResourceUsage) {
+	if err := config.Conf.DB.Model(&models.Work{}).Where("id = ?", workID).Updates(map[string]interface{}{
+		"peak_rss_bytes":   usage.PeakRSSBytes,
+		"cpu_time_seconds": usage.CPUTime.Seconds(),
+	}).Error; err != nil {
+		log.Printf("work %d: fail to record resource usage: %v", workID, err)
+	}
+}
+
+// jobTimeout 返回任务整体的超时时间，0表示不限制。
+func jobTimeout() time.Duration {
+	return stageTimeout(config.Conf.JobTimeoutSeconds)
+}
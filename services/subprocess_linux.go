@@ -0,0 +1,134 @@
+//go:build linux
+
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"myapp/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// processSandbox 为子进程创建独立的进程组，并通过cgroup v2施加资源限制
+type processSandbox struct {
+	limits    ProcessLimits
+	cgroupDir string
+	cgroupFD  *os.File
+}
+
+// noCgroupWarning 保证未配置cgroup时只提示一次资源限制未生效
+var noCgroupWarning sync.Once
+
+// prepareProcess 在启动前配置子进程：独立进程组，以及配置了cgroup根目录时创建专属cgroup。
+func prepareProcess(cmd *exec.Cmd, limits ProcessLimits) (*processSandbox, error) {
+	sb := &processSandbox{limits: limits}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	root := config.Conf.TrainerCgroupRoot
+	if root == "" || (limits.MemoryBytes <= 0 && limits.CPUs <= 0) {
+		if root == "" && (limits.MemoryBytes > 0 || limits.CPUs > 0) {
+			noCgroupWarning.Do(func() {
+				log.Printf("TRAINER_CGROUP_ROOT is not set, trainer memory and CPU limits are not enforced")
+			})
+		}
+		return sb, nil
+	}
+	dir, err := os.MkdirTemp(root, "job-")
+	if err != nil {
+		return nil, fmt.Errorf("fail to create cgroup: %w", err)
+	}
+	sb.cgroupDir = dir
+	if limits.MemoryBytes > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(limits.MemoryBytes, 10)); err != nil {
+			sb.cleanup()
+			return nil, err
+		}
+		// 不使用swap，超出内存上限时直接由OOM killer终止
+		writeCgroupFile(dir, "memory.swap.max", "0")
+	}
+	if limits.CPUs > 0 {
+		const period = 100000
+		quota := int64(limits.CPUs * period)
+		if err := writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", quota, period)); err != nil {
+			sb.cleanup()
+			return nil, err
+		}
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		sb.cleanup()
+		return nil, fmt.Errorf("fail to open cgroup: %w", err)
+	}
+	sb.cgroupFD = fd
+	// 子进程在创建时即加入cgroup，不存在逃逸的时间窗口
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	return sb, nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("fail to set cgroup %s: %w", name, err)
+	}
+	return nil
+}
+
+// kill 终止子进程所在的整个进程组。
+func (sb *processSandbox) kill(process *os.Process) {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil {
+		process.Kill()
+	}
+}
+
+// usage 返回子进程的资源使用情况：使用cgroup时读取cgroup的统计（含所有子孙进程），
+// 否则读取等待子进程时得到的rusage。
+func (sb *processSandbox) usage(state *os.ProcessState) ResourceUsage {
+	var usage ResourceUsage
+	if state != nil {
+		if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+			usage.PeakRSSBytes = rusage.Maxrss * 1024 // Linux上单位为KB
+		}
+		usage.CPUTime = state.UserTime() + state.SystemTime()
+	}
+	if sb.cgroupDir == "" {
+		return usage
+	}
+	if data, err := os.ReadFile(filepath.Join(sb.cgroupDir, "memory.peak")); err == nil {
+		if peak, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			usage.PeakRSSBytes = peak
+		}
+	}
+	if file, err := os.Open(filepath.Join(sb.cgroupDir, "cpu.stat")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				if usec, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+					usage.CPUTime = time.Duration(usec) * time.Microsecond
+				}
+			}
+		}
+		file.Close()
+	}
+	return usage
+}
+
+// cleanup 删除为子进程创建的cgroup。
+func (sb *processSandbox) cleanup() {
+	if sb.cgroupFD != nil {
+		sb.cgroupFD.Close()
+	}
+	if sb.cgroupDir != "" {
+		if err := os.Remove(sb.cgroupDir); err != nil {
+			log.Printf("fail to remove cgroup %s: %v", sb.cgroupDir, err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package services
+
+import (
+	"os"
+	"os/exec"
+)
+
+// processSandbox 在非Linux平台上不施加资源限制，只负责终止子进程与统计CPU时间
+type processSandbox struct{}
+
+func prepareProcess(cmd *exec.Cmd, limits ProcessLimits) (*processSandbox, error) {
+	return &processSandbox{}, nil
+}
+
+func (sb *processSandbox) kill(process *os.Process) {
+	process.Kill()
+}
+
+func (sb *processSandbox) usage(state *os.ProcessState) ResourceUsage {
+	if state == nil {
+		return ResourceUsage{}
+	}
+	return ResourceUsage{CPUTime: state.UserTime() + state.SystemTime()}
+}
+
+func (sb *processSandbox) cleanup() {}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"myapp/config"
+	"myapp/models"
+	"strconv"
+)
+
+// DefaultTrainingPreset 是未指定预设时使用的训练参数预设
+const DefaultTrainingPreset = "standard"
+
+// TrainParams 是训练器支持的超参数，由服务端校验后再拼接为命令行参数
+type TrainParams struct {
+	Iterations      int     `json:"iterations"`
+	DensifyInterval int     `json:"densify_interval"` // 致密化间隔（迭代次数）
+	DensifyUntil    int     `json:"densify_until"`    // 在该迭代之后停止致密化
+	SHDegree        int     `json:"sh_degree"`        // 球谐函数阶数
+	ResolutionScale int     `json:"resolution_scale"` // 训练图像的缩小倍数
+	PositionLR      float64 `json:"position_lr"`      // 位置学习率初始值
+	WhiteBackground bool    `json:"white_background"`
+	TestSplit       bool    `json:"test_split"` // 划分测试集用于评估
+}
+
+// 训练参数的取值范围
+const (
+	MinTrainIterations = 1000
+	MaxTrainIterations = 100000
+	MaxSHDegree        = 3
+	MinPositionLR      = 1e-7
+	MaxPositionLR      = 1e-2
+)
+
+// validResolutionScales 是训练器支持的图像缩小倍数
+var validResolutionScales = map[int]bool{1: true, 2: true, 4: true, 8: true}
+
+// Validate 检查训练参数是否在允许的范围内。
+func (p TrainParams) Validate() error {
+	if p.Iterations < MinTrainIterations || p.Iterations > MaxTrainIterations {
+		return fmt.Errorf("iterations must be between %d and %d", MinTrainIterations, MaxTrainIterations)
+	}
+	if p.DensifyInterval < 1 || p.DensifyInterval > p.Iterations {
+		return fmt.Errorf("densify_interval must be between 1 and iterations")
+	}
+	if p.DensifyUntil < 0 || p.DensifyUntil > p.Iterations {
+		return fmt.Errorf("densify_until must be between 0 and iterations")
+	}
+	if p.SHDegree < 0 || p.SHDegree > MaxSHDegree {
+		return fmt.Errorf("sh_degree must be between 0 and %d", MaxSHDegree)
+	}
+	if !validResolutionScales[p.ResolutionScale] {
+		return errors.New("resolution_scale must be one of 1, 2, 4, 8")
+	}
+	if p.PositionLR < MinPositionLR || p.PositionLR > MaxPositionLR {
+		return fmt.Errorf("position_lr must be between %g and %g", MinPositionLR, MaxPositionLR)
+	}
+	return nil
+}
+
+// WithIterations 返回迭代次数改为n的参数，致密化间隔与停止致密化的迭代不超过n。
+// 用于只给出迭代次数的旧接口请求，使较少的迭代次数仍能通过校验。
+func (p TrainParams) WithIterations(n int) TrainParams {
+	p.Iterations = n
+	p.DensifyInterval = min(p.DensifyInterval, n)
+	p.DensifyUntil = min(p.DensifyUntil, n)
+	return p
+}
+
+// Args 返回训练参数对应的训练器命令行参数，调用前须先通过 Validate。
+func (p TrainParams) Args() []string {
+	args := []string{
+		"--iterations", strconv.Itoa(p.Iterations),
+		"--densification_interval", strconv.Itoa(p.DensifyInterval),
+		"--densify_until_iter", strconv.Itoa(p.DensifyUntil),
+		"--sh_degree", strconv.Itoa(p.SHDegree),
+		"--resolution", strconv.Itoa(p.ResolutionScale),
+		"--position_lr_init", strconv.FormatFloat(p.PositionLR, 'g', -1, 64),
+	}
+	if p.WhiteBackground {
+		args = append(args, "--white_background")
+	}
+	if p.TestSplit {
+		args = append(args, "--eval")
+	}
+	return args
+}
+
+// JSON 返回训练参数的JSON表示，用于保存到作品记录。
+func (p TrainParams) JSON() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// builtinTrainingPresets 是启动时写入数据库的内置预设
+var builtinTrainingPresets = []struct {
+	Name        string
+	Description string
+	Params      TrainParams
+}{
+	{"preview", "快速预览：较少迭代、低分辨率", TrainParams{
+		Iterations: 7000, DensifyInterval: 100, DensifyUntil: 3500,
+		SHDegree: 1, ResolutionScale: 4, PositionLR: 0.00016,
+	}},
+	{"standard", "标准质量", TrainParams{
+		Iterations: 30000, DensifyInterval: 100, DensifyUntil: 15000,
+		SHDegree: 3, ResolutionScale: 2, PositionLR: 0.00016,
+	}},
+	{"high-quality", "高质量：全分辨率、更长的致密化阶段", TrainParams{
+		Iterations: 60000, DensifyInterval: 100, DensifyUntil: 30000,
+		SHDegree: 3, ResolutionScale: 1, PositionLR: 0.00016,
+	}},
+}
+
+// SeedTrainingPresets 将内置预设写入数据库，已存在的同名预设保持不变，
+// 以便管理员在数据库中调整预设参数。
+func SeedTrainingPresets() error {
+	for _, builtin := range builtinTrainingPresets {
+		preset := models.TrainingPreset{
+			Name:        builtin.Name,
+			Description: builtin.Description,
+			Params:      builtin.Params.JSON(),
+		}
+		if err := config.Conf.DB.Where("name = ?", builtin.Name).FirstOrCreate(&preset).Error; err != nil {
+			return fmt.Errorf("fail to seed training preset %s: %w", builtin.Name, err)
+		}
+	}
+	return nil
+}
+
+// FindTrainingPreset 按名称查找训练参数预设并解析其参数。
+func FindTrainingPreset(name string) (*models.TrainingPreset, TrainParams, error) {
+	var preset models.TrainingPreset
+	if err := config.Conf.DB.Where("name = ?", name).First(&preset).Error; err != nil {
+		return nil, TrainParams{}, fmt.Errorf("training preset %q not found: %w", name, err)
+	}
+	var params TrainParams
+	if err := json.Unmarshal([]byte(preset.Params), &params); err != nil {
+		return nil, TrainParams{}, fmt.Errorf("fail to parse training preset %q: %w", name, err)
+	}
+	return &preset, params, nil
+}
+
+// ResolveTrainParams 以预设为基础叠加请求中给出的参数，并校验最终结果。
+// 参数:
+//
+//	presetName - 预设名称，为空时使用 DefaultTrainingPreset。
+//	overrides - 请求中的参数对象（JSON），只覆盖其中出现的字段，可为空。
+//
+// 返回值:
+//
+//	最终生效的训练参数，以及预设不存在、参数格式错误或校验失败时的错误。
+func ResolveTrainParams(presetName string, overrides json.RawMessage) (TrainParams, error) {
+	if presetName == "" {
+		presetName = DefaultTrainingPreset
+	}
+	_, params, err := FindTrainingPreset(presetName)
+	if err != nil {
+		return TrainParams{}, err
+	}
+	return OverlayTrainParams(params, overrides)
+}
+
+// OverlayTrainParams 将请求中给出的参数叠加到base之上并校验，未出现的字段保留base中的值。
+// 参数对象中出现未知字段时返回错误。
+func OverlayTrainParams(base TrainParams, overrides json.RawMessage) (TrainParams, error) {
+	params := base
+	if len(overrides) > 0 && string(overrides) != "null" {
+		decoder := json.NewDecoder(bytes.NewReader(overrides))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&params); err != nil {
+			return TrainParams{}, fmt.Errorf("invalid training params: %w", err)
+		}
+	}
+	if err := params.Validate(); err != nil {
+		return TrainParams{}, err
+	}
+	return params, nil
+}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobTypeTrain 是由视频训练作品的任务类型
+const JobTypeTrain = "train"
+
+// 训练模式
+const (
+	TrainModeTrain    = "train"    // 首次训练
+	TrainModeRetrain  = "retrain"  // 使用原视频和新参数从头训练
+	TrainModeContinue = "continue" // 从上一版本保存的检查点继续训练
+)
+
+// TrainPayload 是训练任务的参数
+type TrainPayload struct {
+	Mode    string      `json:"mode"`
+	VideoID uint        `json:"video_id"`
+	Preset  string      `json:"preset,omitempty"`
+	Params  TrainParams `json:"params"`
+	// 继续训练时使用的检查点所在版本
+	CheckpointRevisionID uint `json:"checkpoint_revision_id,omitempty"`
+}
+
+// 远程训练节点上传的产物
+const (
+	TrainArtifactSplat      = "work.splat"
+	TrainArtifactCameras    = "cameras.json"
+	TrainArtifactCheckpoint = "checkpoint.pth"
+)
+
+func init() {
+	RegisterJobHandler(JobTypeTrain, trainWork)
+	RegisterRemoteJobSpec(JobTypeTrain, RemoteJobSpec{
+		Artifacts: []string{TrainArtifactSplat, TrainArtifactCameras, TrainArtifactCheckpoint},
+		Inputs:    trainInputs,
+		Start: func(job *models.Job) error {
+			if err := UpdateWorkStatus(job.WorkID, "processing", "", jobStartTime(job)); err != nil {
+				return err
+			}
+			PublishWorkEvent(job.WorkID, EventWorkStarted)
+			return nil
+		},
+		Complete: completeRemoteTraining,
+		Fail: func(job *models.Job, err error, retry bool) {
+			setTrainingFailed(job, jobStartTime(job), "process failed", err, retry)
+		},
+	})
+}
+
+// trainInputs 返回训练任务的输入文件：原视频，以及继续训练时使用的检查点。
+func trainInputs(job *models.Job) (map[string]string, error) {
+	var payload TrainPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return nil, err
+	}
+	var video models.Video
+	if err := config.Conf.DB.Unscoped().First(&video, payload.VideoID).Error; err != nil {
+		return nil, fmt.Errorf("fail to find video: %w", err)
+	}
+	if video.ExpiredAt != nil {
+		return nil, NewJobError(FailureInvalidInput, "download", ErrVideoExpired)
+	}
+	inputs := map[string]string{
+		"video.mp4": VideoObjectKey(&video),
+	}
+	if payload.Mode == TrainModeContinue {
+		var checkpointRev models.WorkRevision
+		if err := config.Conf.DB.First(&checkpointRev, payload.CheckpointRevisionID).Error; err != nil {
+			return nil, fmt.Errorf("fail to find checkpoint revision: %w", err)
+		}
+		if checkpointRev.CheckpointKey == "" {
+			return nil, fmt.Errorf("revision %d has no checkpoint", checkpointRev.Number)
+		}
+		inputs["checkpoint.pth"] = checkpointRev.CheckpointKey
+	}
+	return inputs, nil
+}
+
+func jobStartTime(job *models.Job) time.Time {
+	if job.StartedAt != nil {
+		return *job.StartedAt
+	}
+	return time.Now()
+}
+
+// trainWork 在本机执行完整的训练流程：取回视频、训练、转换为.splat，
+// 再由 finishTraining 完成后处理并保存为作品的新版本。
+func trainWork(ctx context.Context, job *models.Job) (string, error) {
+	var payload TrainPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return "", err
+	}
+
+	startTime := time.Now()
+	// 训练器的输出写入任务日志，重试时接在之前尝试的日志之后
+	jobLog := OpenJobLog(job)
+	defer jobLog.Close()
+	jobLog.Printf("attempt %d/%d started at %s", job.Attempts, job.MaxAttempts, startTime.Format(time.RFC3339))
+	fail := func(status string, err error) (string, error) {
+		jobLog.Printf("attempt %d failed: %v", job.Attempts, err)
+		setTrainingFailed(job, startTime, status, err, JobWillRetry(job, err))
+		return "", err
+	}
+
+	if err := UpdateWorkStatus(job.WorkID, "processing", "", startTime); err != nil {
+		return "", err
+	}
+	PublishWorkEvent(job.WorkID, EventWorkStarted)
+
+	inputs, err := trainInputs(job)
+	if err != nil {
+		return fail("process failed", NewJobError("", "prepare", err))
+	}
+	videoPath, err := database.RetrieveFromBucket(inputs["video.mp4"])
+	if err != nil {
+		return fail("process failed", NewJobError("", "download", fmt.Errorf("fail to find video:%w", err)))
+	}
+	defer os.RemoveAll(filepath.Dir(videoPath))
+
+	processor, err := NewVideoProcessor(payload.Params)
+	if err != nil {
+		return fail("process failed", NewJobError(FailureInvalidInput, "prepare", err))
+	}
+	processor.Log = jobLog
+	// 训练结束时（无论成功与否）记录子进程的资源使用
+	defer func() {
+		SetWorkUsage(job.WorkID, processor.Usage)
+	}()
+	defer func() {
+		if processor.OutputFolder == "" {
+			return
+		}
+		if err := os.RemoveAll(filepath.Dir(processor.OutputFolder)); err != nil {
+			log.Printf("fail to remove temp file:%v", err)
+		}
+	}()
+
+	// 继续训练：取回上一版本保存的检查点
+	if key, ok := inputs["checkpoint.pth"]; ok {
+		checkpointPath, err := database.RetrieveFromBucket(key)
+		if err != nil {
+			return fail("process failed", NewJobError("", "download", fmt.Errorf("fail to find checkpoint: %w", err)))
+		}
+		defer os.RemoveAll(filepath.Dir(checkpointPath))
+		processor.StartCheckpoint = checkpointPath
+	}
+
+	if err := processor.ProcessVideo(ctx, videoPath, processor); err != nil {
+		return fail("process failed", err)
+	}
+
+	if ctx.Err() != nil {
+		return fail("splat failed", NewJobError("", "train", ctx.Err()))
+	}
+
+	if err := processor.Splat(ctx); err != nil {
+		return fail("splat failed", err)
+	}
+
+	checkpointPath := processor.CheckpointPath()
+	if _, err := os.Stat(checkpointPath); err != nil {
+		checkpointPath = ""
+	}
+	return finishTraining(job, payload, startTime, processor.SplatPath(), processor.CamerasPath(), checkpointPath)
+}
+
+// completeRemoteTraining 处理远程训练节点上传的产物。
+func completeRemoteTraining(ctx context.Context, job *models.Job, artifacts map[string]string) (string, error) {
+	var payload TrainPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return "", err
+	}
+	startTime := jobStartTime(job)
+	splatPath, ok := artifacts[TrainArtifactSplat]
+	if !ok {
+		err := NewJobError(FailureUnknown, "splat", fmt.Errorf("worker did not upload %s", TrainArtifactSplat))
+		setTrainingFailed(job, startTime, "splat failed", err, JobWillRetry(job, err))
+		return "", err
+	}
+	return finishTraining(job, payload, startTime, splatPath, artifacts[TrainArtifactCameras], artifacts[TrainArtifactCheckpoint])
+}
+
+// finishTraining 对训练输出进行后处理，并将结果保存为作品的新版本，最后创建预览任务。
+// 参数:
+//
+//	splatPath - 训练输出的.splat文件，后处理会原地修改该文件。
+//	camerasPath - 训练器输出的 cameras.json，为空表示没有。
+//	checkpointPath - 最终迭代的检查点，为空表示没有。
+func finishTraining(job *models.Job, payload TrainPayload, startTime time.Time, splatPath, camerasPath, checkpointPath string) (string, error) {
+	// 后处理：摆正上方向、居中并统一尺度
+	var transform *SceneTransform
+	if config.Conf.NormalizeScene {
+		var err error
+		transform, err = NormalizeSplatFile(splatPath, camerasPath, DefaultNormalizeOptions())
+		if err != nil {
+			// 归一化失败不影响作品，保留训练器原始坐标
+			log.Printf("work %d: fail to normalize scene: %v", job.WorkID, err)
+			transform = nil
+		}
+	}
+
+	// 后处理：将训练相机姿态与场景对齐，供查看器加载
+	alignedCamerasPath := ""
+	if camerasPath != "" {
+		alignedCamerasPath = filepath.Join(filepath.Dir(splatPath), "cameras_aligned.json")
+		if err := PrepareCamerasFile(camerasPath, alignedCamerasPath, transform); err != nil {
+			log.Printf("work %d: fail to prepare cameras: %v", job.WorkID, err)
+			alignedCamerasPath = ""
+		}
+	}
+
+	// 保存为作品的新版本
+	params, _ := json.Marshal(payload)
+	revision := models.WorkRevision{
+		WorkID:        job.WorkID,
+		Operation:     payload.Mode,
+		SourceVideoID: payload.VideoID,
+		Iterations:    strconv.Itoa(payload.Params.Iterations),
+		Params:        string(params),
+	}
+	if transform != nil {
+		revision.Transform = transform.JSON()
+	}
+	err := RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		if err := SaveRevision(tx, stx, &revision, splatPath, alignedCamerasPath); err != nil {
+			return err
+		}
+		// 保存最终检查点，供之后继续训练
+		if checkpointPath == "" {
+			return nil
+		}
+		revision.CheckpointKey = RevisionKey(job.WorkID, revision.Number, TrainArtifactCheckpoint)
+		if err := stx.Store(EntityRevision, 0, revision.CheckpointKey, checkpointPath); err != nil {
+			return err
+		}
+		revision.SizeBytes += fileSize(checkpointPath)
+		return tx.Model(&revision).Updates(map[string]interface{}{
+			"checkpoint_key": revision.CheckpointKey,
+			"size_bytes":     revision.SizeBytes,
+		}).Error
+	})
+	if err != nil {
+		jobErr := NewJobError("", "upload", err)
+		setTrainingFailed(job, startTime, "upload failed", jobErr, JobWillRetry(job, jobErr))
+		return "", jobErr
+	}
+
+	if err := UpdateWorkStatus(job.WorkID, "completed", "", startTime); err != nil {
+		return "", err
+	}
+	PublishWorkEvent(job.WorkID, EventWorkCompleted)
+
+	// 后处理：生成环绕预览视频
+	StartWorkPreview(job.UserID, job.WorkID)
+
+	return fmt.Sprintf(`{"revision":%d}`, revision.Number), nil
+}
+
+// setTrainingFailed 记录训练失败后作品的状态：将自动重试时为 "retrying"，否则为status。
+func setTrainingFailed(job *models.Job, startTime time.Time, status string, err error, retry bool) {
+	errorLog := fmt.Sprintf("[%s] %v", ClassifyError(err), err)
+	if retry {
+		status = "retrying"
+		errorLog = fmt.Sprintf("attempt %d/%d failed, retrying: %s", job.Attempts, job.MaxAttempts, errorLog)
+	}
+	if updateErr := UpdateWorkStatus(job.WorkID, status, errorLog, startTime); updateErr != nil {
+		log.Printf("work %d: %v", job.WorkID, updateErr)
+		return
+	}
+	if !retry {
+		PublishWorkEvent(job.WorkID, EventWorkFailed)
+	}
+}
+
+// UpdateWorkStatus 更新工作的状态。
+// 参数:
+//
+//	workID - 工作的唯一标识符。
+//	status - 工作的新状态。
+//	errorLog - 工作执行过程中遇到的错误日志。
+//	startTime - 工作开始的时间。
+//
+// 返回值:
+//
+//	如果更新过程中发生错误，则返回错误。
+func UpdateWorkStatus(workID uint, status, errorLog string, startTime time.Time) error {
+	// 使用事务来更新工作状态，确保数据的一致性。
+	err := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		// 初始化要更新的字段。
+		updates := map[string]interface{}{"status": status}
+
+		// 当工作完成或失败时，更新处理时间和文件路径。
+		// 消耗的GPU时间由 recordWorkAttempt 按每次尝试累计。
+		if status == "completed" || status == "splat failed" {
+			updates["process_time"] = int(time.Since(startTime).Seconds())
+		}
+
+		// 如果有错误日志，则更新错误日志字段。
+		if errorLog != "" {
+			updates["error_log"] = errorLog
+		}
+
+		// 执行更新操作。
+		return tx.Model(&models.Work{}).Where("id = ?", workID).Updates(updates).Error
+	})
+
+	// 如果更新过程中发生错误，返回详细的错误信息。
+	if err != nil {
+		return fmt.Errorf("status update error: %v", err)
+	}
+
+	// 更新成功，返回nil表示没有发生错误。
+	return nil
+}
+
+// StartTraining 将作品置为排队状态并创建训练任务。
+// 作品记录本次选择的预设与训练参数，重新训练时以此为默认值。
+// 配额检查与创建任务在同一事务中并锁定用户记录，同一用户的并发请求不会同时通过检查；
+// 超过配额时返回包装了 ErrQuotaExceeded 的错误。
+func StartTraining(work *models.Work, payload TrainPayload) (*models.Job, error) {
+	if err := payload.Params.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid training params: %w", err)
+	}
+	var job *models.Job
+	err := config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, work.UserID).Error; err != nil {
+			return fmt.Errorf("fail to lock user: %w", err)
+		}
+		if err := checkTrainingQuota(tx, work.UserID); err != nil {
+			return err
+		}
+		if err := tx.Model(work).Updates(map[string]interface{}{
+			"status":          "queued",
+			"error_log":       "",
+			"training_preset": payload.Preset,
+			"train_params":    payload.Params.JSON(),
+		}).Error; err != nil {
+			return fmt.Errorf("status update error: %v", err)
+		}
+		var err error
+		job, err = enqueueJob(tx, JobTypeTrain, work.UserID, work.ID, payload, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	PublishWorkEvent(work.ID, EventWorkQueued)
+	return job, nil
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// TurntableOptions 描述环绕预览视频的渲染参数。
+type TurntableOptions struct {
+	FFmpegPath string  // ffmpeg可执行文件路径
+	Format     string  // 输出格式：mp4 或 webp
+	Frames     int     // 环绕一周的帧数
+	FrameRate  int     // 输出帧率
+	Width      int     // 画面宽度
+	Height     int     // 画面高度
+	FOV        float64 // 垂直视场角（角度）
+	Elevation  float64 // 相机俯仰角（角度）
+	Up         [3]float64
+}
+
+// DefaultTurntableOptions 返回默认的环绕预览参数。
+// 3DGS输出沿用COLMAP坐标系，默认以-Y作为场景的上方向。
+func DefaultTurntableOptions() TurntableOptions {
+	return TurntableOptions{
+		FFmpegPath: "ffmpeg",
+		Format:     "mp4",
+		Frames:     72,
+		FrameRate:  24,
+		Width:      512,
+		Height:     512,
+		FOV:        50,
+		Elevation:  20,
+		Up:         [3]float64{0, -1, 0},
+	}
+}
+
+// projectedSplat 是投影到屏幕空间后的高斯点。
+type projectedSplat struct {
+	x, y       float64 // 屏幕坐标
+	depth      float64
+	conicA     float64 // 二维协方差逆矩阵 [A B; B C]
+	conicB     float64
+	conicC     float64
+	radius     int
+	r, g, b, a float64
+}
+
+// orbitCamera 是环绕预览中单帧使用的针孔相机（OpenCV约定：x向右、y向下、z向前）。
+type orbitCamera struct {
+	eye      vec3
+	rotation mat3 // 世界坐标到相机坐标的旋转，行依次为 right/down/forward
+	fx, fy   float64
+	cx, cy   float64
+	width    int
+	height   int
+}
+
+func newOrbitCamera(eye, target, up vec3, opts TurntableOptions) orbitCamera {
+	forward := target.sub(eye).normalize()
+	right := up.scale(-1).cross(forward).normalize()
+	down := forward.cross(right)
+	focal := float64(opts.Height) / (2 * math.Tan(opts.FOV*math.Pi/360))
+	return orbitCamera{
+		eye:      eye,
+		rotation: mat3{right, down, forward},
+		fx:       focal,
+		fy:       focal,
+		cx:       float64(opts.Width) / 2,
+		cy:       float64(opts.Height) / 2,
+		width:    opts.Width,
+		height:   opts.Height,
+	}
+}
+
+// project 将高斯点投影到屏幕空间，返回false表示该点不可见。
+func (cam *orbitCamera) project(s *Splat, out *projectedSplat) bool {
+	p := cam.rotation.mulVec(vec3{float64(s.Position[0]), float64(s.Position[1]), float64(s.Position[2])}.sub(cam.eye))
+	if p[2] < 0.05 {
+		return false
+	}
+
+	// 三维协方差 Σ = R S S^T R^T，并变换到相机坐标系
+	m := quatToMat3(s.Quaternion())
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] *= float64(s.Scale[j])
+		}
+	}
+	cov := cam.rotation.mul(m.mul(m.transpose())).mul(cam.rotation.transpose())
+
+	// 局部仿射近似的雅可比矩阵
+	invZ := 1 / p[2]
+	j00, j02 := cam.fx*invZ, -cam.fx*p[0]*invZ*invZ
+	j11, j12 := cam.fy*invZ, -cam.fy*p[1]*invZ*invZ
+
+	a := j00*j00*cov[0][0] + 2*j00*j02*cov[0][2] + j02*j02*cov[2][2] + 0.3
+	b := j00*j11*cov[0][1] + j00*j12*cov[0][2] + j02*j11*cov[2][1] + j02*j12*cov[2][2]
+	c := j11*j11*cov[1][1] + 2*j11*j12*cov[1][2] + j12*j12*cov[2][2] + 0.3
+
+	det := a*c - b*b
+	if det <= 0 {
+		return false
+	}
+	mid := (a + c) / 2
+	lambda := mid + math.Sqrt(math.Max(0.1, mid*mid-det))
+	radius := int(math.Ceil(3 * math.Sqrt(lambda)))
+
+	x := cam.fx*p[0]*invZ + cam.cx
+	y := cam.fy*p[1]*invZ + cam.cy
+	if x+float64(radius) < 0 || x-float64(radius) >= float64(cam.width) ||
+		y+float64(radius) < 0 || y-float64(radius) >= float64(cam.height) {
+		return false
+	}
+
+	*out = projectedSplat{
+		x: x, y: y, depth: p[2],
+		conicA: c / det, conicB: -b / det, conicC: a / det,
+		radius: radius,
+		r:      float64(s.Color[0]) / 255,
+		g:      float64(s.Color[1]) / 255,
+		b:      float64(s.Color[2]) / 255,
+		a:      float64(s.Color[3]) / 255,
+	}
+	return true
+}
+
+// renderFrame 使用CPU按从远到近的顺序对高斯点做alpha混合，渲染出一帧图像。
+// 图像按行切分为若干条带并行光栅化，各条带内部保持严格的混合顺序。
+func renderFrame(splats []Splat, cam orbitCamera) *image.RGBA {
+	projected := make([]projectedSplat, 0, len(splats))
+	for i := range splats {
+		var ps projectedSplat
+		if cam.project(&splats[i], &ps) {
+			projected = append(projected, ps)
+		}
+	}
+	sort.Slice(projected, func(i, j int) bool { return projected[i].depth > projected[j].depth })
+
+	buf := make([]float64, cam.width*cam.height*3)
+	bands := runtime.NumCPU()
+	rowsPerBand := (cam.height + bands - 1) / bands
+	var wg sync.WaitGroup
+	for band := 0; band < bands; band++ {
+		y0, y1 := band*rowsPerBand, (band+1)*rowsPerBand
+		if y1 > cam.height {
+			y1 = cam.height
+		}
+		if y0 >= y1 {
+			break
+		}
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			for i := range projected {
+				rasterizeSplat(&projected[i], buf, cam.width, y0, y1)
+			}
+		}(y0, y1)
+	}
+	wg.Wait()
+
+	img := image.NewRGBA(image.Rect(0, 0, cam.width, cam.height))
+	for y := 0; y < cam.height; y++ {
+		for x := 0; x < cam.width; x++ {
+			i := (y*cam.width + x) * 3
+			img.SetRGBA(x, y, color.RGBA{
+				R: toByte(buf[i]), G: toByte(buf[i+1]), B: toByte(buf[i+2]), A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// rasterizeSplat 将单个高斯点混合到[y0,y1)行范围内的像素上。
+func rasterizeSplat(ps *projectedSplat, buf []float64, width, y0, y1 int) {
+	minY := int(math.Max(float64(y0), math.Floor(ps.y-float64(ps.radius))))
+	maxY := int(math.Min(float64(y1-1), math.Ceil(ps.y+float64(ps.radius))))
+	if minY > maxY {
+		return
+	}
+	minX := int(math.Max(0, math.Floor(ps.x-float64(ps.radius))))
+	maxX := int(math.Min(float64(width-1), math.Ceil(ps.x+float64(ps.radius))))
+
+	for py := minY; py <= maxY; py++ {
+		dy := float64(py) + 0.5 - ps.y
+		for px := minX; px <= maxX; px++ {
+			dx := float64(px) + 0.5 - ps.x
+			power := -0.5*(ps.conicA*dx*dx+ps.conicC*dy*dy) - ps.conicB*dx*dy
+			if power > 0 {
+				continue
+			}
+			alpha := math.Min(0.99, ps.a*math.Exp(power))
+			if alpha < 1.0/255 {
+				continue
+			}
+			i := (py*width + px) * 3
+			buf[i] = alpha*ps.r + (1-alpha)*buf[i]
+			buf[i+1] = alpha*ps.g + (1-alpha)*buf[i+1]
+			buf[i+2] = alpha*ps.b + (1-alpha)*buf[i+2]
+		}
+	}
+}
+
+func toByte(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v*255))))
+}
+
+// RenderTurntable 围绕场景包围盒渲染一周环绕动画，并通过ffmpeg编码为MP4或动态WebP。
+// 参数:
+//
+//	ctx - 取消时停止渲染并终止ffmpeg。
+//	splatPath - 输入的.splat文件路径。
+//	outputPath - 输出视频路径，扩展名应与opts.Format一致。
+//	opts - 渲染参数。
+//
+// 返回值:
+//
+//	如果渲染或编码过程中出现错误，则返回错误。
+func RenderTurntable(ctx context.Context, splatPath, outputPath string, opts TurntableOptions) error {
+	if opts.Format != "mp4" && opts.Format != "webp" {
+		return fmt.Errorf("unsupported preview format: %s", opts.Format)
+	}
+	if opts.Frames <= 0 || opts.Width <= 0 || opts.Height <= 0 {
+		return fmt.Errorf("invalid turntable options: %d frames %dx%d", opts.Frames, opts.Width, opts.Height)
+	}
+
+	splats, err := ReadSplatFile(splatPath)
+	if err != nil {
+		return err
+	}
+	if len(splats) == 0 {
+		return fmt.Errorf("splat file is empty")
+	}
+
+	// 使用2%~98%分位数的包围盒，避免漂浮点把相机推得过远
+	bmin, bmax := SplatBounds(splats, 0.02, 0.98)
+	center := vec3(bmin).add(vec3(bmax)).scale(0.5)
+	radius := vec3(bmax).sub(vec3(bmin)).length() / 2
+	if radius == 0 {
+		radius = 1
+	}
+	distance := radius * 1.1 / math.Tan(opts.FOV*math.Pi/360)
+
+	// 构造垂直于上方向的轨道平面基向量
+	up := vec3(opts.Up).normalize()
+	axis := vec3{1, 0, 0}
+	if math.Abs(up.dot(axis)) > 0.9 {
+		axis = vec3{0, 0, 1}
+	}
+	e1 := axis.sub(up.scale(up.dot(axis))).normalize()
+	e2 := up.cross(e1)
+	elevation := opts.Elevation * math.Pi / 180
+
+	frameDir, err := os.MkdirTemp(filepath.Dir(outputPath), "frames-")
+	if err != nil {
+		return fmt.Errorf("fail to create frame directory: %w", err)
+	}
+	defer os.RemoveAll(frameDir)
+
+	for i := 0; i < opts.Frames; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		theta := 2 * math.Pi * float64(i) / float64(opts.Frames)
+		dir := e1.scale(math.Cos(theta) * math.Cos(elevation)).
+			add(e2.scale(math.Sin(theta) * math.Cos(elevation))).
+			add(up.scale(math.Sin(elevation)))
+		cam := newOrbitCamera(center.add(dir.scale(distance)), center, up, opts)
+
+		if err := writePNG(filepath.Join(frameDir, fmt.Sprintf("frame_%04d.png", i)), renderFrame(splats, cam)); err != nil {
+			return err
+		}
+	}
+
+	return encodeFrames(ctx, frameDir, outputPath, opts)
+}
+
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("fail to create frame: %w", err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		return fmt.Errorf("fail to encode frame: %w", err)
+	}
+	return nil
+}
+
+// encodeFrames 调用ffmpeg将帧序列编码为目标格式，ctx取消时终止ffmpeg。
+func encodeFrames(ctx context.Context, frameDir, outputPath string, opts TurntableOptions) error {
+	args := []string{
+		"-y", "-loglevel", "error",
+		"-framerate", fmt.Sprintf("%d", opts.FrameRate),
+		"-i", filepath.Join(frameDir, "frame_%04d.png"),
+	}
+	switch opts.Format {
+	case "mp4":
+		args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart")
+	case "webp":
+		args = append(args, "-c:v", "libwebp", "-loop", "0", "-q:v", "75")
+	}
+	args = append(args, outputPath)
+
+	cmd := exec.Command(opts.FFmpegPath, args...)
+	output := newTailBuffer(logExcerptLimit)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if _, err := runProcess(ctx, cmd, 0, ProcessLimits{}); err != nil {
+		return fmt.Errorf("fail to encode preview: %w: %s", err, output)
+	}
+	return nil
+}
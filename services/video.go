@@ -0,0 +1,34 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"myapp/config"
+	"myapp/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrVideoExpired 表示视频文件已按保留规则删除，不能再用于训练
+var ErrVideoExpired = errors.New("source video expired")
+
+// VideoObjectKey 返回视频文件在对象存储中的键。
+func VideoObjectKey(video *models.Video) string {
+	if video.ObjectKey != "" {
+		return video.ObjectKey
+	}
+	return fmt.Sprintf("video%d.mp4", video.ID)
+}
+
+// FindDuplicateVideo 查找用户已上传的内容相同、且视频文件未过期的视频，没有时返回nil。
+func FindDuplicateVideo(userID uint, sha256 string) (*models.Video, error) {
+	var video models.Video
+	err := config.Conf.DB.Where("user_id = ? AND sha256 = ? AND expired_at IS NULL", userID, sha256).Order("id").First(&video).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to find duplicate video: %w", err)
+	}
+	return &video, nil
+}
@@ -3,11 +3,15 @@ package services
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"myapp/config"
 	"myapp/utils"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -19,12 +23,25 @@ type VideoProcessor struct {
 	PythonInterpreter string
 	FPS               int
 	Iterations        string
+	// Params 是经过校验的训练参数，Iterations 为其迭代次数的字符串形式，用于定位输出文件
+	Params TrainParams
+	// StartCheckpoint 不为空时从该检查点继续训练
+	StartCheckpoint string
+	// Limits 是训练器子进程的资源限制，Usage 累计各阶段子进程的资源使用
+	Limits ProcessLimits
+	Usage  ResourceUsage
+	// Log 不为空时接收训练器子进程的标准输出与错误输出，为空时输出到服务器的标准错误
+	Log io.Writer
 }
 
 // NewVideoProcessor 创建并初始化一个新的VideoProcessor实例。
-// 该函数无需参数。
+// 参数 params 为经过校验的训练参数。
 // 返回值是一个指向VideoProcessor实例的指针，以及一个错误值（如果有）。
-func NewVideoProcessor(iterations string) (*VideoProcessor, error) {
+func NewVideoProcessor(params TrainParams) (*VideoProcessor, error) {
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid training params: %w", err)
+	}
+
 	// 获取项目根目录的路径。
 	projectRoot := utils.GetProjectRoot()
 
@@ -43,7 +60,9 @@ func NewVideoProcessor(iterations string) (*VideoProcessor, error) {
 		OutputFolder:      "",
 		PythonInterpreter: "C:/Users/Administrator/anaconda3/envs/gaussian_splatting/python.exe",
 		FPS:               2,
-		Iterations:        iterations,
+		Iterations:        strconv.Itoa(params.Iterations),
+		Params:            params,
+		Limits:            TrainerLimits(),
 	}, nil
 }
 
@@ -51,19 +70,20 @@ func NewVideoProcessor(iterations string) (*VideoProcessor, error) {
 // 该方法负责执行视频的训练过程，并将输出结果保存在安全的输出目录中。
 // 参数:
 //
+//	ctx: 取消或超时时终止训练器。
 //	video: 指向待处理的视频模型的指针。
 //	processor: 指向当前视频处理器的指针，用于记录和操作处理过程的输出目录。
 //
 // 返回值:
 //
 //	如果处理过程中发生错误，则返回错误。
-func (vp *VideoProcessor) ProcessVideo(videoPath string, processor *VideoProcessor) error {
+func (vp *VideoProcessor) ProcessVideo(ctx context.Context, videoPath string, processor *VideoProcessor) error {
 	// 生成唯一输出目录
 	outputFolder := utils.SafeJoin(vp.BaseOutputFolder, "output")
 
 	// 执行训练命令
 	var err error
-	processor.OutputFolder, err = vp.runTraining(videoPath, outputFolder)
+	processor.OutputFolder, err = vp.runTraining(ctx, videoPath, outputFolder)
 	if err != nil {
 		return err
 	}
@@ -72,26 +92,42 @@ func (vp *VideoProcessor) ProcessVideo(videoPath string, processor *VideoProcess
 }
 
 // runTraining 运行训练程序以处理指定路径的视频。
-// 该函数接受视频路径和输出文件夹路径作为参数。
+// 该函数接受视频路径和输出文件夹路径作为参数，训练器在独立的进程组中运行，
+// 超过 TRAIN_TIMEOUT 或ctx取消时整个进程组被终止。
 // 它返回训练过程中生成的输出路径或者错误信息（如果有）。
-func (vp *VideoProcessor) runTraining(videoPath, outputFolder string) (string, error) {
+func (vp *VideoProcessor) runTraining(ctx context.Context, videoPath, outputFolder string) (string, error) {
 	// 构建运行训练脚本的命令。
-	cmd := exec.Command(vp.PythonInterpreter, vp.TrainerPath, "--video", videoPath, "--iterations", vp.Iterations)
+	// 在最终迭代保存检查点，以便之后继续训练。
+	args := []string{vp.TrainerPath, "--video", videoPath}
+	args = append(args, vp.Params.Args()...)
+	args = append(args, "--checkpoint_iterations", vp.Iterations)
+	if vp.StartCheckpoint != "" {
+		args = append(args, "--start_checkpoint", vp.StartCheckpoint)
+	}
+	cmd := exec.Command(vp.PythonInterpreter, args...)
 
 	// 添加PYTHONPATH环境变量以确保脚本能找到所需的模块。
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PYTHONPATH=%s", vp.PythonPath))
 
-	// 准备缓冲区以存储命令的输出。
+	// 准备缓冲区以存储命令的输出，并保留错误输出的末尾用于判断失败原因。
 	var stdoutBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = os.Stderr
+	stderrTail := newTailBuffer(logExcerptLimit)
+	cmd.Stdout = io.MultiWriter(&stdoutBuf, vp.logWriter(io.Discard))
+	cmd.Stderr = io.MultiWriter(vp.logWriter(os.Stderr), stderrTail)
 
 	// 打印训练开始的信息。
 	fmt.Printf("Starting training process for video: %s\n", videoPath)
 
 	// 执行命令并处理错误（如果有）。
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("training failed: %w", err)
+	usage, err := runProcess(ctx, cmd, stageTimeout(config.Conf.TrainTimeoutSeconds), vp.Limits)
+	vp.Usage.Add(usage)
+	if err != nil {
+		return "", &JobError{
+			Class: classifyTrainerFailure(err, stderrTail.String()),
+			Stage: "train",
+			Err:   fmt.Errorf("training failed: %w", err),
+			Log:   stderrTail.String(),
+		}
 	}
 
 	// 解析输出路径
@@ -118,13 +154,13 @@ func (vp *VideoProcessor) runTraining(videoPath, outputFolder string) (string, e
 //
 // 返回值:
 //
-//	如果转换过程中遇到任何错误，则返回错误。
-func (vp *VideoProcessor) Splat() error {
+//	如果转换过程中遇到任何错误（包括超过 SPLAT_TIMEOUT），则返回错误。
+func (vp *VideoProcessor) Splat(ctx context.Context) error {
 	// 尝试在指定的工作路径中找到.ply文件。
 	plyPath, err := findPlyPath(vp.Iterations, vp.OutputFolder)
 	if err != nil {
 		// 如果找不到.ply文件，返回错误。
-		return fmt.Errorf("fail to find .ply file: %v", err)
+		return NewJobError(FailureUnknown, "splat", fmt.Errorf("fail to find .ply file: %v", err))
 	}
 
 	// 构建执行Python转换脚本的命令。
@@ -133,16 +169,49 @@ func (vp *VideoProcessor) Splat() error {
 	// 添加环境变量以确保Python脚本可以找到所需的库。
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PYTHONPATH=%s", "3DGS/gaussian-splatting/envs/gaussian_splatting"))
 
-	// 执行命令并检查是否有错误发生。
-	if err := cmd.Run(); err != nil {
+	// 执行命令并检查是否有错误发生，保留输出末尾用于判断失败原因。
+	outputTail := newTailBuffer(logExcerptLimit)
+	cmd.Stdout = io.MultiWriter(outputTail, vp.logWriter(io.Discard))
+	cmd.Stderr = cmd.Stdout
+	usage, err := runProcess(ctx, cmd, stageTimeout(config.Conf.SplatTimeoutSeconds), vp.Limits)
+	vp.Usage.Add(usage)
+	if err != nil {
 		// 如果执行命令时出错，返回错误。
-		return fmt.Errorf("fail to convert to splat file:%w", err)
+		return &JobError{
+			Class: classifyTrainerFailure(err, outputTail.String()),
+			Stage: "splat",
+			Err:   fmt.Errorf("fail to convert to splat file:%w", err),
+			Log:   outputTail.String(),
+		}
 	}
 
 	// 如果一切顺利，返回nil表示没有发生错误。
 	return nil
 }
 
+// logWriter 返回子进程输出的去向：设置了 Log 时写入任务日志，否则写入fallback。
+func (vp *VideoProcessor) logWriter(fallback io.Writer) io.Writer {
+	if vp.Log != nil {
+		return vp.Log
+	}
+	return fallback
+}
+
+// SplatPath 返回Splat转换后.splat文件的路径。
+func (vp *VideoProcessor) SplatPath() string {
+	return vp.OutputFolder + "/point_cloud/iteration_" + vp.Iterations + "/point_cloud.splat"
+}
+
+// CamerasPath 返回训练器输出的相机姿态文件路径。
+func (vp *VideoProcessor) CamerasPath() string {
+	return vp.OutputFolder + "/cameras.json"
+}
+
+// CheckpointPath 返回训练最终迭代保存的检查点路径。
+func (vp *VideoProcessor) CheckpointPath() string {
+	return vp.OutputFolder + "/chkpnt" + vp.Iterations + ".pth"
+}
+
 func findPlyPath(iterations, filePath string) (string, error) {
 	if _, err := os.Stat(filePath + "/point_cloud/iteration_" + iterations + "/point_cloud.ply"); err != nil {
 		return "", fmt.Errorf("fail to find .ply file: %v", err)
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"myapp/config"
+	"myapp/models"
+	"myapp/testutil"
+	"testing"
+)
+
+func TestFindDuplicateVideo(t *testing.T) {
+	bucket := testutil.Setup(t)
+	const sum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	first := models.Video{Title: "courtyard", UserID: 1, SHA256: sum, ObjectKey: "video1.mp4"}
+	if err := config.Conf.DB.Create(&first).Error; err != nil {
+		t.Fatal(err)
+	}
+	bucket.Put("video1.mp4", []byte("capture"))
+
+	if found, err := FindDuplicateVideo(1, sum); err != nil || found == nil || found.ID != first.ID {
+		t.Fatalf("duplicate of the same user: %+v, %v", found, err)
+	}
+	if found, err := FindDuplicateVideo(2, sum); err != nil || found != nil {
+		t.Errorf("video of another user reported as duplicate: %+v, %v", found, err)
+	}
+	// 同一用户的相同内容只能保存一次：同时上传时后提交的一方由唯一索引拒绝
+	if err := config.Conf.DB.Create(&models.Video{Title: "again", UserID: 1, SHA256: sum}).Error; err == nil {
+		t.Error("saved a second video with the same content for the same user")
+	}
+	if err := config.Conf.DB.Create(&models.Video{Title: "courtyard", UserID: 2, SHA256: sum}).Error; err != nil {
+		t.Errorf("same content from another user: %v", err)
+	}
+
+	// 过期的视频文件已删除，不能再作为重复上传的结果；相同的内容可以重新上传
+	if err := expireVideo(&first); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := FindDuplicateVideo(1, sum); err != nil || found != nil {
+		t.Errorf("expired video reported as duplicate: %+v, %v", found, err)
+	}
+	if _, ok := bucket.Get("video1.mp4"); ok {
+		t.Error("video file kept after expiry")
+	}
+	if err := config.Conf.DB.Create(&models.Video{Title: "courtyard", UserID: 1, SHA256: sum}).Error; err != nil {
+		t.Errorf("re-upload after expiry: %v", err)
+	}
+}
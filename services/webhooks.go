@@ -0,0 +1,325 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"myapp/config"
+	"myapp/models"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// webhook投递状态
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+const (
+	// webhookBatchSize 是每次轮询领取的投递数
+	webhookBatchSize = 50
+	// webhookConcurrency 是同时进行的投递数
+	webhookConcurrency = 8
+	// webhookResponseLimit 是投递记录中保存的响应内容的最大长度
+	webhookResponseLimit = 1024
+)
+
+// ErrInvalidWebhook 表示webhook地址或订阅的事件无效
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+func init() {
+	SubscribeEvents(enqueueWebhookDeliveries)
+}
+
+// CreateWebhook 为用户注册webhook并生成签名密钥，events为空表示订阅全部事件。
+func CreateWebhook(userID uint, rawURL string, events []string) (*models.Webhook, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http(s) url", ErrInvalidWebhook)
+	}
+	for _, event := range events {
+		if !validEventType(event) {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("fail to generate webhook secret: %w", err)
+	}
+	webhook := models.Webhook{
+		UserID: userID,
+		URL:    target.String(),
+		Secret: hex.EncodeToString(secret),
+		Events: strings.Join(events, ","),
+		Active: true,
+	}
+	if err := config.Conf.DB.Create(&webhook).Error; err != nil {
+		return nil, fmt.Errorf("fail to create webhook: %w", err)
+	}
+	return &webhook, nil
+}
+
+func validEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookSubscribes 判断webhook是否订阅了某一类型的事件。
+func webhookSubscribes(webhook *models.Webhook, eventType string) bool {
+	if webhook.Events == "" {
+		return true
+	}
+	for _, t := range strings.Split(webhook.Events, ",") {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueWebhookDeliveries 为订阅了该事件的每个webhook创建一条待投递记录，由后台协程发送。
+func enqueueWebhookDeliveries(event Event) {
+	var webhooks []models.Webhook
+	if err := config.Conf.DB.Where("user_id = ? AND active = ?", event.UserID, true).Find(&webhooks).Error; err != nil {
+		log.Printf("fail to find webhooks for event %s: %v", event.ID, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("fail to encode event %s: %v", event.ID, err)
+		return
+	}
+	now := time.Now()
+	for i := range webhooks {
+		if !webhookSubscribes(&webhooks[i], event.Type) {
+			continue
+		}
+		delivery := models.WebhookDelivery{
+			WebhookID:     webhooks[i].ID,
+			EventID:       event.ID,
+			Event:         event.Type,
+			Payload:       string(payload),
+			Status:        WebhookPending,
+			NextAttemptAt: &now,
+		}
+		if err := config.Conf.DB.Create(&delivery).Error; err != nil {
+			log.Printf("webhook %d: fail to enqueue delivery: %v", webhooks[i].ID, err)
+		}
+	}
+}
+
+// SignWebhookPayload 返回投递的签名：以webhook密钥对 "<timestamp>.<body>" 计算的HMAC-SHA256（十六进制）。
+// 接收方应以同样的方式计算并比较，同时检查时间戳以防止重放。
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RedeliverWebhook 将一次投递重新放入队列，重新计算尝试次数。
+func RedeliverWebhook(delivery *models.WebhookDelivery) error {
+	now := time.Now()
+	return config.Conf.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":          WebhookPending,
+		"attempts":        0,
+		"next_attempt_at": &now,
+		"error":           "",
+	}).Error
+}
+
+// StartWebhookDispatcher 启动后台协程，按pollInterval轮询并投递到期的webhook事件。
+// 投递与后台任务分开执行，不会被长时间的训练任务阻塞。
+func StartWebhookDispatcher(ctx context.Context, pollInterval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dispatchWebhooks(ctx)
+			}
+		}
+	}()
+}
+
+// dispatchWebhooks 领取到期的投递并发送，等待本批全部完成后返回。
+func dispatchWebhooks(ctx context.Context) {
+	var deliveries []models.WebhookDelivery
+	if err := config.Conf.DB.Where("status = ? AND next_attempt_at <= ?", WebhookPending, time.Now()).
+		Order("id").Limit(webhookBatchSize).Find(&deliveries).Error; err != nil {
+		log.Printf("fail to find webhook deliveries: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, webhookConcurrency)
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		if !claimWebhookDelivery(delivery) {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			deliverWebhook(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+}
+
+// claimWebhookDelivery 以乐观更新领取一次投递：增加尝试次数，并将下一次尝试时间推迟到本次请求超时之后，
+// 进程在投递中退出时由之后的轮询重新领取。
+func claimWebhookDelivery(delivery *models.WebhookDelivery) bool {
+	retryAt := time.Now().Add(2 * webhookTimeout())
+	result := config.Conf.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND attempts = ?", delivery.ID, WebhookPending, delivery.Attempts).
+		Updates(map[string]interface{}{
+			"attempts":        delivery.Attempts + 1,
+			"next_attempt_at": retryAt,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	delivery.Attempts++
+	return true
+}
+
+func webhookTimeout() time.Duration {
+	if config.Conf.WebhookTimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(config.Conf.WebhookTimeoutSeconds) * time.Second
+}
+
+// deliverWebhook 发送一次投递并记录结果，失败时按指数退避安排重试，超过最多尝试次数后标记为失败。
+func deliverWebhook(ctx context.Context, delivery *models.WebhookDelivery) {
+	var webhook models.Webhook
+	if err := config.Conf.DB.First(&webhook, delivery.WebhookID).Error; err != nil || !webhook.Active {
+		finishWebhookDelivery(delivery, WebhookFailed, 0, "", "webhook removed or disabled", nil)
+		return
+	}
+
+	status, body, err := postWebhook(ctx, &webhook, delivery)
+	if err == nil {
+		now := time.Now()
+		finishWebhookDelivery(delivery, WebhookDelivered, status, body, "", &now)
+		return
+	}
+	if delivery.Attempts >= max(config.Conf.WebhookMaxAttempts, 1) {
+		finishWebhookDelivery(delivery, WebhookFailed, status, body, err.Error(), nil)
+		return
+	}
+	retryAt := time.Now().Add(jobRetryDelay(delivery.Attempts))
+	if updateErr := config.Conf.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":          WebhookPending,
+		"next_attempt_at": retryAt,
+		"response_status": status,
+		"response_body":   body,
+		"error":           err.Error(),
+	}).Error; updateErr != nil {
+		log.Printf("webhook delivery %d: %v", delivery.ID, updateErr)
+	}
+}
+
+func finishWebhookDelivery(delivery *models.WebhookDelivery, status string, responseStatus int, body, errMsg string, deliveredAt *time.Time) {
+	if err := config.Conf.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":          status,
+		"next_attempt_at": nil,
+		"response_status": responseStatus,
+		"response_body":   body,
+		"error":           errMsg,
+		"delivered_at":    deliveredAt,
+	}).Error; err != nil {
+		log.Printf("webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// postWebhook 发送签名的事件，返回响应状态码与响应内容摘录，状态码不是2xx时返回错误。
+func postWebhook(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout())
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "myapp-webhook/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.EventID)
+	req.Header.Set("X-Webhook-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, SignWebhookPayload(webhook.Secret, timestamp, body)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(excerpt), fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, string(excerpt), nil
+}
+
+// webhookClient 不跟随重定向，并拒绝连接内网地址（WEBHOOK_ALLOW_PRIVATE=true 时允许）
+var webhookClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: checkWebhookAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// checkWebhookAddress 在建立连接前检查解析后的地址，防止通过webhook访问服务器所在的内网。
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	if config.Conf.WebhookAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
@@ -0,0 +1,480 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// JobTypeWorkImport 是从压缩包批量导入作品的任务类型
+const JobTypeWorkImport = "work_import"
+
+// ImportArchiveFormats 列出支持的导入压缩包格式
+var ImportArchiveFormats = map[string]bool{".zip": true, ".tar": true}
+
+// importManifestName 是压缩包中可选的清单文件名
+const importManifestName = "manifest.json"
+
+// maxImportEntries 是一个压缩包最多导入的作品数
+const maxImportEntries = 1000
+
+// maxImportManifestSize 是 manifest.json 的大小上限
+const maxImportManifestSize = 1024 * 1024
+
+// 清单中各字段的上限，长度按字符计
+const (
+	maxImportNameLength   = 255
+	maxImportTags         = 20
+	maxImportTagLength    = 50
+	maxImportPresets      = 50
+	maxImportPresetLength = 100
+)
+
+// WorkImportPayload 是导入任务的参数，Archive 为暂存在对象存储中的压缩包的键
+type WorkImportPayload struct {
+	Archive string `json:"archive"`
+}
+
+// ImportManifest 是压缩包中 manifest.json 的格式，未列出的.splat/.ply文件以文件名作为作品名导入
+type ImportManifest struct {
+	Works []ImportManifestEntry `json:"works"`
+}
+
+// ImportManifestEntry 描述压缩包中的一个作品，File 为压缩包内的路径
+type ImportManifestEntry struct {
+	File          string               `json:"file"`
+	Name          string               `json:"name"`
+	Tags          []string             `json:"tags"`
+	CameraPresets []ImportCameraPreset `json:"camera_presets"`
+}
+
+// ImportCameraPreset 是导入作品时一并创建的相机预设
+type ImportCameraPreset struct {
+	Name       string    `json:"name"`
+	ViewMatrix []float64 `json:"view_matrix"`
+}
+
+// WorkImportResult 是导入任务的结果，逐项报告创建的作品或失败原因
+type WorkImportResult struct {
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Entries   []WorkImportStatus `json:"entries"`
+}
+
+// WorkImportStatus 是压缩包中一项的导入结果
+type WorkImportStatus struct {
+	File   string `json:"file"`
+	WorkID uint   `json:"work_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func init() {
+	RegisterJobHandler(JobTypeWorkImport, importWorks)
+}
+
+// EnqueueWorkImport 将上传的压缩包暂存到对象存储并创建导入任务，压缩包在导入完成后删除。
+func EnqueueWorkImport(userID uint, archivePath string) (*models.Job, error) {
+	ext := strings.ToLower(filepath.Ext(archivePath))
+	if !ImportArchiveFormats[ext] {
+		return nil, fmt.Errorf("unsupported archive format: %s", ext)
+	}
+	var job *models.Job
+	err := RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		var err error
+		if job, err = enqueueJob(tx, JobTypeWorkImport, userID, 0, WorkImportPayload{}, nil); err != nil {
+			return err
+		}
+		// 压缩包的键包含任务ID，创建任务后再写入参数；事务提交前任务不会被执行
+		key := jobArtifactKey(job.ID, "import"+ext)
+		payload, _ := json.Marshal(WorkImportPayload{Archive: key})
+		job.Payload = string(payload)
+		if err := tx.Model(job).Update("payload", job.Payload).Error; err != nil {
+			return err
+		}
+		return stx.Store(EntityJob, job.ID, key, archivePath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// importEntry 是从压缩包中解出的一个作品文件
+type importEntry struct {
+	name string // 压缩包内的路径
+	path string // 解出的本地文件
+}
+
+// importWorks 取回压缩包，解出其中的.splat/.ply文件与清单，逐项创建作品。
+// 单项失败不影响其他项，失败原因记录在结果中；压缩包无法读取时任务失败。
+// 每一项的结果与创建作品在同一事务中写入任务结果，任务重试或进程重启后跳过已处理的项，不会重复创建作品。
+func importWorks(ctx context.Context, job *models.Job) (string, error) {
+	var payload WorkImportPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return "", NewJobError(FailureInvalidInput, "", err)
+	}
+	result := WorkImportResult{Entries: []WorkImportStatus{}}
+	if job.Result != "" {
+		if err := json.Unmarshal([]byte(job.Result), &result); err != nil {
+			return "", NewJobError(FailureInvalidInput, "", fmt.Errorf("invalid import progress: %w", err))
+		}
+	}
+	done := make(map[string]bool, len(result.Entries))
+	for _, status := range result.Entries {
+		done[status.File] = true
+	}
+
+	archivePath, err := database.RetrieveFromBucket(payload.Archive)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(filepath.Dir(archivePath))
+
+	dir, err := os.MkdirTemp("", "import-")
+	if err != nil {
+		return "", fmt.Errorf("fail to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	entries, manifest, err := extractImportArchive(archivePath, dir)
+	if err != nil {
+		return "", NewJobError(FailureInvalidInput, "extract", err)
+	}
+
+	// 清单中列出的文件按清单导入，其余文件以文件名作为作品名；
+	// 压缩包中重复的路径及清单中重复列出的文件不导入，在结果中报告
+	files := make(map[string]importEntry, len(entries))
+	duplicated := map[string]bool{}
+	for _, entry := range entries {
+		if _, ok := files[entry.name]; ok {
+			duplicated[entry.name] = true
+		}
+		files[entry.name] = entry
+	}
+	var items []ImportManifestEntry
+	listed := map[string]bool{}
+	for _, item := range manifest.Works {
+		item.File = path.Clean(strings.TrimPrefix(item.File, "/"))
+		if listed[item.File] {
+			duplicated[item.File] = true
+			continue
+		}
+		items = append(items, item)
+		listed[item.File] = true
+	}
+	for _, entry := range entries {
+		if !listed[entry.name] {
+			items = append(items, ImportManifestEntry{File: entry.name})
+			listed[entry.name] = true
+		}
+	}
+	if len(items) > maxImportEntries {
+		return "", NewJobError(FailureInvalidInput, "extract",
+			fmt.Errorf("archive contains %d works, at most %d allowed", len(items), maxImportEntries))
+	}
+
+	for _, item := range items {
+		if done[item.File] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		status := WorkImportStatus{File: item.File}
+		entry, ok := files[item.File]
+		validateErr := validateImportEntry(item)
+		switch {
+		case duplicated[item.File]:
+			status.Error = "duplicate file path in archive"
+		case !ok:
+			status.Error = "file not found in archive"
+		case validateErr != nil:
+			status.Error = validateErr.Error()
+		default:
+			// 导入成功时结果与作品在同一事务中写入
+			var progress WorkImportResult
+			err := importWork(job.UserID, item, entry.path, func(tx *gorm.DB, workID uint) error {
+				status.WorkID = workID
+				progress = withImportStatus(result, status)
+				return saveImportProgress(tx, job.ID, progress)
+			})
+			if err == nil {
+				result = progress
+				continue
+			}
+			status.WorkID = 0
+			status.Error = err.Error()
+		}
+		progress := withImportStatus(result, status)
+		if err := saveImportProgress(config.Conf.DB, job.ID, progress); err != nil {
+			return "", err
+		}
+		result = progress
+	}
+
+	err = RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		return stx.Remove(tx, EntityJob, job.ID, payload.Archive)
+	})
+	if err != nil {
+		log.Printf("job %d: %v", job.ID, err)
+	}
+	data, _ := json.Marshal(result)
+	return string(data), nil
+}
+
+// withImportStatus 返回在result之后追加一项结果的副本
+func withImportStatus(result WorkImportResult, status WorkImportStatus) WorkImportResult {
+	entries := make([]WorkImportStatus, len(result.Entries), len(result.Entries)+1)
+	copy(entries, result.Entries)
+	result.Entries = append(entries, status)
+	if status.Error != "" {
+		result.Failed++
+	} else {
+		result.Succeeded++
+	}
+	return result
+}
+
+// saveImportProgress 将已处理的项写入导入任务的结果，任务重试时据此跳过
+func saveImportProgress(db *gorm.DB, jobID uint, result WorkImportResult) error {
+	data, _ := json.Marshal(result)
+	if err := db.Model(&models.Job{}).Where("id = ?", jobID).Update("result", string(data)).Error; err != nil {
+		return fmt.Errorf("fail to save import progress: %w", err)
+	}
+	return nil
+}
+
+// extractImportArchive 将压缩包中的.splat/.ply文件与 manifest.json 解出到dir，
+// 其他文件及目录被忽略。解出的文件以序号命名，不使用压缩包中的路径。
+// 单个文件或解出的文件总计超过 IMPORT_MAX_ENTRY_MB、IMPORT_MAX_TOTAL_MB 时返回错误，
+// 大小以实际解出的字节数为准，不信任压缩包中记录的大小。
+func extractImportArchive(archivePath, dir string) ([]importEntry, *ImportManifest, error) {
+	var entries []importEntry
+	manifest := &ImportManifest{}
+	entryLimit := importSizeLimit(config.Conf.ImportMaxEntryMB)
+	remaining := importSizeLimit(config.Conf.ImportMaxTotalMB)
+	extract := func(name string, size int64, r io.Reader) error {
+		name = path.Clean(strings.TrimPrefix(name, "/"))
+		ext := strings.ToLower(path.Ext(name))
+		if name == importManifestName {
+			if size > maxImportManifestSize {
+				return fmt.Errorf("%s is larger than %d bytes", importManifestName, maxImportManifestSize)
+			}
+			if err := json.NewDecoder(io.LimitReader(r, maxImportManifestSize)).Decode(manifest); err != nil {
+				return fmt.Errorf("invalid %s: %w", importManifestName, err)
+			}
+			return nil
+		}
+		if ext != ".splat" && ext != ".ply" {
+			return nil
+		}
+		if len(entries) >= maxImportEntries {
+			return fmt.Errorf("archive contains more than %d works", maxImportEntries)
+		}
+		limit := min(entryLimit, remaining)
+		if size > limit {
+			return errImportTooLarge(name, size > entryLimit)
+		}
+		localPath := filepath.Join(dir, fmt.Sprintf("%d%s", len(entries), ext))
+		file, err := os.Create(localPath)
+		if err != nil {
+			return fmt.Errorf("fail to extract %s: %w", name, err)
+		}
+		defer file.Close()
+		// 多读一个字节以发现实际大小超过上限的文件
+		n, err := io.Copy(file, io.LimitReader(r, limit+1))
+		if err != nil {
+			return fmt.Errorf("fail to extract %s: %w", name, err)
+		}
+		if n > limit {
+			return errImportTooLarge(name, n > entryLimit)
+		}
+		remaining -= n
+		entries = append(entries, importEntry{name: name, path: localPath})
+		return nil
+	}
+
+	var err error
+	switch strings.ToLower(filepath.Ext(archivePath)) {
+	case ".zip":
+		err = extractZip(archivePath, extract)
+	case ".tar":
+		err = extractTar(archivePath, extract)
+	default:
+		err = fmt.Errorf("unsupported archive format: %s", filepath.Ext(archivePath))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries, manifest, nil
+}
+
+// importSizeLimit 将以MB为单位的上限转换为字节数，不大于0时不限制
+func importSizeLimit(mb int) int64 {
+	if mb <= 0 {
+		return math.MaxInt64 - 1
+	}
+	return int64(mb) * 1024 * 1024
+}
+
+// errImportTooLarge 返回压缩包中的文件超过单个文件上限（entry为true）或总计上限的错误
+func errImportTooLarge(name string, entry bool) error {
+	if entry {
+		return fmt.Errorf("%s is larger than %d MB", name, config.Conf.ImportMaxEntryMB)
+	}
+	return fmt.Errorf("archive is larger than %d MB when extracted", config.Conf.ImportMaxTotalMB)
+}
+
+func extractZip(archivePath string, extract func(name string, size int64, r io.Reader) error) error {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("fail to open zip: %w", err)
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("fail to read %s: %w", f.Name, err)
+		}
+		size := int64(min(f.UncompressedSize64, math.MaxInt64))
+		err = extract(f.Name, size, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(archivePath string, extract func(name string, size int64, r io.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("fail to open tar: %w", err)
+	}
+	defer file.Close()
+	archive := tar.NewReader(file)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("fail to read tar: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := extract(header.Name, header.Size, archive); err != nil {
+			return err
+		}
+	}
+}
+
+// validateImportEntry 检查清单中一项的作品名、标签与相机预设，在导入之前报告不合法的字段
+func validateImportEntry(item ImportManifestEntry) error {
+	if utf8.RuneCountInString(item.Name) > maxImportNameLength {
+		return fmt.Errorf("name is longer than %d characters", maxImportNameLength)
+	}
+	if len(item.Tags) > maxImportTags {
+		return fmt.Errorf("at most %d tags allowed", maxImportTags)
+	}
+	for _, tag := range item.Tags {
+		if strings.TrimSpace(tag) == "" || utf8.RuneCountInString(tag) > maxImportTagLength {
+			return fmt.Errorf("tag %q must be non-empty and at most %d characters", tag, maxImportTagLength)
+		}
+	}
+	if len(item.CameraPresets) > maxImportPresets {
+		return fmt.Errorf("at most %d camera presets allowed", maxImportPresets)
+	}
+	for _, preset := range item.CameraPresets {
+		if preset.Name == "" || utf8.RuneCountInString(preset.Name) > maxImportPresetLength {
+			return fmt.Errorf("camera preset %q must have a name of at most %d characters", preset.Name, maxImportPresetLength)
+		}
+		if len(preset.ViewMatrix) != 16 {
+			return fmt.Errorf("camera preset %q must have 16 view_matrix elements", preset.Name)
+		}
+	}
+	return nil
+}
+
+// importWork 由一个.splat或.ply文件创建作品及其相机预设，.ply文件先转换为.splat。
+// 清单中的字段须已通过 validateImportEntry 检查；record 在创建作品的事务中调用，用于记录导入进度。
+func importWork(userID uint, item ImportManifestEntry, filePath string, record func(tx *gorm.DB, workID uint) error) error {
+	splatPath := filePath
+	if filepath.Ext(filePath) == ".ply" {
+		splats, err := ReadGaussianPLY(filePath)
+		if err != nil {
+			return err
+		}
+		splatPath = strings.TrimSuffix(filePath, ".ply") + ".splat"
+		if err := WriteSplatFile(splatPath, splats); err != nil {
+			return err
+		}
+	} else if _, err := ReadSplatFile(splatPath); err != nil {
+		return err
+	}
+	size := fileSize(splatPath)
+	if size == 0 {
+		return errors.New("work is empty")
+	}
+	if err := CheckStorageQuota(userID, size); err != nil {
+		return err
+	}
+
+	name := item.Name
+	if name == "" {
+		name = strings.TrimSuffix(path.Base(item.File), path.Ext(item.File))
+	}
+	work := models.Work{
+		UserID:   userID,
+		Status:   "completed",
+		WorkName: name,
+	}
+	if len(item.Tags) > 0 {
+		tags, _ := json.Marshal(item.Tags)
+		work.Tags = string(tags)
+	}
+	err := RunStorageTx(func(tx *gorm.DB, stx *StorageTx) error {
+		if err := tx.Create(&work).Error; err != nil {
+			return err
+		}
+		revision := models.WorkRevision{
+			WorkID:    work.ID,
+			Operation: "import",
+		}
+		if err := SaveRevision(tx, stx, &revision, splatPath, ""); err != nil {
+			return err
+		}
+		for _, preset := range item.CameraPresets {
+			viewMatrix, _ := json.Marshal(preset.ViewMatrix)
+			if err := tx.Create(&models.CameraPreset{WorkID: work.ID, Name: preset.Name, ViewMatrix: string(viewMatrix)}).Error; err != nil {
+				return err
+			}
+		}
+		return record(tx, work.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("fail to import work: %w", err)
+	}
+
+	StartWorkPreview(userID, work.ID)
+	return nil
+}
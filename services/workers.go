@@ -0,0 +1,421 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"myapp/config"
+	"myapp/database"
+	"myapp/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RemoteJobSpec 描述一类可由远程训练节点执行的任务。
+// 节点租用任务后下载 Inputs 中的输入文件，执行完成后上传 Artifacts 中列出的产物，
+// 服务器在节点报告成功后调用 Complete 完成后处理。
+type RemoteJobSpec struct {
+	// Artifacts 是节点可以上传的产物文件名
+	Artifacts []string
+	// Inputs 返回任务的输入文件名及其对象键
+	Inputs func(job *models.Job) (map[string]string, error)
+	// Start 在任务被节点租用后调用，可为空
+	Start func(job *models.Job) error
+	// Complete 处理节点上传的产物（文件名到本地路径，未上传的产物不在其中），返回值保存为任务结果
+	Complete func(ctx context.Context, job *models.Job, artifacts map[string]string) (string, error)
+	// Fail 在任务于节点上失败（节点报告失败或租约到期）后调用，retry 表示任务将自动重试，可为空
+	Fail func(job *models.Job, err error, retry bool)
+}
+
+var remoteJobSpecs = map[string]RemoteJobSpec{}
+
+// JobTypeRemoteComplete 是在服务器上对远程节点上传的产物进行后处理的任务类型
+const JobTypeRemoteComplete = "remote_complete"
+
+// RemoteCompletePayload 是后处理任务的参数，JobID 为节点执行的原任务
+type RemoteCompletePayload struct {
+	JobID uint `json:"job_id"`
+}
+
+func init() {
+	RegisterJobHandler(JobTypeRemoteComplete, completeRemoteJob)
+}
+
+// RegisterRemoteJobSpec 注册某一类型任务的远程执行方式，应在init中调用。
+func RegisterRemoteJobSpec(jobType string, spec RemoteJobSpec) {
+	remoteJobSpecs[jobType] = spec
+}
+
+// ErrLeaseLost 表示节点已不再持有任务的租约（租约到期后被放回队列或被其他节点租用）
+var ErrLeaseLost = errors.New("job lease lost")
+
+// ErrInvalidWorkerSecret 表示节点注册时提供的密钥不正确
+var ErrInvalidWorkerSecret = errors.New("invalid worker secret")
+
+// RegisterWorker 使用注册密钥注册一个远程训练节点，返回节点记录及令牌。
+// 令牌只在注册时返回一次，数据库中仅保存其摘要。
+// 参数:
+//
+//	name - 节点名称。
+//	secret - 与服务器配置的 WORKER_SECRET 一致的注册密钥。
+//	jobTypes - 节点可执行的任务类型，须已注册远程执行方式。
+func RegisterWorker(name, secret string, jobTypes []string) (*models.Worker, string, error) {
+	if config.Conf.WorkerSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(secret), []byte(config.Conf.WorkerSecret)) != 1 {
+		return nil, "", ErrInvalidWorkerSecret
+	}
+	if len(jobTypes) == 0 {
+		return nil, "", fmt.Errorf("worker must support at least one job type")
+	}
+	for _, t := range jobTypes {
+		if _, ok := remoteJobSpecs[t]; !ok {
+			return nil, "", fmt.Errorf("job type %q cannot run on remote workers", t)
+		}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("fail to generate worker token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+	now := time.Now()
+	worker := models.Worker{
+		Name:       name,
+		TokenHash:  hashWorkerToken(token),
+		JobTypes:   strings.Join(jobTypes, ","),
+		LastSeenAt: &now,
+	}
+	if err := config.Conf.DB.Create(&worker).Error; err != nil {
+		return nil, "", fmt.Errorf("fail to register worker: %w", err)
+	}
+	return &worker, token, nil
+}
+
+// AuthenticateWorker 根据令牌查找节点，并记录节点最近一次活动时间。
+func AuthenticateWorker(token string) (*models.Worker, error) {
+	var worker models.Worker
+	if err := config.Conf.DB.Where("token_hash = ?", hashWorkerToken(token)).First(&worker).Error; err != nil {
+		return nil, fmt.Errorf("invalid worker token: %w", err)
+	}
+	now := time.Now()
+	config.Conf.DB.Model(&worker).Update("last_seen_at", now)
+	worker.LastSeenAt = &now
+	return &worker, nil
+}
+
+func hashWorkerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// workerLease 返回任务租约的时长。
+func workerLease() time.Duration {
+	seconds := config.Conf.WorkerLeaseSeconds
+	if seconds < 1 {
+		seconds = 60
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ActiveWorkerCount 返回最近一个租约周期内有活动的远程节点数量。
+func ActiveWorkerCount() int {
+	var count int64
+	config.Conf.DB.Model(&models.Worker{}).Where("last_seen_at > ?", time.Now().Add(-workerLease())).Count(&count)
+	return int(count)
+}
+
+// LeasedJob 是节点租用到的任务
+type LeasedJob struct {
+	Job            *models.Job
+	Inputs         []string // 可下载的输入文件名
+	Artifacts      []string // 可上传的产物文件名
+	LeaseExpiresAt time.Time
+}
+
+// LeaseJob 按调度规则为节点租用一个它可执行的任务，没有可执行任务时返回nil。
+func LeaseJob(worker *models.Worker) (*LeasedJob, error) {
+	var types []string
+	for _, t := range strings.Split(worker.JobTypes, ",") {
+		if _, ok := remoteJobSpecs[t]; ok {
+			types = append(types, t)
+		}
+	}
+
+	expires := time.Now().Add(workerLease())
+	job, err := claimNextJob(types, map[string]interface{}{
+		"worker_id":        worker.ID,
+		"lease_expires_at": expires,
+	})
+	if err != nil || job == nil {
+		return nil, err
+	}
+
+	spec := remoteJobSpecs[job.Type]
+	inputs, err := spec.Inputs(job)
+	if err == nil && spec.Start != nil {
+		err = spec.Start(job)
+	}
+	if err != nil {
+		failRemoteJob(job, NewJobError("", "prepare", err))
+		return nil, err
+	}
+
+	leased := &LeasedJob{Job: job, Artifacts: spec.Artifacts, LeaseExpiresAt: expires}
+	for name := range inputs {
+		leased.Inputs = append(leased.Inputs, name)
+	}
+	return leased, nil
+}
+
+// leasedJob 返回节点当前持有租约的任务，已报告成功、等待后处理的任务不再属于节点。
+func leasedJob(worker *models.Worker, jobID uint) (*models.Job, error) {
+	var job models.Job
+	err := config.Conf.DB.Where("id = ? AND status = ? AND worker_id = ? AND lease_expires_at IS NOT NULL", jobID, JobRunning, worker.ID).
+		First(&job).Error
+	if err != nil {
+		return nil, ErrLeaseLost
+	}
+	return &job, nil
+}
+
+// HeartbeatJob 延长节点对任务的租约，节点已失去租约时返回 ErrLeaseLost。
+func HeartbeatJob(worker *models.Worker, jobID uint) (time.Time, error) {
+	expires := time.Now().Add(workerLease())
+	result := config.Conf.DB.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND worker_id = ? AND lease_expires_at IS NOT NULL", jobID, JobRunning, worker.ID).
+		Update("lease_expires_at", expires)
+	if result.Error != nil {
+		return time.Time{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return time.Time{}, ErrLeaseLost
+	}
+	return expires, nil
+}
+
+// JobInputKey 返回节点持有租约的任务中某个输入文件的对象键。
+func JobInputKey(worker *models.Worker, jobID uint, name string) (string, error) {
+	job, err := leasedJob(worker, jobID)
+	if err != nil {
+		return "", err
+	}
+	inputs, err := remoteJobSpecs[job.Type].Inputs(job)
+	if err != nil {
+		return "", err
+	}
+	key, ok := inputs[name]
+	if !ok {
+		return "", fmt.Errorf("job %d has no input %q", jobID, name)
+	}
+	return key, nil
+}
+
+// jobArtifactKey 返回节点上传的产物在对象存储中的暂存键。
+func jobArtifactKey(jobID uint, name string) string {
+	return fmt.Sprintf("job%d/%s", jobID, name)
+}
+
+// StoreJobArtifact 暂存节点上传的产物文件，产物名须在任务类型允许的范围内。
+func StoreJobArtifact(worker *models.Worker, jobID uint, name, filePath string) error {
+	job, err := leasedJob(worker, jobID)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, artifact := range remoteJobSpecs[job.Type].Artifacts {
+		if artifact == name {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("job %d does not accept artifact %q", jobID, name)
+	}
+	return storeFile(jobArtifactKey(jobID, name), filePath)
+}
+
+// RemoteJobResult 是节点报告的任务结果
+type RemoteJobResult struct {
+	Success bool
+	Error   string
+	// 节点判断的失败分类与阶段，以及失败时的日志摘录
+	FailureClass string
+	Stage        string
+	Log          string
+	// 节点上训练子进程的资源使用
+	Usage ResourceUsage
+}
+
+// CompleteRemoteJob 处理节点报告的任务结果。
+// 成功时创建后处理任务，由服务器取回节点上传的产物并执行该类型任务的后处理，节点不必等待；
+// 此后节点不再持有租约，任务在后处理结束前保持运行状态。失败时按失败分类决定是否重试。
+func CompleteRemoteJob(worker *models.Worker, jobID uint, result RemoteJobResult) error {
+	job, err := leasedJob(worker, jobID)
+	if err != nil {
+		return err
+	}
+	spec := remoteJobSpecs[job.Type]
+	if job.WorkID != 0 && result.Usage != (ResourceUsage{}) {
+		SetWorkUsage(job.WorkID, result.Usage)
+	}
+
+	if !result.Success {
+		defer removeJobArtifacts(job, spec)
+		if result.Error == "" {
+			result.Error = "worker reported failure"
+		}
+		jobErr := &JobError{Class: result.FailureClass, Stage: result.Stage, Err: errors.New(result.Error), Log: result.Log}
+		if !validFailureClass(jobErr.Class) {
+			jobErr.Class = FailureUnknown
+		}
+		failRemoteJob(job, jobErr)
+		return nil
+	}
+
+	// 清空租约到期时间，任务不会被当作失联节点的任务放回队列，节点也不能再次报告结果
+	return config.Conf.DB.Transaction(func(tx *gorm.DB) error {
+		released := tx.Model(&models.Job{}).
+			Where("id = ? AND status = ? AND worker_id = ? AND lease_expires_at IS NOT NULL", job.ID, JobRunning, worker.ID).
+			Update("lease_expires_at", nil)
+		if released.Error != nil {
+			return released.Error
+		}
+		if released.RowsAffected == 0 {
+			return ErrLeaseLost
+		}
+		_, err := enqueueJob(tx, JobTypeRemoteComplete, job.UserID, 0, RemoteCompletePayload{JobID: job.ID}, nil)
+		return err
+	})
+}
+
+// completeRemoteJob 是后处理任务的处理函数：取回节点上传的产物并执行后处理，结果写入原任务。
+// 后处理失败时按原任务的失败处理，后处理任务本身不重试。
+func completeRemoteJob(ctx context.Context, followup *models.Job) (string, error) {
+	var payload RemoteCompletePayload
+	if err := DecodeJobPayload(followup, &payload); err != nil {
+		return "", NewJobError(FailureInvalidInput, "", err)
+	}
+	var job models.Job
+	err := config.Conf.DB.Where("id = ? AND status = ? AND worker_id <> 0 AND lease_expires_at IS NULL", payload.JobID, JobRunning).
+		First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Sprintf("skipped: job %d is not awaiting post-processing", payload.JobID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	spec := remoteJobSpecs[job.Type]
+	defer removeJobArtifacts(&job, spec)
+
+	artifacts := map[string]string{}
+	for _, name := range spec.Artifacts {
+		artifactPath, err := database.RetrieveFromBucket(jobArtifactKey(job.ID, name))
+		if err != nil {
+			// 未上传的产物由 Complete 自行判断是否必需
+			continue
+		}
+		defer os.RemoveAll(filepath.Dir(artifactPath))
+		artifacts[name] = artifactPath
+	}
+
+	output, err := runRemoteComplete(ctx, spec, &job, artifacts)
+	if err != nil {
+		failJob(&job, err)
+		return fmt.Sprintf("job %d failed: %v", job.ID, err), nil
+	}
+	recordWorkAttempt(&job, nil, nil)
+	finishJob(job.ID, JobCompleted, output, "")
+	return fmt.Sprintf("job %d completed", job.ID), nil
+}
+
+// runRemoteComplete 执行后处理，并将panic转换为错误。
+func runRemoteComplete(ctx context.Context, spec RemoteJobSpec, job *models.Job, artifacts map[string]string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return spec.Complete(ctx, job, artifacts)
+}
+
+// failRemoteJob 处理任务在节点上的失败。
+func failRemoteJob(job *models.Job, err error) {
+	if spec := remoteJobSpecs[job.Type]; spec.Fail != nil {
+		spec.Fail(job, err, JobWillRetry(job, err))
+	}
+	failJob(job, err)
+}
+
+func removeJobArtifacts(job *models.Job, spec RemoteJobSpec) {
+	for _, name := range spec.Artifacts {
+		if err := database.RemoveObject(jobArtifactKey(job.ID, name)); err != nil {
+			log.Printf("job %d: %v", job.ID, err)
+		}
+	}
+}
+
+// requeueExpiredLeases 处理租约到期（节点或本地执行的进程失联）的任务：视为一次临时失败，
+// 未超过最多尝试次数时放回队列，由其他执行方重新领取。超过整体超时的远程任务标记为超时失败。
+func requeueExpiredLeases() {
+	var jobs []models.Job
+	now := time.Now()
+	if err := config.Conf.DB.Where("status = ? AND lease_expires_at < ?", JobRunning, now).
+		Find(&jobs).Error; err != nil {
+		log.Printf("fail to find expired leases: %v", err)
+		return
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if job.WorkerID == 0 {
+			// 本地执行的任务，执行的进程已退出或长时间无法访问数据库；以租约仍已到期为条件收回，避免与续约冲突
+			result := config.Conf.DB.Model(&models.Job{}).
+				Where("id = ? AND status = ? AND worker_id = 0 AND runner = ? AND lease_expires_at < ?", job.ID, JobRunning, job.Runner, now).
+				Update("lease_expires_at", nil)
+			if result.Error != nil || result.RowsAffected == 0 {
+				continue
+			}
+			failJob(job, NewJobError(FailureTransient, "lease", fmt.Errorf("runner %s lease expired", job.Runner)))
+			continue
+		}
+		// 以乐观锁收回租约，避免与节点同时到达的心跳或结果冲突
+		result := config.Conf.DB.Model(&models.Job{}).
+			Where("id = ? AND status = ? AND worker_id = ?", job.ID, JobRunning, job.WorkerID).
+			Update("worker_id", 0)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		failRemoteJob(job, NewJobError(FailureTransient, "lease", fmt.Errorf("worker %d lease expired", job.WorkerID)))
+	}
+
+	// 远程任务的整体超时由服务器判断，节点在下一次心跳时得知失去租约并终止训练器
+	timeout := jobTimeout()
+	if timeout <= 0 {
+		return
+	}
+	jobs = nil
+	// 等待后处理的任务（租约已清空）由后处理任务结束，不计入节点上的超时
+	if err := config.Conf.DB.Where("status = ? AND worker_id <> 0 AND lease_expires_at IS NOT NULL AND started_at < ?", JobRunning, time.Now().Add(-timeout)).
+		Find(&jobs).Error; err != nil {
+		log.Printf("fail to find timed out jobs: %v", err)
+		return
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		result := config.Conf.DB.Model(&models.Job{}).
+			Where("id = ? AND status = ? AND worker_id = ?", job.ID, JobRunning, job.WorkerID).
+			Update("worker_id", 0)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		failRemoteJob(job, NewJobError(FailureTimeout, "train", fmt.Errorf("job exceeded %s: %w", timeout, context.DeadlineExceeded)))
+	}
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"myapp/config"
+	"myapp/models"
+	"myapp/testutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jobTypeRemoteEcho 是仅用于测试的远程任务：节点上传的 result.json 原样作为任务结果
+const jobTypeRemoteEcho = "test_remote_echo"
+
+// remoteEchoFailures 记录 Fail 回调收到的 retry 参数
+var remoteEchoFailures []bool
+
+func init() {
+	RegisterJobHandler(jobTypeRemoteEcho, func(ctx context.Context, job *models.Job) (string, error) {
+		return "", errors.New("runs on remote workers only")
+	})
+	RegisterRemoteJobSpec(jobTypeRemoteEcho, RemoteJobSpec{
+		Artifacts: []string{"result.json"},
+		Inputs: func(job *models.Job) (map[string]string, error) {
+			return map[string]string{"input.json": "input.json"}, nil
+		},
+		Complete: func(ctx context.Context, job *models.Job, artifacts map[string]string) (string, error) {
+			path, ok := artifacts["result.json"]
+			if !ok {
+				return "", errors.New("result.json was not uploaded")
+			}
+			data, err := os.ReadFile(path)
+			return string(data), err
+		},
+		Fail: func(job *models.Job, err error, retry bool) {
+			remoteEchoFailures = append(remoteEchoFailures, retry)
+		},
+	})
+}
+
+// setupRemoteEcho 准备数据库与两个节点，并创建一个排队的测试任务
+func setupRemoteEcho(t *testing.T) (*testutil.Bucket, *models.Job, *models.Worker, *models.Worker) {
+	t.Helper()
+	bucket := testutil.Setup(t)
+	config.Conf.JobMaxAttempts = 2
+	config.Conf.WorkerLeaseSeconds = 60
+	remoteEchoFailures = nil
+	var workers [2]*models.Worker
+	for i, name := range []string{"gpu-a", "gpu-b"} {
+		worker := &models.Worker{Name: name, TokenHash: name, JobTypes: jobTypeRemoteEcho}
+		if err := config.Conf.DB.Create(worker).Error; err != nil {
+			t.Fatal(err)
+		}
+		workers[i] = worker
+	}
+	job, err := EnqueueJob(jobTypeRemoteEcho, 1, 0, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bucket, job, workers[0], workers[1]
+}
+
+func writeArtifact(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRemoteJobLifecycle(t *testing.T) {
+	bucket, queued, owner, other := setupRemoteEcho(t)
+
+	leased, err := LeaseJob(owner)
+	if err != nil || leased == nil || leased.Job.ID != queued.ID {
+		t.Fatalf("lease: %+v, %v", leased, err)
+	}
+	if len(leased.Inputs) != 1 || leased.Inputs[0] != "input.json" || time.Until(leased.LeaseExpiresAt) < 59*time.Second {
+		t.Errorf("leased job: inputs %v, lease until %s", leased.Inputs, leased.LeaseExpiresAt)
+	}
+
+	// 只有持有租约的节点可以续约与上传产物，且只能上传任务类型允许的产物
+	config.Conf.DB.Model(&models.Job{}).Where("id = ?", queued.ID).Update("lease_expires_at", time.Now().Add(time.Second))
+	expires, err := HeartbeatJob(owner, queued.ID)
+	if err != nil || time.Until(expires) < 59*time.Second {
+		t.Errorf("heartbeat extended the lease to %s, err = %v", expires, err)
+	}
+	if _, err := HeartbeatJob(other, queued.ID); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("heartbeat from another worker: %v", err)
+	}
+	result := writeArtifact(t, `{"psnr":31.2}`)
+	if err := StoreJobArtifact(other, queued.ID, "result.json", result); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("artifact from another worker: %v", err)
+	}
+	if err := StoreJobArtifact(owner, queued.ID, "model.ckpt", result); err == nil {
+		t.Error("accepted an artifact the job type does not produce")
+	}
+	if err := StoreJobArtifact(owner, queued.ID, "result.json", result); err != nil {
+		t.Fatal(err)
+	}
+
+	// 报告成功后节点不再持有租约，后处理由服务器上的后续任务完成
+	if err := CompleteRemoteJob(owner, queued.ID, RemoteJobResult{Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HeartbeatJob(owner, queued.ID); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("heartbeat after reporting success: %v", err)
+	}
+	if err := CompleteRemoteJob(owner, queued.ID, RemoteJobResult{Error: "late failure"}); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("second report accepted: %v", err)
+	}
+	requeueExpiredLeases()
+	if job := reloadJob(t, queued.ID); job.Status != JobRunning || job.LeaseExpiresAt != nil {
+		t.Fatalf("job awaiting post-processing: status %s, lease %v", job.Status, job.LeaseExpiresAt)
+	}
+
+	var followup models.Job
+	if err := config.Conf.DB.Where("type = ?", JobTypeRemoteComplete).First(&followup).Error; err != nil {
+		t.Fatalf("no post-processing job: %v", err)
+	}
+	if _, err := completeRemoteJob(context.Background(), &followup); err != nil {
+		t.Fatal(err)
+	}
+	job := reloadJob(t, queued.ID)
+	if job.Status != JobCompleted || job.Result != `{"psnr":31.2}` {
+		t.Errorf("after post-processing: status %s, result %q", job.Status, job.Result)
+	}
+	if _, ok := bucket.Get(jobArtifactKey(queued.ID, "result.json")); ok {
+		t.Error("artifact left in the bucket after post-processing")
+	}
+	// 重复执行的后续任务不会再次完成原任务
+	if summary, err := completeRemoteJob(context.Background(), &followup); err != nil || !strings.HasPrefix(summary, "skipped") {
+		t.Errorf("repeated post-processing: %q, %v", summary, err)
+	}
+}
+
+func TestRemoteJobLeaseExpiry(t *testing.T) {
+	_, queued, owner, _ := setupRemoteEcho(t)
+	if leased, err := LeaseJob(owner); err != nil || leased == nil {
+		t.Fatalf("lease: %+v, %v", leased, err)
+	}
+	result := writeArtifact(t, "{}")
+
+	// 节点失联：租约到期后任务放回队列，计为一次可重试的失败
+	config.Conf.DB.Model(&models.Job{}).Where("id = ?", queued.ID).Update("lease_expires_at", time.Now().Add(-time.Second))
+	requeueExpiredLeases()
+	job := reloadJob(t, queued.ID)
+	if job.Status != JobQueued || job.WorkerID != 0 || job.FailureClass != FailureTransient {
+		t.Fatalf("after lease expiry: status %s, worker %d, class %s", job.Status, job.WorkerID, job.FailureClass)
+	}
+	if len(remoteEchoFailures) != 1 || !remoteEchoFailures[0] {
+		t.Errorf("Fail callback calls: %v, want one with retry", remoteEchoFailures)
+	}
+
+	// 恢复连接的节点不能再续约、上传或报告结果
+	if _, err := HeartbeatJob(owner, queued.ID); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("heartbeat after expiry: %v", err)
+	}
+	if err := StoreJobArtifact(owner, queued.ID, "result.json", result); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("artifact after expiry: %v", err)
+	}
+	if err := CompleteRemoteJob(owner, queued.ID, RemoteJobResult{Success: true}); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("result after expiry: %v", err)
+	}
+	var followups int64
+	config.Conf.DB.Model(&models.Job{}).Where("type = ?", JobTypeRemoteComplete).Count(&followups)
+	if followups != 0 {
+		t.Errorf("%d post-processing jobs created for a lost lease", followups)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"myapp/services"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runStorageGC 执行 storage-gc 子命令：核对存储桶与数据库并输出报告。
+// 默认只报告差异，-delete 时删除孤立对象与过期的临时目录，并补全视频与作品版本缺少的大小。
+//
+//	myapp storage-gc [-delete] [-json]
+func runStorageGC(args []string) error {
+	flags := flag.NewFlagSet("storage-gc", flag.ExitOnError)
+	del := flags.Bool("delete", false, "删除孤立对象与过期的临时目录，补全缺少的大小")
+	asJSON := flags.Bool("json", false, "以JSON格式输出报告")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	report, err := services.ReconcileStorage(ctx, *del)
+	if err != nil {
+		return fmt.Errorf("fail to reconcile storage: %w", err)
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	fmt.Print(services.FormatStorageReport(report))
+	return nil
+}
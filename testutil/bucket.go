@@ -0,0 +1,277 @@
+package testutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucketName 是测试使用的存储桶名称
+const bucketName = "test"
+
+// Bucket 是只实现了本项目用到的S3接口（上传、下载、查询、删除与列出对象）的内存存储桶
+type Bucket struct {
+	mu      sync.Mutex
+	objects map[string]*bucketObject
+}
+
+type bucketObject struct {
+	data     []byte
+	header   http.Header // Content-Type 与 X-Amz-Meta-* 元数据
+	modified time.Time
+}
+
+func newBucket() *Bucket {
+	return &Bucket{objects: map[string]*bucketObject{}}
+}
+
+// Put 直接写入对象内容，不经过应用的上传流程，也不产生校验和记录
+func (b *Bucket) Put(key string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = &bucketObject{data: append([]byte{}, data...), header: http.Header{}, modified: time.Now()}
+}
+
+// Get 返回存储桶中对象的原始内容（加密的对象为密文）
+func (b *Bucket) Get(key string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, obj.data...), true
+}
+
+// Snapshot 保存对象当前的内容与元数据，返回将对象恢复到此时状态的函数，用于模拟未完成的上传
+func (b *Bucket) Snapshot(key string) (restore func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.objects[key]
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if ok {
+			b.objects[key] = obj
+		} else {
+			delete(b.objects, key)
+		}
+	}
+}
+
+// Keys 按字典序返回全部对象键
+func (b *Bucket) Keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *Bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != bucketName {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Has("location"):
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+	case key == "" && r.Method == http.MethodGet:
+		b.list(w, r.URL.Query().Get("prefix"))
+	case key == "":
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	case r.Method == http.MethodPut:
+		b.put(w, r, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		b.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		b.mu.Lock()
+		delete(b.objects, key)
+		b.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (b *Bucket) put(w http.ResponseWriter, r *http.Request, key string) {
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = &awsChunkedReader{r: bufio.NewReader(r.Body)}
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	header := http.Header{}
+	for name, values := range r.Header {
+		if name == "Content-Type" || strings.HasPrefix(name, "X-Amz-Meta-") {
+			header[name] = values
+		}
+	}
+	b.mu.Lock()
+	b.objects[key] = &bucketObject{data: data, header: header, modified: time.Now()}
+	b.mu.Unlock()
+	w.Header().Set("ETag", etag(data))
+}
+
+func (b *Bucket) get(w http.ResponseWriter, r *http.Request, key string) {
+	b.mu.Lock()
+	obj, ok := b.objects[key]
+	b.mu.Unlock()
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	for name, values := range obj.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("ETag", etag(obj.data))
+	w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	data, status := obj.data, http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" {
+		start, end, ok := parseRange(spec, len(data))
+		if !ok {
+			writeS3Error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data, status = data[start:end+1], http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// parseRange 解析 "bytes=a-b"、"bytes=a-" 与 "bytes=-n" 形式的范围
+func parseRange(spec string, size int) (int, int, bool) {
+	first, last, ok := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if first == "" {
+		n, err := strconv.Atoi(last)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, size > 0
+	}
+	start, err := strconv.Atoi(first)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.Atoi(last); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+func (b *Bucket) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucketName, Prefix: prefix, MaxKeys: 1000}
+
+	b.mu.Lock()
+	for key, obj := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: obj.modified.UTC().Format(time.RFC3339Nano),
+				ETag:         etag(obj.data),
+				Size:         len(obj.data),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	b.mu.Unlock()
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, len(data))
+}
+
+// awsChunkedReader 解码以 aws-chunked 编码上传的请求体：每块为 "长度[;签名]\r\n内容\r\n"，以长度为0的块结束
+type awsChunkedReader struct {
+	r         *bufio.Reader
+	remaining int
+	done      bool
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid aws-chunked size %q", size)
+		}
+		if n == 0 {
+			// 之后可能还有附加的校验和，不需要
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = int(n)
+	}
+	n, err := c.r.Read(p[:min(len(p), c.remaining)])
+	c.remaining -= n
+	if c.remaining == 0 && err == nil {
+		// 跳过块末尾的 \r\n
+		var crlf [2]byte
+		if _, err := io.ReadFull(c.r, crlf[:]); err != nil || !bytes.Equal(crlf[:], []byte("\r\n")) {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
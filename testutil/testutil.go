@@ -0,0 +1,68 @@
+// Package testutil 为测试提供临时的SQLite数据库与内存中的对象存储，替代 config.LoadConfig 连接的MySQL与MinIO。
+package testutil
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"myapp/config"
+
+	"github.com/glebarez/sqlite"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Setup 将 config.Conf 的数据库与对象存储替换为仅供本测试使用的实例，测试结束后恢复。
+// 工作目录切换到临时目录，取回对象时生成的 temp 目录随测试删除。
+// 返回的 Bucket 可直接读写对象，用于模拟存储中的损坏或残留。
+func Setup(t *testing.T) *Bucket {
+	t.Helper()
+	previous := config.Conf
+	t.Cleanup(func() { config.Conf = previous })
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := config.Migrate(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	bucket := newBucket()
+	server := httptest.NewServer(bucket)
+	t.Cleanup(server.Close)
+	endpoint, _ := url.Parse(server.URL)
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4("test", "test-secret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatalf("connect test bucket: %v", err)
+	}
+
+	config.Conf.DB = db
+	config.Conf.MINIO = client
+	config.Conf.BucketName = bucketName
+	config.Conf.StorageKeys = nil
+	config.Conf.StorageKeyID = ""
+	return bucket
+}